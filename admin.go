@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"stori-technical-challenge/config"
+	"strings"
+)
+
+// defaultAdminAddr keeps the admin API on loopback unless told otherwise.
+const defaultAdminAddr = "127.0.0.1:9091"
+
+// adminHandler serves the APIs that manage who receives statements and
+// export their transactions. They run on their own listener, apart from
+// /metrics: whoever can add a recipient can have customers' statements sent
+// to them, and the exports are the statements themselves. With a token,
+// every request must carry it as "Authorization: Bearer <token>".
+func adminHandler(token config.Secret) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/recipients", recipientsHandler())
+	mux.Handle("/export/", exportHandler())
+	if token == "" {
+		return mux
+	}
+	return requireToken(token, mux)
+}
+
+func requireToken(token config.Secret, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token.Reveal())) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="stori"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readAdminToken reads the token from path, or returns an empty token when
+// path is empty.
+func readAdminToken(path string) (config.Secret, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading admin token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("admin token file %s is empty", path)
+	}
+	return config.Secret(token), nil
+}
+
+// checkAdminAddr refuses to serve the admin API beyond loopback without a
+// token.
+func checkAdminAddr(addr string, token config.Secret) error {
+	if token != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid admin address %q: %w", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("the admin API on %s would be reachable from other hosts: set ADMIN_TOKEN_FILE or bind it to 127.0.0.1", addr)
+}
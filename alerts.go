@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/db"
+	"stori-technical-challenge/pkg/outbox"
+	"stori-technical-challenge/pkg/pipeline"
+)
+
+// alertMessages returns the outbox messages that report the statement's
+// unusual activity: an email for alert_email and a call to alert_webhook.
+// They are imported with the statement and keyed by it and the account
+// like the summary, so each account's statement alerts once, and the
+// dispatcher retries them until they go through.
+func alertMessages(statement []byte, report *pipeline.Report) ([]db.OutboxMessage, error) {
+	if report.Alert == nil {
+		return nil, nil
+	}
+	account := config.AppConfig.Account
+	var messages []db.OutboxMessage
+	if to := config.AppConfig.AlertEmail; to != "" {
+		messages = append(messages, db.OutboxMessage{
+			DedupKey: outbox.StatementKey(statement, "alert:"+account+":"+to),
+			To:       []string{to},
+			Subject:  pipeline.AlertSubject,
+			Body:     report.AlertBody,
+		})
+	}
+	if url := config.AppConfig.AlertWebhook; url != "" {
+		notice, err := json.Marshal(report.Alert)
+		if err != nil {
+			return nil, fmt.Errorf("encoding alert: %w", err)
+		}
+		messages = append(messages, db.OutboxMessage{
+			DedupKey:   outbox.StatementKey(statement, "webhook:"+account+":"+url),
+			Subject:    pipeline.AlertSubject,
+			Body:       string(notice),
+			WebhookURL: url,
+		})
+	}
+	return messages, nil
+}
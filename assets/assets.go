@@ -0,0 +1,9 @@
+// Package assets embeds the static files shipped with the binary.
+package assets
+
+import _ "embed"
+
+// Logo is the Stori logo, as a PNG.
+//
+//go:embed Stori_Logo_2023-min.png
+var Logo []byte
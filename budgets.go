@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/budget"
+	"stori-technical-challenge/pkg/db"
+	"strconv"
+)
+
+const budgetsUsage = "usage: %s budgets set [-account a] [-category c | -min-balance] AMOUNT" +
+	" | list [-account a] | remove [-account a] [-category c | -min-balance]"
+
+// runBudgetsCommand implements "budgets", which manages each account's
+// monthly spending budgets and low balance threshold. "list" prints budgets
+// as JSON, one per line.
+func runBudgetsCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf(budgetsUsage, os.Args[0])
+	}
+	command := args[0]
+	switch command {
+	case "set", "list", "remove":
+	default:
+		return fmt.Errorf(budgetsUsage, os.Args[0])
+	}
+
+	account := os.Getenv("ACCOUNT")
+	if account == "" {
+		account = config.DefaultAccount
+	}
+	fs := flag.NewFlagSet("budgets "+command, flag.ExitOnError)
+	fs.StringVar(&account, "account", account, "account the budget belongs to (overrides $ACCOUNT)")
+	category := fs.String("category", "", "limit the spending budget to one category (default all spending)")
+	minBalance := fs.Bool("min-balance", false, "the low balance threshold instead of a spending budget")
+	fs.Parse(args[1:])
+
+	kind := budget.Spending
+	if *minBalance {
+		if *category != "" {
+			return fmt.Errorf("-category and -min-balance can't be used together")
+		}
+		kind = budget.MinBalance
+	}
+
+	if err := db.InitDB(); err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	ctx := context.Background()
+
+	switch command {
+	case "list":
+		// An explicit -account lists that account; otherwise list them all
+		listed := ""
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "account" {
+				listed = account
+			}
+		})
+		budgets, err := db.GetBudgets(ctx, listed)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(out)
+		for _, b := range budgets {
+			if err := enc.Encode(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "remove":
+		if fs.NArg() != 0 {
+			return fmt.Errorf(budgetsUsage, os.Args[0])
+		}
+		err := db.DeleteBudget(ctx, account, kind, *category)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("account %q has no such budget", account)
+		}
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf(budgetsUsage, os.Args[0])
+	}
+	amount, err := strconv.ParseFloat(fs.Arg(0), 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %q", fs.Arg(0))
+	}
+	return db.SaveBudget(ctx, budget.Budget{Account: account, Kind: kind, Category: *category, Amount: amount})
+}
@@ -1,63 +1,324 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
+// ConfigFileEnv names the environment variable that points to a YAML config file.
+const ConfigFileEnv = "CONFIG_FILE"
+
+// SecretsDirEnv names the environment variable that points to a directory of
+// secret files, one per secret (e.g. /run/secrets).
+const SecretsDirEnv = "SECRETS_DIR"
+
 type Config struct {
-	SMTPHost     string
-	SMTPPort     int
-	SMTPUser     string
-	SMTPPassword string
-	FromEmail    string
-	ToEmail      string
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port"`
+	SMTPUser     string `yaml:"smtp_user"`
+	SMTPPassword Secret `yaml:"smtp_password"`
+	// SMTPPasswordFile is read into SMTPPassword by Load. Prefer it over
+	// SMTPPassword so the password never lives in the environment or config.
+	SMTPPasswordFile string `yaml:"smtp_password_file"`
+	FromEmail        string `yaml:"from_email"`
+	// ToEmail receives the summary when Account has no recipients.
+	ToEmail string `yaml:"to_email"`
+	// Account selects whose recipients the summary is sent to. Defaults to
+	// "default".
+	Account string `yaml:"account"`
+	// SMTPRateLimit caps messages per second sent over pooled connections.
+	// Zero means no limit.
+	SMTPRateLimit float64 `yaml:"smtp_rate_limit"`
+	// SMTPMaxPerConnection is the number of messages sent over one pooled
+	// connection before it is replaced. Zero means no limit.
+	SMTPMaxPerConnection int `yaml:"smtp_max_per_connection"`
+	// CategoryRules is a YAML file of categorization rules. When empty the
+	// built-in rules are used.
+	CategoryRules string `yaml:"category_rules"`
+	// AlertEmail receives the unusual activity found in imported
+	// statements, e.g. the fraud team's address. Empty sends no alert email.
+	AlertEmail string `yaml:"alert_email"`
+	// AlertWebhook is posted the unusual activity as JSON. Empty posts
+	// nothing.
+	AlertWebhook string `yaml:"alert_webhook"`
 }
 
 var AppConfig Config
 
+// DefaultAccount is the account used when none is configured.
+const DefaultAccount = "default"
+
+// field describes a single setting and how it is overridden from the
+// environment and the command line.
+type field struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var fields = []field{
+	{"SMTP_HOST", "smtp-host", "SMTP server host", func(c *Config, v string) error { c.SMTPHost = v; return nil }},
+	{"SMTP_PORT", "smtp-port", "SMTP server port", func(c *Config, v string) error {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		c.SMTPPort = port
+		return nil
+	}},
+	{"SMTP_USER", "smtp-user", "SMTP username", func(c *Config, v string) error { c.SMTPUser = v; return nil }},
+	{"SMTP_PASSWORD", "smtp-password", "SMTP password", func(c *Config, v string) error { c.SMTPPassword = Secret(v); return nil }},
+	{"SMTP_PASSWORD_FILE", "smtp-password-file", "file containing the SMTP password", func(c *Config, v string) error { c.SMTPPasswordFile = v; return nil }},
+	{"FROM_EMAIL", "from-email", "sender address", func(c *Config, v string) error { c.FromEmail = v; return nil }},
+	{"TO_EMAIL", "to-email", "recipient address", func(c *Config, v string) error { c.ToEmail = v; return nil }},
+	{"ACCOUNT", "account", "account whose recipients get the summary", func(c *Config, v string) error { c.Account = v; return nil }},
+	{"SMTP_RATE_LIMIT", "smtp-rate-limit", "maximum messages per second (0 for no limit)", func(c *Config, v string) error {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		c.SMTPRateLimit = rate
+		return nil
+	}},
+	{"SMTP_MAX_PER_CONNECTION", "smtp-max-per-connection", "messages per SMTP connection before reconnecting (0 for no limit)", func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		c.SMTPMaxPerConnection = n
+		return nil
+	}},
+	{"CATEGORY_RULES", "category-rules", "YAML file of transaction categorization rules", func(c *Config, v string) error { c.CategoryRules = v; return nil }},
+	{"ALERT_EMAIL", "alert-email", "address that receives unusual activity alerts", func(c *Config, v string) error { c.AlertEmail = v; return nil }},
+	{"ALERT_WEBHOOK", "alert-webhook", "URL that unusual activity alerts are posted to", func(c *Config, v string) error { c.AlertWebhook = v; return nil }},
+}
+
+// Flags holds the command-line overrides bound to a flag.FlagSet.
+type Flags struct {
+	file   string
+	values map[string]*string
+}
+
+// BindFlags registers -config and one flag per setting on fs. The returned
+// Flags is read by Load after fs.Parse has run.
+func BindFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{values: make(map[string]*string)}
+	fs.StringVar(&f.file, "config", "", "path to a YAML config file (overrides $"+ConfigFileEnv+")")
+	for _, fd := range fields {
+		f.values[fd.flag] = fs.String(fd.flag, "", fd.usage+" (overrides $"+fd.env+")")
+	}
+	return f
+}
+
+// Options controls where Load reads settings from.
+type Options struct {
+	// File is the YAML config file. When empty, $CONFIG_FILE is used and, if
+	// that is unset too, no file is read.
+	File string
+	// Flags are command-line overrides; they take precedence over everything else.
+	Flags *Flags
+	// Getenv looks up environment variables. Defaults to os.Getenv.
+	Getenv func(string) string
+	// Secrets are consulted in order for secrets that are still unset after
+	// the file, environment and flags. Defaults to a FileProvider over
+	// $SECRETS_DIR when that variable is set.
+	Secrets []SecretProvider
+	// RecipientOptional skips the to_email requirement for callers that get
+	// the recipient elsewhere, such as the recipients table or the Lambda
+	// handler's request payload.
+	RecipientOptional bool
+}
+
+// ValidationError lists every problem found while loading a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load builds a Config from defaults, the config file, environment variables
+// and flags, in increasing order of precedence, and validates the result.
+// The returned Config is populated even when validation fails so callers can
+// report what was loaded.
+func Load(opts Options) (Config, error) {
+	getenv := opts.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+
+	cfg := Config{SMTPPort: 587, Account: DefaultAccount}
+	var problems []string
+
+	path := opts.File
+	if opts.Flags != nil && opts.Flags.file != "" {
+		path = opts.Flags.file
+	}
+	if path == "" {
+		path = getenv(ConfigFileEnv)
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	for _, fd := range fields {
+		if v := getenv(fd.env); v != "" {
+			if err := fd.set(&cfg, v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", fd.env, err))
+			}
+		}
+	}
+
+	if opts.Flags != nil {
+		for _, fd := range fields {
+			if v := *opts.Flags.values[fd.flag]; v != "" {
+				if err := fd.set(&cfg, v); err != nil {
+					problems = append(problems, fmt.Sprintf("-%s: %v", fd.flag, err))
+				}
+			}
+		}
+	}
+
+	secrets := opts.Secrets
+	if secrets == nil && getenv(SecretsDirEnv) != "" {
+		secrets = []SecretProvider{FileProvider{Dir: getenv(SecretsDirEnv)}}
+	}
+	problems = append(problems, resolveSMTPPassword(&cfg, secrets)...)
+
+	problems = append(problems, cfg.problems(!opts.RecipientOptional)...)
+	if len(problems) > 0 {
+		return cfg, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// LoadConfig loads the configuration from $CONFIG_FILE and the environment
+// into AppConfig.
 func LoadConfig() error {
-	AppConfig = Config{
-		SMTPHost:     getEnv("SMTP_HOST", "smtp.example.com"),
-		SMTPPort:     getEnvAsInt("SMTP_PORT", 587),
-		SMTPUser:     getEnv("SMTP_USER", "user@example.com"),
-		SMTPPassword: getEnv("SMTP_PASSWORD", "password"),
-		FromEmail:    getEnv("FROM_EMAIL", "from@example.com"),
-		ToEmail:      getEnv("TO_EMAIL", "to@example.com"),
+	cfg, err := Load(Options{})
+	if err != nil {
+		return err
 	}
+	AppConfig = cfg
+	return nil
+}
 
-	// Validaciones adicionales
-	if AppConfig.SMTPHost == "" {
-		return fmt.Errorf("SMTP_HOST is required")
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
 	}
-	if AppConfig.FromEmail == "" {
-		return fmt.Errorf("FROM_EMAIL is required")
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
 	}
-	if AppConfig.ToEmail == "" {
-		return fmt.Errorf("TO_EMAIL is required")
+	return nil
+}
+
+// resolveSMTPPassword fills SMTPPassword from SMTPPasswordFile or, failing
+// that, from the secret providers.
+func resolveSMTPPassword(cfg *Config, providers []SecretProvider) []string {
+	if cfg.SMTPPasswordFile != "" {
+		if cfg.SMTPPassword != "" {
+			return []string{"smtp_password and smtp_password_file are mutually exclusive"}
+		}
+		password, err := readSecretFile(cfg.SMTPPasswordFile)
+		if err != nil {
+			return []string{fmt.Sprintf("smtp_password_file: %v", err)}
+		}
+		cfg.SMTPPassword = password
+		return nil
 	}
 
+	for _, provider := range providers {
+		if cfg.SMTPPassword != "" {
+			break
+		}
+		password, ok, err := provider.Lookup("smtp_password")
+		if err != nil {
+			return []string{fmt.Sprintf("smtp_password: %v", err)}
+		}
+		if ok {
+			cfg.SMTPPassword = password
+		}
+	}
 	return nil
 }
 
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// Validate reports every problem with c as a *ValidationError.
+func (c Config) Validate() error {
+	if problems := c.problems(true); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
 	}
-	return value
+	return nil
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
-	valueStr := os.Getenv(key)
-	if valueStr == "" {
-		return defaultValue
+func (c Config) problems(requireRecipient bool) []string {
+	var problems []string
+
+	if c.SMTPHost == "" {
+		problems = append(problems, "smtp_host is required (SMTP_HOST)")
+	}
+	if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+		problems = append(problems, fmt.Sprintf("smtp_port must be between 1 and 65535, got %d", c.SMTPPort))
+	}
+	if c.SMTPUser != "" && c.SMTPPassword == "" {
+		problems = append(problems, "smtp_password is required when smtp_user is set (SMTP_PASSWORD or SMTP_PASSWORD_FILE)")
+	}
+	if c.SMTPRateLimit < 0 {
+		problems = append(problems, fmt.Sprintf("smtp_rate_limit must not be negative, got %g", c.SMTPRateLimit))
+	}
+	if c.SMTPMaxPerConnection < 0 {
+		problems = append(problems, fmt.Sprintf("smtp_max_per_connection must not be negative, got %d", c.SMTPMaxPerConnection))
+	}
+	problems = append(problems, checkAddress("from_email", "FROM_EMAIL", c.FromEmail)...)
+	if requireRecipient || c.ToEmail != "" {
+		problems = append(problems, checkAddress("to_email", "TO_EMAIL", c.ToEmail)...)
+	}
+	if c.AlertEmail != "" {
+		problems = append(problems, checkAddress("alert_email", "ALERT_EMAIL", c.AlertEmail)...)
+	}
+	if c.AlertWebhook != "" {
+		if u, err := url.Parse(c.AlertWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("alert_webhook %q is not an http(s) URL", c.AlertWebhook))
+		}
 	}
 
-	value, err := strconv.Atoi(valueStr)
-	if err != nil {
-		return defaultValue
+	return problems
+}
+
+func checkAddress(name, env, value string) []string {
+	if value == "" {
+		return []string{fmt.Sprintf("%s is required (%s)", name, env)}
+	}
+	if _, err := mail.ParseAddress(value); err != nil {
+		return []string{fmt.Sprintf("%s %q is not a valid email address", name, value)}
+	}
+	return nil
+}
+
+// Print writes the effective configuration as YAML. Secrets are redacted.
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
 	}
-	return value
+	return enc.Close()
 }
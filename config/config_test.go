@@ -0,0 +1,199 @@
+package config_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"stori-technical-challenge/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envFrom(values map[string]string) func(string) string {
+	return func(key string) string { return values[key] }
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+smtp_host: file.example.com
+smtp_port: 2525
+from_email: file@example.com
+to_email: file-to@example.com
+`)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := config.BindFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-to-email", "flag@example.com"}))
+
+	cfg, err := config.Load(config.Options{
+		File:   path,
+		Flags:  flags,
+		Getenv: envFrom(map[string]string{"SMTP_HOST": "env.example.com", "TO_EMAIL": "env@example.com"}),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "env.example.com", cfg.SMTPHost, "env should override file")
+	assert.Equal(t, 2525, cfg.SMTPPort, "file should override default")
+	assert.Equal(t, "file@example.com", cfg.FromEmail)
+	assert.Equal(t, "flag@example.com", cfg.ToEmail, "flag should override env")
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	_, err := config.Load(config.Options{
+		Getenv: envFrom(map[string]string{"SMTP_PORT": "abc", "SMTP_USER": "user", "TO_EMAIL": "not-an-address"}),
+	})
+
+	var validationErr *config.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.ElementsMatch(t, []string{
+		`SMTP_PORT: invalid integer "abc"`,
+		"smtp_host is required (SMTP_HOST)",
+		"smtp_password is required when smtp_user is set (SMTP_PASSWORD or SMTP_PASSWORD_FILE)",
+		"from_email is required (FROM_EMAIL)",
+		`to_email "not-an-address" is not a valid email address`,
+	}, validationErr.Problems)
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	path := writeConfigFile(t, "smtp_hots: typo.example.com\n")
+
+	_, err := config.Load(config.Options{File: path, Getenv: envFrom(nil)})
+	assert.ErrorContains(t, err, "smtp_hots")
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	cfg := config.Config{SMTPHost: "localhost", SMTPPort: 25, SMTPUser: "user", SMTPPassword: "hunter2"}
+
+	var out bytes.Buffer
+	require.NoError(t, cfg.Print(&out))
+
+	assert.NotContains(t, out.String(), "hunter2")
+	assert.Contains(t, out.String(), "smtp_password: '[REDACTED]'")
+}
+
+func TestSecretNeverFormatsPlaintext(t *testing.T) {
+	cfg := config.Config{SMTPUser: "user", SMTPPassword: "hunter2"}
+
+	jsonOut, err := json.Marshal(cfg)
+	require.NoError(t, err)
+
+	var logOut bytes.Buffer
+	slog.New(slog.NewJSONHandler(&logOut, nil)).Info("loaded", "password", cfg.SMTPPassword, "config", cfg)
+
+	for _, out := range []string{
+		fmt.Sprintf("%v", cfg),
+		fmt.Sprintf("%+v", cfg),
+		fmt.Sprintf("%#v", cfg),
+		fmt.Sprint(cfg.SMTPPassword),
+		string(jsonOut),
+		logOut.String(),
+	} {
+		assert.NotContains(t, out, "hunter2")
+	}
+	assert.Equal(t, "hunter2", cfg.SMTPPassword.Reveal())
+}
+
+func TestLoadReadsPasswordFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtp_password")
+	require.NoError(t, os.WriteFile(path, []byte("s3cret\n"), 0o600))
+
+	cfg, err := config.Load(config.Options{Getenv: envFrom(map[string]string{
+		"SMTP_HOST":          "localhost",
+		"SMTP_USER":          "user",
+		"SMTP_PASSWORD_FILE": path,
+		"FROM_EMAIL":         "from@example.com",
+		"TO_EMAIL":           "to@example.com",
+	})})
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", cfg.SMTPPassword.Reveal())
+}
+
+func TestLoadReadsPasswordFromSecretsDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "smtp_password"), []byte("mounted"), 0o600))
+
+	cfg, err := config.Load(config.Options{Getenv: envFrom(map[string]string{
+		"SMTP_HOST":   "localhost",
+		"SMTP_USER":   "user",
+		"SECRETS_DIR": dir,
+		"FROM_EMAIL":  "from@example.com",
+		"TO_EMAIL":    "to@example.com",
+	})})
+	require.NoError(t, err)
+	assert.Equal(t, "mounted", cfg.SMTPPassword.Reveal())
+}
+
+type staticProvider map[string]config.Secret
+
+func (p staticProvider) Lookup(name string) (config.Secret, bool, error) {
+	value, ok := p[name]
+	return value, ok, nil
+}
+
+func TestLoadUsesSecretProviders(t *testing.T) {
+	cfg, err := config.Load(config.Options{
+		Getenv: envFrom(map[string]string{
+			"SMTP_HOST":  "localhost",
+			"SMTP_USER":  "user",
+			"FROM_EMAIL": "from@example.com",
+			"TO_EMAIL":   "to@example.com",
+		}),
+		Secrets: []config.SecretProvider{staticProvider{}, staticProvider{"smtp_password": "from-vault"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "from-vault", cfg.SMTPPassword.Reveal())
+}
+
+func TestLoadSMTPLimits(t *testing.T) {
+	env := map[string]string{
+		"SMTP_HOST":               "localhost",
+		"FROM_EMAIL":              "from@example.com",
+		"TO_EMAIL":                "to@example.com",
+		"SMTP_RATE_LIMIT":         "2.5",
+		"SMTP_MAX_PER_CONNECTION": "50",
+	}
+	cfg, err := config.Load(config.Options{Getenv: envFrom(env)})
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, cfg.SMTPRateLimit)
+	assert.Equal(t, 50, cfg.SMTPMaxPerConnection)
+
+	env["SMTP_RATE_LIMIT"] = "-1"
+	_, err = config.Load(config.Options{Getenv: envFrom(env)})
+	assert.ErrorContains(t, err, "smtp_rate_limit must not be negative")
+}
+
+func TestLoadAlertDestinations(t *testing.T) {
+	env := map[string]string{
+		"SMTP_HOST":     "localhost",
+		"FROM_EMAIL":    "from@example.com",
+		"TO_EMAIL":      "to@example.com",
+		"ALERT_EMAIL":   "fraud@example.com",
+		"ALERT_WEBHOOK": "https://alerts.example.com/stori",
+	}
+	cfg, err := config.Load(config.Options{Getenv: envFrom(env)})
+	require.NoError(t, err)
+	assert.Equal(t, "fraud@example.com", cfg.AlertEmail)
+	assert.Equal(t, "https://alerts.example.com/stori", cfg.AlertWebhook)
+
+	env["ALERT_EMAIL"] = "fraud"
+	env["ALERT_WEBHOOK"] = "alerts.example.com"
+	_, err = config.Load(config.Options{Getenv: envFrom(env)})
+	var validationErr *config.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []string{
+		`alert_email "fraud" is not a valid email address`,
+		`alert_webhook "alerts.example.com" is not an http(s) URL`,
+	}, validationErr.Problems)
+}
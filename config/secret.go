@@ -0,0 +1,99 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Secret holds a sensitive value such as a password. Every way of formatting
+// or serializing it (fmt verbs, JSON, YAML, slog) prints a placeholder; the
+// plaintext is only available through Reveal.
+type Secret string
+
+// Reveal returns the plaintext value.
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return fmt.Sprintf("config.Secret(%q)", s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// SecretProvider looks up secrets by name, e.g. "smtp_password". Lookup
+// returns ok=false when the provider does not hold the secret.
+type SecretProvider interface {
+	Lookup(name string) (value Secret, ok bool, err error)
+}
+
+// EnvProvider reads secrets from environment variables named after the
+// upper-cased secret name.
+type EnvProvider struct {
+	Getenv func(string) string
+}
+
+func (p EnvProvider) Lookup(name string) (Secret, bool, error) {
+	getenv := p.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	value := getenv(strings.ToUpper(name))
+	return Secret(value), value != "", nil
+}
+
+// FileProvider reads secrets from one file per secret in Dir, the layout
+// used by Docker and Kubernetes secret mounts (e.g. /run/secrets/smtp_password).
+type FileProvider struct {
+	Dir string
+}
+
+func (p FileProvider) Lookup(name string) (Secret, bool, error) {
+	value, err := readSecretFile(filepath.Join(p.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// readSecretFile returns the contents of path without the trailing newline
+// that editors and `echo` usually leave behind.
+func readSecretFile(path string) (Secret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return Secret(strings.TrimRight(string(data), "\r\n")), nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/db"
+	"stori-technical-challenge/pkg/export"
+	"stori-technical-challenge/pkg/transactions"
+	"strings"
+	"time"
+)
+
+const exportUsage = "usage: %s export summary [-format json|csv|xlsx] [-o file] [file]" +
+	" | transactions [-format json|csv|xlsx] [-o file] [-account a] [-from YYYY-MM-DD] [-to YYYY-MM-DD]"
+
+// runExportCommand implements "export", which writes the summary of a
+// transactions file, or the stored transactions, to stdout or a file.
+func runExportCommand(args []string, stdout io.Writer) error {
+	if len(args) == 0 || (args[0] != "summary" && args[0] != "transactions") {
+		return fmt.Errorf(exportUsage, os.Args[0])
+	}
+	command := args[0]
+
+	account := os.Getenv("ACCOUNT")
+	if account == "" {
+		account = config.DefaultAccount
+	}
+	fs := flag.NewFlagSet("export "+command, flag.ExitOnError)
+	formatName := fs.String("format", "", "json, csv or xlsx (default: from the -o extension, or json)")
+	output := fs.String("o", "", "file to write to (default: stdout)")
+	fs.StringVar(&account, "account", account, "with transactions: account to export (overrides $ACCOUNT)")
+	from := fs.String("from", "", "with transactions: first date to export")
+	to := fs.String("to", "", "with transactions: last date to export")
+	fs.Parse(args[1:])
+
+	if *formatName == "" {
+		*formatName = strings.TrimPrefix(filepath.Ext(*output), ".")
+	}
+	format := export.JSON
+	if *formatName != "" {
+		var err error
+		if format, err = export.ParseFormat(*formatName); err != nil {
+			return err
+		}
+	}
+	if format == export.XLSX && *output == "" {
+		return fmt.Errorf("xlsx needs a file: pass -o")
+	}
+
+	var dataset export.Dataset
+	switch command {
+	case "summary":
+		if fs.NArg() > 1 {
+			return fmt.Errorf(exportUsage, os.Args[0])
+		}
+		source := FilePath
+		if fs.NArg() == 1 {
+			source = fs.Arg(0)
+		}
+		summary, err := exportSummary(context.Background(), source)
+		if err != nil {
+			return err
+		}
+		dataset = summary
+	case "transactions":
+		if fs.NArg() != 0 {
+			return fmt.Errorf(exportUsage, os.Args[0])
+		}
+		if err := db.InitDB(); err != nil {
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		ledger, err := exportLedger(context.Background(), account, *from, *to)
+		if err != nil {
+			return err
+		}
+		dataset = ledger
+	}
+
+	if *output == "" {
+		return export.Write(stdout, format, dataset)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := export.Write(f, format, dataset); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// exportSummary computes the summary of the transactions file at path.
+func exportSummary(ctx context.Context, path string) (export.Summary, error) {
+	result, err := transactions.NewProcessor(transactions.DefaultCSVReader{}).Process(ctx, path)
+	if err != nil {
+		return export.Summary{}, err
+	}
+	return export.Summary{
+		Source:       filepath.Base(path),
+		TotalBalance: result.TotalBalance,
+		AvgDebit:     result.AvgDebit,
+		AvgCredit:    result.AvgCredit,
+		Months:       result.Summary,
+	}, nil
+}
+
+// checkDateRange validates the bounds of a ledger export, so a typo
+// doesn't silently export nothing.
+func checkDateRange(from, to string) error {
+	for _, date := range []string{from, to} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return fmt.Errorf("invalid date %q: want YYYY-MM-DD", date)
+		}
+	}
+	return nil
+}
+
+// exportLedger returns the stored transactions of account between from and
+// to.
+func exportLedger(ctx context.Context, account, from, to string) (export.Ledger, error) {
+	if err := checkDateRange(from, to); err != nil {
+		return nil, err
+	}
+	rows, err := db.GetTransactions(ctx, account, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return export.Ledger(rows), nil
+}
+
+// exportHandler serves /export/summary, the summary of the current
+// transactions file, and /export/transactions, the stored ledger of the
+// account query parameter (the configured account by default) filtered by
+// the from and to query parameters. The format comes from the format query
+// parameter or, failing that, the Accept header.
+func exportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		var format export.Format
+		if name := query.Get("format"); name != "" {
+			var err error
+			if format, err = export.ParseFormat(name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			var ok bool
+			if format, ok = export.Negotiate(r.Header.Get("Accept")); !ok {
+				http.Error(w, "acceptable formats: application/json, text/csv, "+export.XLSX.ContentType(), http.StatusNotAcceptable)
+				return
+			}
+		}
+
+		var dataset export.Dataset
+		var name string
+		switch strings.TrimPrefix(r.URL.Path, "/export/") {
+		case "summary":
+			summary, err := exportSummary(r.Context(), FilePath)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			dataset, name = summary, "summary"
+		case "transactions":
+			from, to := query.Get("from"), query.Get("to")
+			if err := checkDateRange(from, to); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			account := query.Get("account")
+			if account == "" {
+				account = config.AppConfig.Account
+			}
+			ledger, err := exportLedger(r.Context(), account, from, to)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			dataset, name = ledger, "transactions"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Vary", "Accept")
+		if format != export.JSON {
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+string(format)))
+		}
+		if err := export.Write(w, format, dataset); err != nil {
+			// The status is already sent; all that's left is to log it
+			slog.Error("writing export failed", "export", name, "format", format, "error", err)
+		}
+	})
+}
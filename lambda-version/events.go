@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/tracing"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// s3TestEvent is the message S3 sends to a queue when a notification is
+// first configured. It carries no records.
+const s3TestEvent = "s3:TestEvent"
+
+// handleRequest is the Lambda entry point. It accepts native S3 bucket
+// notifications, SQS messages wrapping them, API Gateway proxy requests in
+// both payload formats and the legacy Request payload, and dispatches on the
+// shape of the event.
+func (h *handler) handleRequest(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	ctx, span := tracing.Start(ctx, "lambda.handleRequest")
+	defer func() {
+		span.End()
+		if err := tracing.Flush(ctx); err != nil {
+			logging.FromContext(ctx).Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	logger := logging.OrDefault(h.logger)
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		logger = logger.With("aws_request_id", lc.AwsRequestID)
+		span.SetAttributes(attribute.String("faas.invocation_id", lc.AwsRequestID))
+	}
+	ctx = logging.NewContext(ctx, logger)
+
+	var probe struct {
+		Records []struct {
+			EventSource string `json:"eventSource"`
+		} `json:"Records"`
+		HTTPMethod     string `json:"httpMethod"`
+		RequestContext struct {
+			HTTP struct {
+				Method string `json:"method"`
+			} `json:"http"`
+		} `json:"requestContext"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+
+	switch {
+	case len(probe.Records) > 0 && probe.Records[0].EventSource == "aws:sqs":
+		var event events.SQSEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("invalid SQS event: %w", err)
+		}
+		return h.handleSQS(ctx, event), nil
+	case len(probe.Records) > 0 && probe.Records[0].EventSource == "aws:s3":
+		var event events.S3Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("invalid S3 event: %w", err)
+		}
+		return h.handleS3(ctx, event)
+	case probe.HTTPMethod != "":
+		var req events.APIGatewayProxyRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, fmt.Errorf("invalid API Gateway request: %w", err)
+		}
+		status, body := h.handleAPIGateway(ctx, req.HTTPMethod, req.Body, req.IsBase64Encoded)
+		return events.APIGatewayProxyResponse{StatusCode: status, Headers: apiHeaders(), Body: body}, nil
+	case probe.RequestContext.HTTP.Method != "":
+		var req events.APIGatewayV2HTTPRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, fmt.Errorf("invalid API Gateway request: %w", err)
+		}
+		status, body := h.handleAPIGateway(ctx, req.RequestContext.HTTP.Method, req.Body, req.IsBase64Encoded)
+		return events.APIGatewayV2HTTPResponse{StatusCode: status, Headers: apiHeaders(), Body: body}, nil
+	default:
+		var req Request
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, fmt.Errorf("invalid request: %w", err)
+		}
+		return h.handleCustom(ctx, req)
+	}
+}
+
+// s3Failure is an object of a bucket notification that failed.
+type s3Failure struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Error  string `json:"error"`
+}
+
+// s3EventResponse reports a bucket notification whose objects only partly
+// succeeded.
+type s3EventResponse struct {
+	Processed int         `json:"processed"`
+	Failures  []s3Failure `json:"failures"`
+}
+
+// handleS3 processes every record of a bucket notification. When every
+// record fails the invocation fails, so Lambda retries the event. When only
+// some do, the failures are reported instead: a retry would email the
+// summaries of the objects that succeeded again. Route notifications
+// through SQS to have the failed ones retried on their own.
+func (h *handler) handleS3(ctx context.Context, event events.S3Event) (interface{}, error) {
+	var errs []error
+	var failures []s3Failure
+	for _, record := range event.Records {
+		if err := h.processRecord(ctx, record); err != nil {
+			errs = append(errs, err)
+			failures = append(failures, s3Failure{Bucket: record.S3.Bucket.Name, Key: record.S3.Object.Key, Error: err.Error()})
+		}
+	}
+	switch {
+	case len(failures) == 0:
+		return fmt.Sprintf("Processed %d objects", len(event.Records)), nil
+	case len(failures) == len(event.Records):
+		return nil, errors.Join(errs...)
+	}
+	logging.FromContext(ctx).Error("some objects failed, not retrying the event", "outcome", "partial_failure", "processed", len(event.Records)-len(failures), "failed", len(failures))
+	return s3EventResponse{Processed: len(event.Records) - len(failures), Failures: failures}, nil
+}
+
+// handleSQS processes S3 notifications delivered through a queue and reports
+// the messages that failed so only those are retried. The event source
+// mapping must have ReportBatchItemFailures enabled.
+func (h *handler) handleSQS(ctx context.Context, event events.SQSEvent) events.SQSEventResponse {
+	var response events.SQSEventResponse
+	for _, message := range event.Records {
+		if err := h.processMessage(ctx, message); err != nil {
+			logging.FromContext(ctx).Error("message failed", "message_id", message.MessageId, "outcome", "failure", "error", err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: message.MessageId,
+			})
+		}
+	}
+	return response
+}
+
+func (h *handler) processMessage(ctx context.Context, message events.SQSMessage) error {
+	var body struct {
+		events.S3Event
+		Event string `json:"Event"`
+	}
+	if err := json.Unmarshal([]byte(message.Body), &body); err != nil {
+		return fmt.Errorf("invalid S3 event in message body: %w", err)
+	}
+	if body.Event == s3TestEvent {
+		return nil
+	}
+
+	var errs []error
+	for _, record := range body.Records {
+		if err := h.processRecord(ctx, record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h *handler) processRecord(ctx context.Context, record events.S3EventRecord) error {
+	// Object keys arrive URL-encoded in notifications
+	key, err := url.QueryUnescape(record.S3.Object.Key)
+	if err != nil {
+		return fmt.Errorf("invalid object key %q: %w", record.S3.Object.Key, err)
+	}
+	_, err = h.processObject(ctx, record.S3.Bucket.Name, key, "")
+	return err
+}
+
+// apiRequest is the JSON body accepted from API Gateway. Callers only pick
+// the object, within the input bucket and prefix; who receives its summary
+// is always resolved from the object, as for S3 events.
+type apiRequest struct {
+	// Bucket defaults to the input bucket, the only one allowed.
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+// handleAPIGateway serves a request of either API Gateway payload format
+// and returns the response status and JSON body.
+func (h *handler) handleAPIGateway(ctx context.Context, method, rawBody string, isBase64 bool) (int, string) {
+	if method != http.MethodPost {
+		return apiResponse(http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+
+	body := []byte(rawBody)
+	if isBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(rawBody)
+		if err != nil {
+			return apiResponse(http.StatusBadRequest, map[string]string{"error": "invalid base64 body"})
+		}
+		body = decoded
+	}
+
+	if h.inputBucket == "" {
+		return apiResponse(http.StatusForbidden, map[string]string{"error": "API requests are disabled: INPUT_BUCKET is not set"})
+	}
+
+	// Unknown fields, such as the toEmail older clients sent, are rejected
+	// rather than silently ignored
+	var input apiRequest
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&input); err != nil {
+		return apiResponse(http.StatusBadRequest, map[string]string{"error": "invalid JSON body: " + err.Error()})
+	}
+	if input.Key == "" {
+		return apiResponse(http.StatusBadRequest, map[string]string{"error": "key is required"})
+	}
+	if input.Bucket == "" {
+		input.Bucket = h.inputBucket
+	}
+	if input.Bucket != h.inputBucket || !strings.HasPrefix(input.Key, h.inputPrefix) {
+		return apiResponse(http.StatusForbidden, map[string]string{"error": "the object must be in the input bucket, under its prefix"})
+	}
+
+	recipient, err := h.processObject(ctx, input.Bucket, input.Key, "")
+	if err != nil {
+		logging.FromContext(ctx).Error("API request failed", "bucket", input.Bucket, "key", input.Key, "outcome", "failure", "error", err)
+		return apiResponse(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return apiResponse(http.StatusOK, map[string]string{
+		"status":    "sent",
+		"bucket":    input.Bucket,
+		"key":       input.Key,
+		"recipient": recipient,
+	})
+}
+
+func apiResponse(status int, body map[string]string) (int, string) {
+	data, _ := json.Marshal(body)
+	return status, string(data)
+}
+
+func apiHeaders() map[string]string {
+	return map[string]string{"Content-Type": "application/json"}
+}
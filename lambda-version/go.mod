@@ -1,14 +0,0 @@
-module stori-technical-challenge/lambda-version
-
-go 1.22.4
-
-require (
-	github.com/aws/aws-lambda-go v1.47.0
-	github.com/aws/aws-sdk-go v1.54.2
-	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
-)
-
-require (
-	github.com/jmespath/go-jmespath v0.4.0 // indirect
-	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
-)
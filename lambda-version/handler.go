@@ -2,22 +2,61 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"stori-technical-challenge/lambda-version/email"
-	"stori-technical-challenge/lambda-version/transactions"
-	"stori-technical-challenge/lambda-version/utils"
+	"log/slog"
+	"os"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/anomaly"
+	"stori-technical-challenge/pkg/category"
+	"stori-technical-challenge/pkg/email"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/metrics"
+	"stori-technical-challenge/pkg/pipeline"
+	"stori-technical-challenge/pkg/storage"
+	"stori-technical-challenge/pkg/tracing"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// deadlineMargin is the time reserved before the invocation deadline to
+// report a timeout.
+const deadlineMargin = 2 * time.Second
+
+// Request is the original custom payload. It is still accepted for direct
+// invocations alongside the native event types handled in events.go.
 type Request struct {
 	ToEmail string               `json:"toEmail"`
 	S3Event events.S3EventRecord `json:"s3Event"`
 }
 
-func handleRequest(ctx context.Context, req Request) (string, error) {
+// handler holds the dependencies shared by every invocation.
+type handler struct {
+	s3Client   storage.S3Client
+	pipeline   *pipeline.Pipeline
+	recipients recipientResolver
+	// inputBucket and inputPrefix are where statements are uploaded. API
+	// requests can only name objects there, and are refused without an
+	// input bucket.
+	inputBucket, inputPrefix string
+	// archive, when set, receives a copy of every summary before it's sent.
+	archive *pipeline.Archive
+	// alertEmail and alertWebhook, when set, are told about unusual
+	// activity in a file.
+	alertEmail   string
+	alertWebhook *anomaly.Webhook
+	// logger is the base logger; each invocation and file adds its own
+	// attributes. Defaults to slog.Default().
+	logger *slog.Logger
+}
+
+func (h *handler) handleCustom(ctx context.Context, req Request) (string, error) {
 	// Validar entrada
 	if req.ToEmail == "" {
 		return "", fmt.Errorf("missing 'ToEmail' field")
@@ -26,39 +65,253 @@ func handleRequest(ctx context.Context, req Request) (string, error) {
 		return "", fmt.Errorf("invalid S3 event: bucket or key is missing")
 	}
 
-	bucket := req.S3Event.S3.Bucket.Name
-	key := req.S3Event.S3.Object.Key
-	log.Printf("Processing file: s3://%s/%s\n", bucket, key)
+	if _, err := h.processObject(ctx, req.S3Event.S3.Bucket.Name, req.S3Event.S3.Object.Key, req.ToEmail); err != nil {
+		return "", err
+	}
+	return "Success", nil
+}
+
+// processObject summarizes one S3 object and emails it. When toEmail is
+// empty the recipients are resolved from the object. It returns the
+// addresses the summary was sent to, comma-separated. Every log line about the object carries its own
+// correlation ID.
+func (h *handler) processObject(ctx context.Context, bucket, key, toEmail string) (recipient string, err error) {
+	ctx, span := tracing.Start(ctx, "lambda.processObject", attribute.String("s3.bucket", bucket), attribute.String("s3.key", key))
+	start := time.Now()
+	var addresses []string
+	logger := logging.WithCorrelationID(logging.FromContext(ctx), logging.NewCorrelationID()).With("bucket", bucket, "key", key)
+	ctx = logging.NewContext(ctx, logger)
+	logger.Info("processing file")
+	defer func() {
+		tracing.End(span, err)
+		if err != nil {
+			logger.Error("processing file failed", "outcome", "failure", "error", err, "duration_ms", logging.Since(start))
+			return
+		}
+		logger.Info("file processed", "outcome", "success", "recipients", logging.MaskEmails(addresses), "duration_ms", logging.Since(start))
+	}()
 
-	// Crear cliente S3
-	s3Client := &transactions.DefaultS3Client{}
+	// Stop a little before Lambda's own deadline so a slow download fails
+	// with a useful error instead of the runtime killing the invocation
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-deadlineMargin))
+		defer cancel()
+	}
 
-	// Procesar transacciones desde S3
-	totalBalance, summary, avgDebit, avgCredit, err := transactions.ProcessTransactionsFromS3(bucket, key, s3Client)
+	deliveries := to(toEmail)
+	if toEmail == "" {
+		if deliveries, err = h.recipients.resolve(ctx, bucket, key); err != nil {
+			return "", err
+		}
+	}
+	for _, d := range deliveries {
+		addresses = append(addresses, d.msg.Recipients()...)
+	}
+	recipient = strings.Join(addresses, ", ")
+
+	// Procesar transacciones desde S3 y generar el contenido del correo
+	p := *h.pipeline
+	p.Logger = logger
+	reader := storage.S3CSVReader{Client: h.s3Client, Bucket: bucket}
+	report, err := p.Summarize(ctx, reader, key)
 	if err != nil {
-		return "", fmt.Errorf("error processing transactions: %w", err)
+		return "", err
+	}
+
+	// Guardar una copia antes de enviar, así todo correo enviado queda registrado
+	if h.archive != nil {
+		dir, err := h.archive.Save(ctx, report, recipient)
+		if err != nil {
+			return "", fmt.Errorf("error archiving results: %w", err)
+		}
+		logger.Info("results archived", "output_bucket", h.archive.Bucket, "output_dir", dir)
+	}
+
+	// Enviar un correo por idioma
+	if len(deliveries) == 0 {
+		logger.Info("every recipient opted out, summary not sent")
 	}
+	for _, d := range deliveries {
+		if err := p.DeliverLocalized(ctx, report, d.msg, d.locale); err != nil {
+			return "", err
+		}
+	}
+	h.alert(ctx, &p, report)
+	metrics.RecordSuccess()
+	return recipient, nil
+}
 
-	emailSummary := utils.ConvertTransactionSummaryToEmailSummary(summary)
+// alert reports the unusual activity in a summarized file. Failures are
+// only logged: failing the invocation would send the summary again when
+// it's retried.
+func (h *handler) alert(ctx context.Context, p *pipeline.Pipeline, report *pipeline.Report) {
+	if report.Alert == nil {
+		return
+	}
+	logger := logging.OrDefault(p.Logger)
+	if h.alertEmail != "" {
+		// DeliverAlert logs its own failures
+		_ = p.DeliverAlert(ctx, report, h.alertEmail)
+	}
+	if h.alertWebhook != nil {
+		if err := h.alertWebhook.Post(ctx, *report.Alert); err != nil {
+			logger.Error("alert webhook failed", "outcome", "failure", "anomalies", len(report.Alert.Anomalies), "error", err)
+		}
+	}
+}
 
-	// Generar contenido del correo
-	emailData := email.GenerateEmailData(totalBalance, emailSummary, avgDebit, avgCredit)
-	body, err := email.LoadTemplate("email_template.html", emailData)
+// newHandler wires a handler from the configuration and the environment:
+// EMAIL_TEMPLATE_DIR, RECIPIENTS_OBJECT, RECIPIENT_LOOKUP, INPUT_BUCKET,
+// INPUT_PREFIX, OUTPUT_BUCKET and OUTPUT_PREFIX.
+// Categorization rules come from the configuration's category_rules and
+// unusual activity goes to its alert_email and alert_webhook.
+func newHandler(cfg config.Config, s3Client storage.S3Client, sender email.EmailSender, logger *slog.Logger) (*handler, error) {
+	// The template is embedded in the binary now, and a single-file
+	// override would lose the layout and partials. Deployments that still
+	// set it keep starting, with the built-in templates.
+	if path := os.Getenv("EMAIL_TEMPLATE_PATH"); path != "" {
+		logging.OrDefault(logger).Warn("EMAIL_TEMPLATE_PATH is deprecated and ignored; set EMAIL_TEMPLATE_DIR to a directory of template overrides", "path", path)
+	}
+	templates := email.DefaultTemplates
+	if dir := os.Getenv("EMAIL_TEMPLATE_DIR"); dir != "" {
+		templates = email.NewRegistry(dir)
+	}
+
+	categories, err := category.Load(cfg.CategoryRules)
 	if err != nil {
-		return "", fmt.Errorf("error loading email template: %w", err)
+		return nil, err
 	}
 
-	// Enviar correo
-	emailSender := email.SMTPSender{}
-	err = emailSender.SendEmail("Stori - Transaction Summary", body, req.ToEmail)
+	recipients, err := parseS3Location(os.Getenv("RECIPIENTS_OBJECT"))
+	if err != nil {
+		return nil, fmt.Errorf("RECIPIENTS_OBJECT: %w", err)
+	}
+	table, err := parseRecipientTable(os.Getenv("RECIPIENT_LOOKUP"))
 	if err != nil {
-		return "", fmt.Errorf("error sending email: %w", err)
+		return nil, err
 	}
 
-	log.Println("Email sent successfully")
-	return "Success", nil
+	h := &handler{
+		s3Client: s3Client,
+		pipeline: &pipeline.Pipeline{
+			Templates:   templates,
+			Sender:      sender,
+			Categorizer: categories,
+		},
+		recipients: recipientResolver{
+			s3Client:   s3Client,
+			recipients: recipients,
+			account:    cfg.Account,
+			table:      table,
+			fallback:   cfg.ToEmail,
+		},
+		inputBucket: os.Getenv("INPUT_BUCKET"),
+		inputPrefix: os.Getenv("INPUT_PREFIX"),
+		logger:      logger,
+		alertEmail:  cfg.AlertEmail,
+	}
+	if cfg.AlertWebhook != "" {
+		h.alertWebhook = &anomaly.Webhook{URL: cfg.AlertWebhook}
+	}
+	if bucket := os.Getenv("OUTPUT_BUCKET"); bucket != "" {
+		h.archive = &pipeline.Archive{Writer: s3Client, Bucket: bucket, Prefix: os.Getenv("OUTPUT_PREFIX")}
+	}
+	return h, nil
 }
 
 func main() {
-	lambda.Start(handleRequest)
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	var local localOptions
+	fs.StringVar(&local.eventFile, "event", "", "run locally: invoke the handler once with this JSON event file")
+	fs.StringVar(&local.bucketDir, "bucket-dir", ".", "with -event: directory that stands in for the S3 bucket")
+	fs.StringVar(&local.captureDir, "capture-dir", "", "with -event: also write captured emails to this directory")
+	fs.StringVar(&local.outputDir, "output-dir", "", "with -event: archive results to this directory")
+	fs.Parse(os.Args[1:])
+
+	logger := logging.FromEnv()
+	slog.SetDefault(logger)
+
+	if local.eventFile != "" {
+		if err := runLocal(local, os.Stdout); err != nil {
+			logger.Error("local run failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Spans are flushed at the end of every invocation, see handleRequest
+	if _, err := tracing.Setup(context.Background(), tracing.Options{ServiceName: "stori-lambda"}); err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	h, err := newLambdaHandler(logger)
+	if err != nil {
+		// Only main exits: a failed cold start is reported once and the
+		// runtime marks the init as failed
+		logger.Error("failed to initialize handler", "error", err)
+		os.Exit(1)
+	}
+	lambda.Start(h.handleRequest)
+}
+
+// newLambdaHandler loads the configuration and builds the handler used by
+// the Lambda runtime.
+func newLambdaHandler(logger *slog.Logger) (*handler, error) {
+	// El destinatario se resuelve por evento, TO_EMAIL es opcional
+	cfg, err := config.Load(config.Options{RecipientOptional: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	config.AppConfig = cfg
+
+	// Un único cliente por cold start, compartido entre invocaciones
+	s3Options, err := s3OptionsFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	s3Client, err := storage.NewDefaultS3Client(s3Options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return newHandler(cfg, s3Client, email.SMTPSender{Logger: logger}, logger)
+}
+
+// s3OptionsFromEnv reads the S3 client settings: S3_MAX_RETRIES,
+// S3_TIMEOUT and S3_RETRY_MAX_DELAY (Go durations such as "5s") and
+// S3_MAX_OBJECT_SIZE (bytes).
+func s3OptionsFromEnv() (storage.S3Options, error) {
+	var opts storage.S3Options
+	var errs []error
+
+	if v := os.Getenv("S3_MAX_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		errs = append(errs, envError("S3_MAX_RETRIES", err))
+		opts.MaxRetries = n
+	}
+	if v := os.Getenv("S3_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		errs = append(errs, envError("S3_TIMEOUT", err))
+		opts.Timeout = d
+	}
+	if v := os.Getenv("S3_RETRY_MAX_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		errs = append(errs, envError("S3_RETRY_MAX_DELAY", err))
+		opts.MaxRetryDelay = d
+	}
+	if v := os.Getenv("S3_MAX_OBJECT_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		errs = append(errs, envError("S3_MAX_OBJECT_SIZE", err))
+		opts.MaxObjectSize = n
+	}
+	return opts, errors.Join(errs...)
+}
+
+func envError(name string, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/email"
+	"stori-technical-challenge/pkg/pipeline"
+	"stori-technical-challenge/pkg/storage"
+	"stori-technical-challenge/pkg/transactions"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fixturesDir = filepath.Join("..", "testdata", "fixtures")
+
+// fixtureBucket stores every fixture under each of the key prefixes used in
+// the tests. uploads/basic.csv carries recipient metadata.
+func fixtureBucket(t *testing.T) *storage.MemClient {
+	fixtures, err := filepath.Glob(filepath.Join(fixturesDir, "*.csv"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	client := storage.NewMemClient()
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(fixture)
+		require.NoError(t, err)
+		for _, prefix := range []string{"", "inbox/user@example.com/", "acme/", "acme/finance/"} {
+			client.Put("statements", prefix+filepath.Base(fixture), data, nil)
+		}
+		if filepath.Base(fixture) == "basic.csv" {
+			client.Put("statements", "uploads/basic.csv", data, map[string]string{"Recipient": "meta@example.com"})
+			client.Put("statements", "unknown/basic.csv", data, nil)
+		}
+	}
+	return client
+}
+
+func newPipeline(sender *email.CaptureSender) *pipeline.Pipeline {
+	return &pipeline.Pipeline{
+		Sender:           sender,
+		ProcessorOptions: []transactions.Option{transactions.WithYear(2024)},
+		Now:              func() time.Time { return time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC) },
+	}
+}
+
+// TestHandlerMatchesCLI runs every fixture through the CLI path (local file)
+// and the Lambda handler (S3 object) and checks they produce the same email.
+func TestHandlerMatchesCLI(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join(fixturesDir, "*.csv"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	for _, fixture := range fixtures {
+		name := filepath.Base(fixture)
+		t.Run(name, func(t *testing.T) {
+			cliSender := &email.CaptureSender{}
+			cli := newPipeline(cliSender)
+			report, err := cli.Summarize(context.Background(), transactions.DefaultCSVReader{}, fixture)
+			require.NoError(t, err)
+			require.NoError(t, cli.Deliver(context.Background(), report, "user@example.com"))
+
+			lambdaSender := &email.CaptureSender{}
+			h := &handler{s3Client: fixtureBucket(t), pipeline: newPipeline(lambdaSender)}
+			req := Request{ToEmail: "user@example.com"}
+			req.S3Event.S3.Bucket.Name = "statements"
+			req.S3Event.S3.Object.Key = name
+
+			result, err := h.handleRequest(context.Background(), mustJSON(t, req))
+			require.NoError(t, err)
+			assert.Equal(t, "Success", result)
+
+			require.Len(t, lambdaSender.Sent(), 1)
+			assert.Equal(t, cliSender.Sent(), lambdaSender.Sent())
+			for month := range report.Summary {
+				assert.Contains(t, lambdaSender.Sent()[0].Body, "Number of transactions in "+month+":")
+			}
+			assert.Len(t, report.Summary, 2, "fixtures span exactly two months")
+		})
+	}
+}
+
+func TestHandlerRejectsInvalidRequests(t *testing.T) {
+	h := &handler{s3Client: fixtureBucket(t), pipeline: newPipeline(&email.CaptureSender{})}
+
+	_, err := h.handleRequest(context.Background(), mustJSON(t, Request{}))
+	assert.ErrorContains(t, err, "missing 'ToEmail' field")
+
+	_, err = h.handleRequest(context.Background(), mustJSON(t, Request{ToEmail: "user@example.com", S3Event: events.S3EventRecord{}}))
+	assert.ErrorContains(t, err, "bucket or key is missing")
+}
+
+func mustJSON(t *testing.T, v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
+
+func s3Record(bucket, key string) events.S3EventRecord {
+	var record events.S3EventRecord
+	record.EventSource = "aws:s3"
+	record.S3.Bucket.Name = bucket
+	record.S3.Object.Key = key
+	return record
+}
+
+func newRecipientHandler(t *testing.T, sender *email.CaptureSender) *handler {
+	client := fixtureBucket(t)
+	return &handler{
+		s3Client: client,
+		pipeline: newPipeline(sender),
+		recipients: recipientResolver{
+			s3Client: client,
+			table:    map[string]string{"acme/": "acme@example.com", "acme/finance/": "finance@acme.com"},
+		},
+	}
+}
+
+func TestHandlerS3EventResolvesRecipients(t *testing.T) {
+	sender := &email.CaptureSender{}
+	h := newRecipientHandler(t, sender)
+
+	event := events.S3Event{Records: []events.S3EventRecord{
+		s3Record("statements", "uploads/basic.csv"),
+		s3Record("statements", "inbox/user%40example.com/many_days.csv"),
+		s3Record("statements", "acme/finance/full_dates.csv"),
+		s3Record("statements", "acme/invalid_rows.csv"),
+	}}
+
+	result, err := h.handleRequest(context.Background(), mustJSON(t, event))
+	require.NoError(t, err)
+	assert.Equal(t, "Processed 4 objects", result)
+
+	var recipients []string
+	for _, sent := range sender.Sent() {
+		recipients = append(recipients, sent.To...)
+	}
+	assert.Equal(t, []string{"meta@example.com", "user@example.com", "finance@acme.com", "acme@example.com"}, recipients)
+
+	_, err = h.handleRequest(context.Background(), mustJSON(t, events.S3Event{Records: []events.S3EventRecord{
+		s3Record("statements", "unknown/basic.csv"),
+	}}))
+	assert.ErrorContains(t, err, "no recipient found for s3://statements/unknown/basic.csv")
+}
+
+func TestHandlerS3EventUsesRecipientsTable(t *testing.T) {
+	sender := &email.CaptureSender{}
+	h := newRecipientHandler(t, sender)
+	client := h.s3Client.(*storage.MemClient)
+	client.Put("config", "recipients.jsonl", []byte(strings.Join([]string{
+		`{"id":1,"account":"acme","email":"owner@acme.com","role":"to","opted_out":false,"locale":"es","frequency":"daily"}`,
+		`{"id":2,"account":"acme","email":"cfo@acme.com","role":"cc","opted_out":false,"locale":"en","frequency":"weekly"}`,
+		`{"id":3,"account":"acme","email":"audit@acme.com","role":"bcc","opted_out":false,"locale":"es","frequency":"daily"}`,
+		`{"id":4,"account":"acme","email":"gone@acme.com","role":"to","opted_out":true,"locale":"es","frequency":"daily"}`,
+		`{"id":5,"account":"quiet","email":"gone@quiet.com","role":"to","opted_out":true,"locale":"es","frequency":"daily"}`,
+	}, "\n")), nil)
+	data, err := os.ReadFile(filepath.Join(fixturesDir, "basic.csv"))
+	require.NoError(t, err)
+	client.Put("statements", "uploads/acme.csv", data, map[string]string{"Account": "acme"})
+	client.Put("statements", "uploads/quiet.csv", data, map[string]string{"Account": "quiet"})
+	h.recipients.recipients = s3Location{bucket: "config", key: "recipients.jsonl"}
+	h.recipients.account = "acme"
+
+	result, err := h.handleRequest(context.Background(), mustJSON(t, events.S3Event{Records: []events.S3EventRecord{
+		s3Record("statements", "uploads/acme.csv"),
+		s3Record("statements", "uploads/quiet.csv"),
+		s3Record("statements", "acme/basic.csv"),
+	}}))
+	require.NoError(t, err)
+	assert.Equal(t, "Processed 3 objects", result)
+
+	sent := sender.Sent()
+	require.Len(t, sent, 4)
+	// One email per locale, in the table's order
+	assert.Equal(t, []string{"owner@acme.com"}, sent[0].To)
+	assert.Equal(t, []string{"audit@acme.com"}, sent[0].Bcc)
+	assert.Equal(t, "Stori - Resumen de transacciones", sent[0].Subject)
+	assert.Equal(t, []string{"cfo@acme.com"}, sent[1].To)
+	assert.Equal(t, pipeline.Subject, sent[1].Subject)
+	// Nobody for quiet, who opted out; acme/basic.csv has no account
+	// metadata, so it belongs to the configured account too
+	assert.Equal(t, []string{"owner@acme.com"}, sent[2].To)
+	assert.Equal(t, []string{"cfo@acme.com"}, sent[3].To)
+}
+
+func TestHandlerS3EventReportsPartialFailures(t *testing.T) {
+	sender := &email.CaptureSender{}
+	h := newRecipientHandler(t, sender)
+
+	result, err := h.handleRequest(context.Background(), mustJSON(t, events.S3Event{Records: []events.S3EventRecord{
+		s3Record("statements", "acme/basic.csv"),
+		s3Record("statements", "acme/missing.csv"),
+	}}))
+	require.NoError(t, err, "failing would retry the event and send acme/basic.csv again")
+	response := result.(s3EventResponse)
+	assert.Equal(t, 1, response.Processed)
+	require.Len(t, response.Failures, 1)
+	assert.Equal(t, "acme/missing.csv", response.Failures[0].Key)
+	assert.Len(t, sender.Sent(), 1)
+}
+
+func TestHandlerSQSReportsPartialFailures(t *testing.T) {
+	sender := &email.CaptureSender{}
+	h := newRecipientHandler(t, sender)
+
+	sqsMessage := func(id string, body interface{}) events.SQSMessage {
+		return events.SQSMessage{MessageId: id, EventSource: "aws:sqs", Body: string(mustJSON(t, body))}
+	}
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		sqsMessage("ok", events.S3Event{Records: []events.S3EventRecord{s3Record("statements", "acme/basic.csv")}}),
+		sqsMessage("missing", events.S3Event{Records: []events.S3EventRecord{s3Record("statements", "acme/missing.csv")}}),
+		sqsMessage("test", map[string]string{"Event": "s3:TestEvent"}),
+		{MessageId: "garbage", EventSource: "aws:sqs", Body: "not json"},
+	}}
+
+	result, err := h.handleRequest(context.Background(), mustJSON(t, event))
+	require.NoError(t, err)
+	assert.Equal(t, events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{
+		{ItemIdentifier: "missing"},
+		{ItemIdentifier: "garbage"},
+	}}, result)
+	assert.Len(t, sender.Sent(), 1)
+}
+
+func TestHandlerAPIGateway(t *testing.T) {
+	sender := &email.CaptureSender{}
+	h := newRecipientHandler(t, sender)
+	h.inputBucket, h.inputPrefix = "statements", "uploads/"
+
+	request := func(method, body string) events.APIGatewayProxyResponse {
+		result, err := h.handleRequest(context.Background(), mustJSON(t, events.APIGatewayProxyRequest{HTTPMethod: method, Body: body}))
+		require.NoError(t, err)
+		return result.(events.APIGatewayProxyResponse)
+	}
+
+	resp := request("POST", `{"bucket":"statements","key":"uploads/basic.csv"}`)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.JSONEq(t, `{"status":"sent","bucket":"statements","key":"uploads/basic.csv","recipient":"meta@example.com"}`, resp.Body, "recipients come from the object")
+
+	assert.Equal(t, 400, request("POST", `{"bucket":"statements"}`).StatusCode)
+	assert.Equal(t, 400, request("POST", `{"key":"uploads/basic.csv","toEmail":"attacker@example.com"}`).StatusCode, "callers can't choose the recipient")
+	assert.Equal(t, 403, request("POST", `{"bucket":"secrets","key":"uploads/basic.csv"}`).StatusCode, "nor another bucket")
+	assert.Equal(t, 403, request("POST", `{"key":"acme/basic.csv"}`).StatusCode, "nor a key outside the prefix")
+	assert.Equal(t, 405, request("GET", "").StatusCode)
+	assert.Equal(t, 500, request("POST", `{"key":"uploads/missing.csv"}`).StatusCode)
+	assert.Len(t, sender.Sent(), 1)
+
+	h.inputBucket = ""
+	assert.Equal(t, 403, request("POST", `{"key":"uploads/basic.csv"}`).StatusCode, "without an input bucket the API is off")
+}
+
+func TestHandlerAPIGatewayV2(t *testing.T) {
+	sender := &email.CaptureSender{}
+	h := newRecipientHandler(t, sender)
+	h.inputBucket = "statements"
+
+	request := func(method, body string) events.APIGatewayV2HTTPResponse {
+		var req events.APIGatewayV2HTTPRequest
+		req.Version = "2.0"
+		req.RequestContext.HTTP.Method = method
+		req.Body = body
+		result, err := h.handleRequest(context.Background(), mustJSON(t, req))
+		require.NoError(t, err)
+		return result.(events.APIGatewayV2HTTPResponse)
+	}
+
+	resp := request("POST", `{"key":"inbox/user@example.com/basic.csv"}`)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.JSONEq(t, `{"status":"sent","bucket":"statements","key":"inbox/user@example.com/basic.csv","recipient":"user@example.com"}`, resp.Body)
+	assert.Equal(t, 405, request("GET", "").StatusCode)
+	assert.Len(t, sender.Sent(), 1)
+}
+
+func TestHandlerArchivesResults(t *testing.T) {
+	sender := &email.CaptureSender{}
+	outputs := storage.FSClient{Root: t.TempDir()}
+	h := newRecipientHandler(t, sender)
+	h.archive = &pipeline.Archive{
+		Writer: outputs,
+		Bucket: "outputs",
+		Prefix: "sent",
+		Now:    func() time.Time { return time.Date(2024, 9, 3, 14, 5, 6, 0, time.UTC) },
+	}
+
+	_, err := h.handleRequest(context.Background(), mustJSON(t, events.S3Event{Records: []events.S3EventRecord{
+		s3Record("statements", "acme/invalid_rows.csv"),
+	}}))
+	require.NoError(t, err)
+
+	dir := "sent/2024-09-03/acme/invalid_rows/140506.000/"
+	read := func(name string) string {
+		obj, err := outputs.GetObject(context.Background(), "outputs", dir+name)
+		require.NoError(t, err)
+		defer obj.Body.Close()
+		data, err := io.ReadAll(obj.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	var summary pipeline.SummaryDocument
+	require.NoError(t, json.Unmarshal([]byte(read("summary.json")), &summary))
+	assert.Equal(t, "acme@example.com", summary.Recipient)
+	assert.Equal(t, "acme/invalid_rows.csv", summary.Source)
+	assert.Equal(t, 4, summary.RejectedRows)
+	require.Len(t, summary.Months, 2)
+	assert.Equal(t, "2024-07", summary.Months[0].Month)
+
+	assert.Equal(t, sender.Sent()[0].Body, read("email.html"))
+	assert.Equal(t, "Line,Reason,Id,Date,Transaction\n"+
+		"3,\"invalid date \"\"13/45\"\"\",1,13/45,-10\n"+
+		"4,\"invalid amount \"\"abc\"\"\",2,7/20,abc\n"+
+		"5,\"invalid amount \"\"\"\"\",3,7/21,\n"+
+		"7,missing columns,5,8/2\n", read("rejected.csv"))
+}
+
+func TestNewHandlerIgnoresEmailTemplatePath(t *testing.T) {
+	t.Setenv("EMAIL_TEMPLATE_PATH", "/var/task/templates/email_template.html")
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	h, err := newHandler(config.Config{}, storage.NewMemClient(), &email.CaptureSender{}, logger)
+	require.NoError(t, err, "a leftover setting must not break cold starts")
+	assert.Equal(t, email.DefaultTemplates, h.pipeline.Templates)
+	assert.Contains(t, logs.String(), "level=WARN")
+	assert.Contains(t, logs.String(), "EMAIL_TEMPLATE_PATH is deprecated and ignored")
+}
+
+func TestRunLocal(t *testing.T) {
+	tests := []struct {
+		event    string
+		response string
+		emails   int
+	}{
+		{"s3.json", `"Processed 1 objects"`, 1},
+		{"sqs.json", `{"batchItemFailures":[{"itemIdentifier":"4a9a6f1e-0002"}]}`, 1},
+		{"apigateway.json", `{"statusCode":200}`, 1},
+	}
+
+	t.Setenv("INPUT_BUCKET", "statements")
+	for _, tt := range tests {
+		t.Run(tt.event, func(t *testing.T) {
+			captureDir := t.TempDir()
+			outputDir := t.TempDir()
+
+			var out bytes.Buffer
+			err := runLocal(localOptions{
+				eventFile:  filepath.Join("testdata", "events", tt.event),
+				bucketDir:  filepath.Join("testdata", "bucket"),
+				captureDir: captureDir,
+				outputDir:  outputDir,
+			}, &out)
+			require.NoError(t, err)
+
+			response, _, _ := strings.Cut(out.String(), "captured email")
+			var got map[string]interface{}
+			if json.Unmarshal([]byte(response), &got) == nil {
+				assert.Subset(t, got, mustMap(t, tt.response))
+			} else {
+				assert.JSONEq(t, tt.response, response)
+			}
+
+			captured, err := filepath.Glob(filepath.Join(captureDir, "*.html"))
+			require.NoError(t, err)
+			assert.Len(t, captured, tt.emails)
+			statements, err := filepath.Glob(filepath.Join(captureDir, "*-statement.pdf"))
+			require.NoError(t, err)
+			assert.Len(t, statements, tt.emails, "every email carries its statement")
+
+			var archived []string
+			require.NoError(t, filepath.WalkDir(outputDir, func(path string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					archived = append(archived, d.Name())
+				}
+				return err
+			}))
+			assert.ElementsMatch(t, []string{"summary.json", "email.html", "statement.pdf", "rejected.csv"}, archived)
+		})
+	}
+}
+
+func mustMap(t *testing.T, raw string) map[string]interface{} {
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(raw), &m))
+	return m
+}
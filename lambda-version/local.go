@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/email"
+	"stori-technical-challenge/pkg/pipeline"
+	"stori-technical-challenge/pkg/storage"
+	"strings"
+)
+
+// localOptions configures a local run of the handler.
+type localOptions struct {
+	eventFile  string
+	bucketDir  string
+	captureDir string
+	outputDir  string
+}
+
+// runLocal invokes the handler once with the event in opts.eventFile, the
+// way the Lambda runtime would, but reads objects from a local directory
+// and captures emails instead of sending them. The handler's response is
+// written to out as JSON, followed by a line per captured email.
+func runLocal(opts localOptions, out io.Writer) error {
+	payload, err := os.ReadFile(opts.eventFile)
+	if err != nil {
+		return fmt.Errorf("error reading event file: %w", err)
+	}
+
+	// Nothing goes through SMTP, so an incomplete SMTP configuration is fine
+	cfg, err := config.Load(config.Options{RecipientOptional: true})
+	var validationErr *config.ValidationError
+	if errors.As(err, &validationErr) {
+		slog.Warn("ignoring configuration problems in local mode", "error", validationErr)
+	} else if err != nil {
+		return err
+	}
+	config.AppConfig = cfg
+
+	sender := &email.CaptureSender{Dir: opts.captureDir}
+	h, err := newHandler(cfg, storage.FSClient{Root: opts.bucketDir, SingleBucket: true}, sender, slog.Default())
+	if err != nil {
+		return err
+	}
+	if opts.outputDir != "" {
+		h.archive = &pipeline.Archive{Writer: storage.FSClient{Root: opts.outputDir, SingleBucket: true}, Bucket: "local"}
+	}
+
+	result, err := h.handleRequest(context.Background(), payload)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		return err
+	}
+	for _, sent := range sender.Sent() {
+		fmt.Fprintf(out, "captured email to %s: %q (%d bytes)\n", strings.Join(sent.Recipients(), ", "), sent.Subject, len(sent.Body))
+	}
+	return nil
+}
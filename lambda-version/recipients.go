@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"stori-technical-challenge/pkg/email"
+	"stori-technical-challenge/pkg/storage"
+	"strings"
+)
+
+// RecipientMetadataKey is the S3 user metadata key (x-amz-meta-recipient)
+// that names who should receive an object's summary.
+const RecipientMetadataKey = "recipient"
+
+// AccountMetadataKey is the S3 user metadata key (x-amz-meta-account) that
+// names the account an object belongs to. Objects without it belong to the
+// configured account.
+const AccountMetadataKey = "account"
+
+// delivery is one summary email: who it goes to and in which language.
+type delivery struct {
+	msg    email.Message
+	locale string
+}
+
+// to returns a delivery of the English summary to a single address.
+func to(address string) []delivery {
+	return []delivery{{msg: email.Message{To: []string{address}}, locale: email.TemplateLocale}}
+}
+
+// recipientResolver works out who receives the summary of an S3 object when
+// the event doesn't say. It tries, in order: the object's recipient
+// metadata, an email address used as a folder in the key
+// ("inbox/user@example.com/txns.csv"), the recipients of the object's
+// account in the recipients table, the longest matching prefix in the lookup
+// table and finally the fallback address.
+type recipientResolver struct {
+	s3Client storage.S3Client
+	// recipients is where the recipients table lives; empty without one.
+	recipients s3Location
+	// account is the account of objects without account metadata.
+	account  string
+	table    map[string]string
+	fallback string
+}
+
+// s3Location is an object, parsed from "s3://bucket/key".
+type s3Location struct {
+	bucket, key string
+}
+
+func parseS3Location(raw string) (s3Location, error) {
+	if raw == "" {
+		return s3Location{}, nil
+	}
+	path, ok := strings.CutPrefix(raw, "s3://")
+	bucket, key, _ := strings.Cut(path, "/")
+	if !ok || bucket == "" || key == "" {
+		return s3Location{}, fmt.Errorf("invalid S3 location %q: use s3://bucket/key", raw)
+	}
+	return s3Location{bucket: bucket, key: key}, nil
+}
+
+// parseRecipientTable decodes a JSON object mapping key prefixes to
+// recipients, e.g. {"statements/acme/": "finance@acme.com"}.
+func parseRecipientTable(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	table := make(map[string]string)
+	if err := json.Unmarshal([]byte(raw), &table); err != nil {
+		return nil, fmt.Errorf("invalid recipient lookup table: %w", err)
+	}
+	return table, nil
+}
+
+// resolve returns the emails to send for the object. It is empty when
+// every recipient of the object's account opted out.
+func (r recipientResolver) resolve(ctx context.Context, bucket, key string) ([]delivery, error) {
+	head, err := r.s3Client.HeadObject(ctx, bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("error reading object metadata: %w", err)
+	}
+	if recipient := storage.Metadata(head, RecipientMetadataKey); recipient != "" {
+		return to(recipient), nil
+	}
+
+	segments := strings.Split(key, "/")
+	for _, segment := range segments[:len(segments)-1] {
+		if isAddress(segment) {
+			return to(segment), nil
+		}
+	}
+
+	if r.recipients.key != "" {
+		account := storage.Metadata(head, AccountMetadataKey)
+		if account == "" {
+			account = r.account
+		}
+		recipients, err := r.accountRecipients(ctx, account)
+		if err != nil {
+			return nil, err
+		}
+		if len(recipients) > 0 {
+			return deliveries(recipients), nil
+		}
+	}
+
+	match, found := "", false
+	for prefix := range r.table {
+		if strings.HasPrefix(key, prefix) && (!found || len(prefix) > len(match)) {
+			match, found = prefix, true
+		}
+	}
+	if found {
+		return to(r.table[match]), nil
+	}
+
+	if r.fallback != "" {
+		return to(r.fallback), nil
+	}
+	return nil, fmt.Errorf("no recipient found for s3://%s/%s", bucket, key)
+}
+
+// tableRecipient is a line of the recipients table, as printed by
+// "stori recipients list".
+type tableRecipient struct {
+	Account  string `json:"account"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	OptedOut bool   `json:"opted_out"`
+	Locale   string `json:"locale"`
+}
+
+// accountRecipients reads the recipients of account from the recipients
+// table. It is read on every call, so an updated table applies to the next
+// object.
+func (r recipientResolver) accountRecipients(ctx context.Context, account string) ([]tableRecipient, error) {
+	out, err := r.s3Client.GetObject(ctx, r.recipients.bucket, r.recipients.key)
+	if err != nil {
+		return nil, fmt.Errorf("error reading recipients table: %w", err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading recipients table: %w", err)
+	}
+
+	var recipients []tableRecipient
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var recipient tableRecipient
+		if err := json.Unmarshal(scanner.Bytes(), &recipient); err != nil {
+			return nil, fmt.Errorf("invalid recipients table, line %d: %w", line, err)
+		}
+		if recipient.Account == account {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients, scanner.Err()
+}
+
+// deliveries addresses one email per locale to the recipients that haven't
+// opted out, the way the CLI does. Frequencies aren't honored: the Lambda
+// keeps no record of who was notified when.
+func deliveries(recipients []tableRecipient) []delivery {
+	var result []delivery
+	byLocale := make(map[string]int)
+	for _, r := range recipients {
+		if r.OptedOut {
+			continue
+		}
+		i, ok := byLocale[r.Locale]
+		if !ok {
+			i, byLocale[r.Locale] = len(result), len(result)
+			result = append(result, delivery{locale: r.Locale})
+		}
+		msg := &result[i].msg
+		switch r.Role {
+		case "cc":
+			msg.Cc = append(msg.Cc, r.Email)
+		case "bcc":
+			msg.Bcc = append(msg.Bcc, r.Email)
+		default:
+			msg.To = append(msg.To, r.Email)
+		}
+	}
+	for i := range result {
+		if msg := &result[i].msg; len(msg.To) == 0 {
+			msg.To, msg.Cc = msg.Cc, nil
+		}
+	}
+	return result
+}
+
+func isAddress(value string) bool {
+	addr, err := mail.ParseAddress(value)
+	return err == nil && addr.Address == value
+}
@@ -1,36 +1,176 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
 	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/category"
 	"stori-technical-challenge/pkg/db"
 	"stori-technical-challenge/pkg/email"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/metrics"
+	"stori-technical-challenge/pkg/outbox"
+	"stori-technical-challenge/pkg/pipeline"
+	"stori-technical-challenge/pkg/tracing"
 	"stori-technical-challenge/pkg/transactions"
-)
+	"time"
 
-const (
-	Subject               = "Stori - Transaction Summary"
-	FilePath              = "txns.csv"
-	FilePathEmailTemplate = "pkg/email/email_template.html"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+const FilePath = "txns.csv"
+
+// templates renders the summary email; -template-dir overrides it.
+var templates = email.DefaultTemplates
+
+// bindTemplateFlag registers -template-dir on fs. Call the returned function
+// after parsing to apply it.
+func bindTemplateFlag(fs *flag.FlagSet) func() {
+	dir := fs.String("template-dir", os.Getenv("EMAIL_TEMPLATE_DIR"), "directory whose templates override the built-in ones (overrides $EMAIL_TEMPLATE_DIR)")
+	return func() {
+		if *dir != "" {
+			templates = email.NewRegistry(*dir)
+		}
+	}
+}
+
 func main() {
-	if err := initializeApp(); err != nil {
-		log.Fatalf("Failed to initialize application: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfigCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "outbox" {
+		if err := runOutboxCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "recipients" {
+		if err := runRecipientsCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "budgets" {
+		if err := runBudgetsCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "statement" {
+		if err := runStatementCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExportCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		if err := runServeCommand(os.Args[2:]); err != nil {
+			slog.Error("server failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fs := flag.NewFlagSet("stori", flag.ExitOnError)
+	configFlags := config.BindFlags(fs)
+	metricsFile := fs.String("metrics-file", os.Getenv("METRICS_TEXTFILE"), "write Prometheus metrics to this file when the run ends (overrides $METRICS_TEXTFILE)")
+	applyTemplateFlag := bindTemplateFlag(fs)
+	fs.Parse(os.Args[1:])
+	applyTemplateFlag()
+
+	// Every line of this run shares one correlation ID
+	logger := logging.WithCorrelationID(logging.FromEnv(), logging.NewCorrelationID())
+	slog.SetDefault(logger)
+
+	ctx := context.Background()
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Options{})
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	start := time.Now()
+	err = initializeApp(configFlags)
+	if err != nil {
+		logger.Error("failed to initialize application", "outcome", "failure", "error", err)
+	} else if err = processAndSendTransactions(ctx, logger); err != nil {
+		logger.Error("failed to process transactions", "outcome", "failure", "error", err, "duration_ms", logging.Since(start))
+	}
+
+	// Written on failure too, so the textfile collector sees failed runs
+	if *metricsFile != "" {
+		if writeErr := metrics.WriteTextfile(*metricsFile); writeErr != nil {
+			logger.Error("failed to write metrics", "path", *metricsFile, "error", writeErr)
+		}
+	}
+	if shutdownErr := shutdownTracing(ctx); shutdownErr != nil {
+		logger.Error("failed to flush traces", "error", shutdownErr)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+
+	logger.Info("application finished", "outcome", "success", "duration_ms", logging.Since(start))
+}
+
+// runConfigCommand implements "config check", which prints the effective
+// configuration with secrets redacted and fails if it is invalid.
+func runConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: %s config check [flags]", os.Args[0])
 	}
 
-	if err := processAndSendTransactions(); err != nil {
-		log.Fatalf("Failed to process transactions: %v", err)
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	configFlags := config.BindFlags(fs)
+	fs.Parse(args[1:])
+
+	cfg, err := config.Load(config.Options{Flags: configFlags, RecipientOptional: true})
+	var validationErr *config.ValidationError
+	if err != nil && !errors.As(err, &validationErr) {
+		return err
+	}
+
+	if err := cfg.Print(os.Stdout); err != nil {
+		return fmt.Errorf("printing configuration: %w", err)
+	}
+	if validationErr != nil {
+		fmt.Fprintln(os.Stderr, validationErr)
+		return errors.New("configuration check failed")
+	}
+	if _, err := category.Load(cfg.CategoryRules); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errors.New("configuration check failed")
 	}
 
-	log.Println("Application finished successfully")
+	fmt.Fprintln(os.Stderr, "configuration OK")
+	return nil
 }
 
-func initializeApp() error {
-	if err := config.LoadConfig(); err != nil {
+func initializeApp(configFlags *config.Flags) error {
+	// to_email is only a fallback for accounts without recipients
+	cfg, err := config.Load(config.Options{Flags: configFlags, RecipientOptional: true})
+	if err != nil {
 		return fmt.Errorf("failed to load configuration: %w", err)
 	}
+	config.AppConfig = cfg
 
 	if err := db.InitDB(); err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
@@ -39,54 +179,128 @@ func initializeApp() error {
 	return nil
 }
 
-func processAndSendTransactions() error {
-	reader := transactions.DefaultCSVReader{}
-	processor := transactions.NewProcessor(reader)
+func processAndSendTransactions(ctx context.Context, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "stori.run", attribute.String("source", FilePath))
+	defer func() { tracing.End(span, err) }()
 
-	// Process transactions and get results
-	totalBalance, summary, avgDebit, avgCredit, err := processor.ProcessTransactions(FilePath)
+	categories, err := category.Load(config.AppConfig.CategoryRules)
 	if err != nil {
-		return fmt.Errorf("processing transactions: %w", err)
+		return err
 	}
 
-	// Save processed transactions to database
-	if err := db.SaveTransactionsFromCSV(FilePath); err != nil {
-		return fmt.Errorf("saving transactions to the database: %w", err)
+	db.SetLogger(logger)
+	budgets, err := db.GetBudgets(ctx, config.AppConfig.Account)
+	if err != nil {
+		return err
 	}
-
-	// Send the summary email
-	if err := sendSummaryEmail(totalBalance, summary, avgDebit, avgCredit); err != nil {
-		return fmt.Errorf("sending summary email: %w", err)
+	p := &pipeline.Pipeline{
+		Templates:   templates,
+		Logger:      logger,
+		Account:     statementAccount(config.AppConfig.Account),
+		Categorizer: categories,
+		History: func(ctx context.Context, since time.Time) ([]transactions.Transaction, error) {
+			return db.GetHistory(ctx, config.AppConfig.Account, since)
+		},
+		OpeningBalance: func(ctx context.Context, before time.Time) (float64, error) {
+			return db.BalanceBefore(ctx, config.AppConfig.Account, before)
+		},
+		Budgets: budgets,
 	}
 
-	return nil
-}
+	// Process transactions and render the summary
+	report, err := p.Summarize(ctx, transactions.DefaultCSVReader{}, FilePath)
+	if err != nil {
+		return err
+	}
 
-func sendSummaryEmail(totalBalance float64, summary map[string]transactions.Summary, avgDebit, avgCredit float64) error {
-	// Prepare email data
-	emailData := email.EmailData{
-		TotalBalance:    totalBalance,
-		NumTransactions: map[string]int{}, // Populate with summary data
-		AvgDebitAmount:  avgDebit,
-		AvgCreditAmount: avgCredit,
+	statement, err := os.ReadFile(FilePath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", FilePath, err)
 	}
 
-	// Populate NumTransactions from summary
-	for date, details := range summary {
-		emailData.NumTransactions[date] = details.NumTransactions
+	// Save the transactions and queue the summary and alerts in one
+	// database transaction, so an SMTP or webhook outage can't lose them
+	now := time.Now()
+	messages, notified, err := summaryMessages(ctx, statement, report, now)
+	if err != nil {
+		return err
+	}
+	alerts, err := alertMessages(statement, report)
+	if err != nil {
+		return err
+	}
+	enqueued, err := db.ImportStatement(ctx, db.Import{
+		Source:       FilePath,
+		Account:      config.AppConfig.Account,
+		Transactions: report.Transactions,
+		Messages:     append(messages, alerts...),
+		Notified:     notified,
+		Now:          now,
+	})
+	if err != nil {
+		return fmt.Errorf("importing statement: %w", err)
+	}
+	digests := 0
+	for _, msg := range messages {
+		if msg.RecipientID != 0 {
+			digests++
+		}
+	}
+	switch {
+	case !enqueued:
+		logger.Info("statement already imported, not notifying again", "source", FilePath, "account", config.AppConfig.Account)
+	case digests > 0:
+		logger.Info("summary held in recipients' digests", "source", FilePath, "account", config.AppConfig.Account, "digests", digests)
+	case len(messages[0].To)+len(messages[0].Cc)+len(messages[0].Bcc) == 0:
+		logger.Info("no recipients due, statement imported without notification", "source", FilePath, "account", config.AppConfig.Account)
 	}
 
-	// Render email template
-	body, err := email.RenderTemplate(FilePathEmailTemplate, emailData)
+	// Send the summary and alerts along with anything left from earlier runs
+	sender := newSender(logger)
+	defer sender.Close()
+	result, err := newDispatcher(sender, logger).RunOnce(ctx)
 	if err != nil {
-		return fmt.Errorf("rendering email template: %w", err)
+		return fmt.Errorf("dispatching emails: %w", err)
 	}
 
-	// Send email using SMTPSender
-	sender := email.SMTPSender{}
-	if err := sender.SendEmail(Subject, body); err != nil {
-		return fmt.Errorf("sending email: %w", err)
+	// Only this run's messages fail the run. Those left by earlier runs
+	// already failed the run that queued them, so they're only reported
+	queued := make(map[string]bool)
+	if enqueued {
+		for _, msg := range append(messages, alerts...) {
+			queued[msg.DedupKey] = true
+		}
+	}
+	retried, failed, earlier := 0, 0, 0
+	for key, status := range result.Unsent {
+		switch {
+		case !queued[key]:
+			earlier++
+		case status == db.OutboxFailed:
+			failed++
+		default:
+			retried++
+		}
+	}
+	if earlier > 0 {
+		logger.Warn("messages from earlier runs still not sent", "count", earlier)
+	}
+	if retried > 0 || failed > 0 {
+		return fmt.Errorf("%d messages not sent: %d will be retried, %d failed for good", retried+failed, retried, failed)
 	}
 
 	return nil
 }
+
+// newSender returns a sender that reuses its SMTP connection across the
+// messages of a dispatch, within the configured rate limits.
+func newSender(logger *slog.Logger) *email.PooledSMTPSender {
+	return email.NewPooledSMTPSender(config.AppConfig, email.PoolOptions{Logger: logger})
+}
+
+func newDispatcher(sender email.EmailSender, logger *slog.Logger) *outbox.Dispatcher {
+	return &outbox.Dispatcher{
+		Sender: sender,
+		Logger: logger,
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/db"
+	"stori-technical-challenge/pkg/logging"
+)
+
+// runOutboxCommand implements "outbox list", which prints the delivery status
+// of queued emails as JSON, one message per line, and "outbox dispatch",
+// which sends the ones that are due.
+func runOutboxCommand(args []string, out io.Writer) error {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "dispatch") {
+		return fmt.Errorf("usage: %s outbox list [-status pending|sent|failed|skipped] | dispatch [flags]", os.Args[0])
+	}
+
+	fs := flag.NewFlagSet("outbox "+args[0], flag.ExitOnError)
+	configFlags := config.BindFlags(fs)
+	status := fs.String("status", "", "with list: only show messages with this status")
+	fs.Parse(args[1:])
+
+	if args[0] == "list" {
+		if err := db.InitDB(); err != nil {
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		messages, err := db.GetOutboxMessages(context.Background(), db.OutboxStatus(*status))
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(out)
+		for _, msg := range messages {
+			if err := enc.Encode(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := initializeApp(configFlags); err != nil {
+		return err
+	}
+	logger := logging.WithCorrelationID(logging.FromEnv(), logging.NewCorrelationID())
+	sender := newSender(logger)
+	defer sender.Close()
+	result, err := newDispatcher(sender, logger).RunOnce(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "sent %d, retrying %d, failed %d\n", result.Sent, result.Retried, result.Failed)
+	return nil
+}
@@ -0,0 +1,256 @@
+// Package anomaly flags transactions that look unusual for an account:
+// amounts far outside its normal distribution, months whose debit volume
+// spikes above the usual, and charges that look like duplicates of a
+// recent one. It is a first-pass signal for fraud review, not a verdict.
+package anomaly
+
+import (
+	"math"
+	"sort"
+	"stori-technical-challenge/pkg/transactions"
+	"time"
+)
+
+// Kind is what makes a transaction or a month unusual.
+type Kind string
+
+const (
+	// Outlier is a transaction far larger than the account's usual ones.
+	Outlier Kind = "outlier"
+	// Spike is a month whose debits add up to far more than usual.
+	Spike Kind = "spike"
+	// Duplicate is a charge with the same amount and counterparty as one
+	// shortly before it.
+	Duplicate Kind = "duplicate"
+)
+
+// Options tune detection. The zero value uses DefaultOptions.
+type Options struct {
+	// OutlierThreshold is how many (robust) standard deviations an amount
+	// must be from the median to be an outlier.
+	OutlierThreshold float64
+	// MinSamples is how many transactions of the same sign are needed
+	// before outliers are looked for.
+	MinSamples int
+	// SpikeFactor is how many times the median monthly debit volume a
+	// month must reach to be a spike.
+	SpikeFactor float64
+	// MinMonths is how many earlier months are needed before spikes are
+	// looked for.
+	MinMonths int
+	// DuplicateWindow is how many days apart two matching charges may be
+	// to look like a duplicate.
+	DuplicateWindow int
+}
+
+// DefaultOptions are used for unset fields of Options.
+var DefaultOptions = Options{
+	OutlierThreshold: 3.5,
+	MinSamples:       10,
+	SpikeFactor:      2,
+	MinMonths:        3,
+	DuplicateWindow:  2,
+}
+
+func (o Options) withDefaults() Options {
+	if o.OutlierThreshold == 0 {
+		o.OutlierThreshold = DefaultOptions.OutlierThreshold
+	}
+	if o.MinSamples == 0 {
+		o.MinSamples = DefaultOptions.MinSamples
+	}
+	if o.SpikeFactor == 0 {
+		o.SpikeFactor = DefaultOptions.SpikeFactor
+	}
+	if o.MinMonths == 0 {
+		o.MinMonths = DefaultOptions.MinMonths
+	}
+	if o.DuplicateWindow == 0 {
+		o.DuplicateWindow = DefaultOptions.DuplicateWindow
+	}
+	return o
+}
+
+// Anomaly is one unusual transaction or month.
+type Anomaly struct {
+	Kind Kind `json:"kind"`
+	// Date is the transaction's, or the first day of a spiking month.
+	Date        time.Time `json:"date"`
+	Description string    `json:"description,omitempty"`
+	// Amount is the signed amount of the transaction, or the magnitude of
+	// a spiking month's debits.
+	Amount float64 `json:"amount"`
+	// Baseline is what was usual: the median amount of the same sign for
+	// an outlier, the median monthly debit volume for a spike and the
+	// amount of the earlier charge for a duplicate.
+	Baseline float64 `json:"baseline"`
+	// Score is how far from usual: the robust z-score of an outlier, the
+	// ratio to the baseline of a spike and the days since the earlier
+	// charge for a duplicate.
+	Score float64 `json:"score"`
+	// Month is the spiking month, formatted as transactions.MonthLayout.
+	Month string `json:"month,omitempty"`
+	// Previous is the date of the earlier charge a duplicate matches.
+	Previous *time.Time `json:"previous,omitempty"`
+}
+
+// Detect flags the unusual transactions and months of current, the
+// transactions being imported, against history, the account's earlier
+// ones. History may be empty; current is then compared with itself.
+// Anomalies are returned oldest first.
+func Detect(history, current []transactions.Transaction, opts Options) []Anomaly {
+	opts = opts.withDefaults()
+
+	var anomalies []Anomaly
+	anomalies = append(anomalies, outliers(history, current, opts)...)
+	anomalies = append(anomalies, spikes(history, current, opts)...)
+	anomalies = append(anomalies, duplicates(history, current, opts)...)
+	sort.SliceStable(anomalies, func(i, j int) bool { return anomalies[i].Date.Before(anomalies[j].Date) })
+	return anomalies
+}
+
+// outliers uses the median and the median absolute deviation, which a few
+// huge transactions can't drag along the way they would a mean and a
+// standard deviation. Debits and credits are judged separately, and only
+// amounts larger than usual are flagged.
+func outliers(history, current []transactions.Transaction, opts Options) []Anomaly {
+	var anomalies []Anomaly
+	for _, debit := range []bool{true, false} {
+		var sample []float64
+		for _, t := range append(append([]transactions.Transaction(nil), history...), current...) {
+			if t.Amount != 0 && (t.Amount < 0) == debit {
+				sample = append(sample, math.Abs(t.Amount))
+			}
+		}
+		if len(sample) < opts.MinSamples {
+			continue
+		}
+		usual := median(sample)
+		deviations := make([]float64, len(sample))
+		for i, v := range sample {
+			deviations[i] = math.Abs(v - usual)
+		}
+		// 1.4826 scales the MAD to a standard deviation for normal data
+		scale := 1.4826 * median(deviations)
+		if scale == 0 {
+			continue
+		}
+
+		for _, t := range current {
+			if t.Amount == 0 || (t.Amount < 0) != debit {
+				continue
+			}
+			if score := (math.Abs(t.Amount) - usual) / scale; score > opts.OutlierThreshold {
+				baseline := usual
+				if debit {
+					baseline = -usual
+				}
+				anomalies = append(anomalies, Anomaly{Kind: Outlier, Date: t.Date, Description: t.Description, Amount: t.Amount, Baseline: baseline, Score: score})
+			}
+		}
+	}
+	return anomalies
+}
+
+// spikes compares the debit volume of each month in current with the
+// median of the months before it, up to a year back.
+func spikes(history, current []transactions.Transaction, opts Options) []Anomaly {
+	volumes := make(map[string]float64)
+	for _, t := range append(append([]transactions.Transaction(nil), history...), current...) {
+		// Months with only credits count too, as months without spending
+		volumes[t.Date.Format(transactions.MonthLayout)] += math.Max(0, -t.Amount)
+	}
+	months := make([]string, 0, len(volumes))
+	for month := range volumes {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+
+	checked := make(map[string]bool)
+	var anomalies []Anomaly
+	for _, t := range current {
+		month := t.Date.Format(transactions.MonthLayout)
+		if checked[month] {
+			continue
+		}
+		checked[month] = true
+
+		i := sort.SearchStrings(months, month)
+		earlier := months[max(0, i-12):i]
+		if len(earlier) < opts.MinMonths {
+			continue
+		}
+		baseline := make([]float64, len(earlier))
+		for j, m := range earlier {
+			baseline[j] = volumes[m]
+		}
+		usual := median(baseline)
+		if usual > 0 && volumes[month] > opts.SpikeFactor*usual {
+			start, _ := time.Parse(transactions.MonthLayout, month)
+			anomalies = append(anomalies, Anomaly{Kind: Spike, Date: start, Amount: volumes[month], Baseline: usual, Score: volumes[month] / usual, Month: month})
+		}
+	}
+	return anomalies
+}
+
+// duplicates flags each charge in current that has the same amount and
+// counterparty as an earlier one within the window. Charges without a
+// description match on the amount alone.
+func duplicates(history, current []transactions.Transaction, opts Options) []Anomaly {
+	type charge struct {
+		transactions.Transaction
+		current bool
+	}
+	var charges []charge
+	for _, t := range history {
+		if t.Amount < 0 {
+			charges = append(charges, charge{t, false})
+		}
+	}
+	for _, t := range current {
+		if t.Amount < 0 {
+			charges = append(charges, charge{t, true})
+		}
+	}
+	sort.SliceStable(charges, func(i, j int) bool { return charges[i].Date.Before(charges[j].Date) })
+
+	window := time.Duration(opts.DuplicateWindow) * 24 * time.Hour
+	var anomalies []Anomaly
+	for i, c := range charges {
+		if !c.current {
+			continue
+		}
+		// The nearest earlier match, so a triple charge is two duplicates
+		for j := i - 1; j >= 0 && c.Date.Sub(charges[j].Date) <= window; j-- {
+			earlier := charges[j]
+			if earlier.Amount != c.Amount || transactions.Counterparty(earlier.Description) != transactions.Counterparty(c.Description) {
+				continue
+			}
+			previous := earlier.Date
+			anomalies = append(anomalies, Anomaly{
+				Kind:        Duplicate,
+				Date:        c.Date,
+				Description: c.Description,
+				Amount:      c.Amount,
+				Baseline:    earlier.Amount,
+				Score:       c.Date.Sub(previous).Hours() / 24,
+				Previous:    &previous,
+			})
+			break
+		}
+	}
+	return anomalies
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
@@ -0,0 +1,111 @@
+package anomaly_test
+
+import (
+	"fmt"
+	"stori-technical-challenge/pkg/anomaly"
+	"stori-technical-challenge/pkg/transactions"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func day(month time.Month, d int) time.Time {
+	return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func tx(date time.Time, amount float64, description string) transactions.Transaction {
+	return transactions.Transaction{Date: date, Amount: amount, Description: description}
+}
+
+// everyday is three months of ordinary spending: a charge of 20 to 40 every
+// few days and the salary on the first.
+func everyday() []transactions.Transaction {
+	var history []transactions.Transaction
+	for month := time.April; month <= time.June; month++ {
+		history = append(history, tx(day(month, 1), 2500, "Payroll"))
+		for d := 2; d <= 28; d += 3 {
+			history = append(history, tx(day(month, d), -float64(20+d), fmt.Sprintf("Shop %d", d)))
+		}
+	}
+	return history
+}
+
+func kinds(anomalies []anomaly.Anomaly) []anomaly.Kind {
+	var kinds []anomaly.Kind
+	for _, a := range anomalies {
+		kinds = append(kinds, a.Kind)
+	}
+	return kinds
+}
+
+func TestDetectNothingUnusual(t *testing.T) {
+	current := []transactions.Transaction{
+		tx(day(7, 1), 2500, "Payroll"),
+		tx(day(7, 4), -31, "Shop"),
+		tx(day(7, 9), -26, "Shop"),
+	}
+	assert.Empty(t, anomaly.Detect(everyday(), current, anomaly.Options{}))
+}
+
+func TestDetectOutlier(t *testing.T) {
+	current := []transactions.Transaction{
+		tx(day(7, 1), 2500, "Payroll"),
+		tx(day(7, 4), -1899, "Electronics outlet"),
+		tx(day(7, 5), -35, "Shop"),
+	}
+	anomalies := anomaly.Detect(everyday(), current, anomaly.Options{})
+	require.Equal(t, []anomaly.Kind{anomaly.Spike, anomaly.Outlier}, kinds(anomalies), "one such charge is a month's spending already")
+
+	a := anomalies[1]
+	assert.Equal(t, day(7, 4), a.Date)
+	assert.Equal(t, "Electronics outlet", a.Description)
+	assert.Equal(t, -1899.0, a.Amount)
+	assert.Equal(t, -34.0, a.Baseline, "the median debit")
+	assert.Greater(t, a.Score, 100.0)
+}
+
+func TestDetectNeedsEnoughHistoryForOutliers(t *testing.T) {
+	current := []transactions.Transaction{tx(day(7, 2), -30, "Shop"), tx(day(7, 4), -1899, "Electronics outlet")}
+	assert.Empty(t, anomaly.Detect(nil, current, anomaly.Options{}))
+}
+
+func TestDetectSpike(t *testing.T) {
+	current := []transactions.Transaction{tx(day(7, 1), 2500, "Payroll")}
+	// Ordinary amounts, but far too many of them
+	for d := 2; d <= 30; d++ {
+		current = append(current, tx(day(7, d), -40, "Shop "+string(rune('A'+d%26))))
+	}
+	anomalies := anomaly.Detect(everyday(), current, anomaly.Options{})
+	require.Equal(t, []anomaly.Kind{anomaly.Spike}, kinds(anomalies))
+
+	a := anomalies[0]
+	assert.Equal(t, "2024-07", a.Month)
+	assert.Equal(t, day(7, 1), a.Date)
+	assert.Equal(t, 1160.0, a.Amount)
+	assert.Equal(t, 306.0, a.Baseline, "every earlier month spent the same")
+	assert.InDelta(t, 3.79, a.Score, 0.01)
+}
+
+func TestDetectDuplicates(t *testing.T) {
+	history := append(everyday(), tx(day(6, 30), -15.99, "NETFLIX.COM 1234"))
+	current := []transactions.Transaction{
+		tx(day(7, 1), -15.99, "Netflix.com 5678"),
+		tx(day(7, 3), -40, ""),
+		tx(day(7, 3), -40, ""),
+		tx(day(7, 4), -40, ""),
+		// Too far apart, and a different merchant
+		tx(day(7, 10), -40, ""),
+		tx(day(7, 10), -15.99, "Spotify"),
+	}
+	anomalies := anomaly.Detect(history, current, anomaly.Options{})
+	require.Equal(t, []anomaly.Kind{anomaly.Duplicate, anomaly.Duplicate, anomaly.Duplicate}, kinds(anomalies))
+
+	require.NotNil(t, anomalies[0].Previous)
+	assert.Equal(t, day(6, 30), *anomalies[0].Previous, "matched against the history")
+	assert.Equal(t, 1.0, anomalies[0].Score)
+	assert.Equal(t, day(7, 3), *anomalies[1].Previous)
+	assert.Equal(t, 0.0, anomalies[1].Score)
+	assert.Equal(t, day(7, 3), *anomalies[2].Previous, "the nearest earlier match")
+}
@@ -0,0 +1,71 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SchemaVersion is the version of the Notice JSON document. Fields may be
+// added without changing it; renaming or removing one bumps it.
+const SchemaVersion = 1
+
+// Notice is what an alert says: the anomalies found importing one
+// statement of an account.
+type Notice struct {
+	SchemaVersion int       `json:"schema_version"`
+	Account       string    `json:"account"`
+	Source        string    `json:"source"`
+	Anomalies     []Anomaly `json:"anomalies"`
+}
+
+// NewNotice returns the notice about anomalies found in source.
+func NewNotice(account, source string, anomalies []Anomaly) Notice {
+	return Notice{SchemaVersion: SchemaVersion, Account: account, Source: source, Anomalies: anomalies}
+}
+
+// Webhook posts notices as JSON to URL.
+type Webhook struct {
+	URL string
+	// Client sends the request. Defaults to a client with a 10 second
+	// timeout.
+	Client *http.Client
+}
+
+// Post sends n to the webhook. Any status other than 2xx is an error.
+func (w Webhook) Post(ctx context.Context, n Notice) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return w.PostJSON(ctx, body)
+}
+
+// PostJSON sends a JSON document already encoded, such as a Notice queued
+// in the outbox, to the webhook.
+func (w Webhook) PostJSON(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package anomaly_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"stori-technical-challenge/pkg/anomaly"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPostsNotice(t *testing.T) {
+	var got anomaly.Notice
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	notice := anomaly.NewNotice("default", "txns.csv", []anomaly.Anomaly{{Kind: anomaly.Outlier, Date: day(7, 4), Amount: -1899, Baseline: -35, Score: 120}})
+	require.NoError(t, anomaly.Webhook{URL: server.URL}.Post(context.Background(), notice))
+	assert.Equal(t, notice, got)
+	assert.Equal(t, anomaly.SchemaVersion, got.SchemaVersion)
+}
+
+func TestWebhookReportsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := anomaly.Webhook{URL: server.URL}.Post(context.Background(), anomaly.NewNotice("default", "txns.csv", nil))
+	assert.ErrorContains(t, err, "503 Service Unavailable: try later")
+}
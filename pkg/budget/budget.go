@@ -0,0 +1,176 @@
+// Package budget checks an account's monthly spending budgets and low
+// balance threshold after an import, so the summary can point out what
+// needs attention.
+package budget
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"stori-technical-challenge/pkg/transactions"
+	"strings"
+)
+
+// Kind is what a budget limits.
+type Kind string
+
+const (
+	// Spending caps the debits of a month, overall or in one category.
+	Spending Kind = "spending"
+	// MinBalance is the balance below which the account is running low.
+	MinBalance Kind = "min_balance"
+)
+
+// Budget is a monthly spending limit or a low balance threshold of an
+// account.
+type Budget struct {
+	ID      int64  `json:"id"`
+	Account string `json:"account"`
+	Kind    Kind   `json:"kind"`
+	// Category limits a spending budget to one category. Empty means all
+	// debits; balance thresholds have none.
+	Category string `json:"category,omitempty"`
+	// Amount is the most to spend in a month, as a positive number, or
+	// the lowest acceptable balance.
+	Amount float64 `json:"amount"`
+}
+
+// Validate fills in defaults and checks the kind and amount.
+func (b *Budget) Validate() error {
+	if b.Account == "" {
+		return fmt.Errorf("account is required")
+	}
+	if b.Kind == "" {
+		b.Kind = Spending
+	}
+	switch b.Kind {
+	case Spending:
+		if b.Amount <= 0 {
+			return fmt.Errorf("a spending budget must be positive, got %g", b.Amount)
+		}
+	case MinBalance:
+		if b.Category != "" {
+			return fmt.Errorf("a balance threshold has no category")
+		}
+	default:
+		return fmt.Errorf("invalid budget kind %q: use spending or min_balance", b.Kind)
+	}
+	return nil
+}
+
+// Status is how a budget stands.
+type Status string
+
+const (
+	OK Status = "ok"
+	// Warning is a spending budget that is nearly used up.
+	Warning Status = "warning"
+	// Breached is an overspent budget or a balance below its threshold.
+	Breached Status = "breached"
+)
+
+// WarningRatio is the share of a spending budget from which it's nearly
+// used up.
+const WarningRatio = 0.8
+
+// Result is a spending budget checked for one month, or the balance
+// threshold checked against the balance.
+type Result struct {
+	Budget Budget `json:"budget"`
+	// Month is the month a spending budget was checked for, formatted as
+	// transactions.MonthLayout.
+	Month string `json:"month,omitempty"`
+	// Actual is what was spent in the month, as a positive number, or the
+	// balance.
+	Actual float64 `json:"actual"`
+	Status Status  `json:"status"`
+}
+
+// Used is the share of a spending budget that was spent.
+func (r Result) Used() float64 {
+	if r.Budget.Amount == 0 {
+		return 0
+	}
+	return r.Actual / r.Budget.Amount
+}
+
+// Remaining is what is left of a spending budget, negative when overspent.
+func (r Result) Remaining() float64 {
+	return r.Budget.Amount - r.Actual
+}
+
+// Over is how much a spending budget was overspent by, zero otherwise.
+func (r Result) Over() float64 {
+	return math.Max(0, -r.Remaining())
+}
+
+// Evaluate checks budgets for every month in current, the transactions
+// being imported, counting the spending in history, the account's stored
+// transactions, that falls in those months too. The balance threshold is
+// checked against balance. Results come balance threshold first, then by
+// month in the order of budgets.
+func Evaluate(budgets []Budget, history, current []transactions.Transaction, balance float64) []Result {
+	months := make(map[string]bool)
+	for _, t := range current {
+		months[t.Date.Format(transactions.MonthLayout)] = true
+	}
+	sorted := make([]string, 0, len(months))
+	for month := range months {
+		sorted = append(sorted, month)
+	}
+	sort.Strings(sorted)
+
+	// spent[month][category] is the magnitude of the debits; "" totals them
+	spent := make(map[string]map[string]float64)
+	for _, t := range append(append([]transactions.Transaction(nil), history...), current...) {
+		month := t.Date.Format(transactions.MonthLayout)
+		if t.Amount >= 0 || !months[month] {
+			continue
+		}
+		if spent[month] == nil {
+			spent[month] = make(map[string]float64)
+		}
+		spent[month][""] -= t.Amount
+		if t.Category != "" {
+			spent[month][strings.ToLower(t.Category)] -= t.Amount
+		}
+	}
+
+	var results []Result
+	for _, b := range budgets {
+		if b.Kind == MinBalance {
+			status := OK
+			if balance < b.Amount {
+				status = Breached
+			}
+			results = append(results, Result{Budget: b, Actual: balance, Status: status})
+		}
+	}
+	for _, month := range sorted {
+		for _, b := range budgets {
+			if b.Kind != Spending {
+				continue
+			}
+			// Rounded to cents so float sums don't breach a budget by a hair
+			r := Result{Budget: b, Month: month, Actual: math.Round(spent[month][strings.ToLower(b.Category)]*100) / 100, Status: OK}
+			switch {
+			case r.Actual > b.Amount:
+				r.Status = Breached
+			case r.Actual >= WarningRatio*b.Amount:
+				r.Status = Warning
+			}
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// NeedsAttention reports whether any budget was breached.
+func NeedsAttention(results []Result) bool {
+	for _, r := range results {
+		if r.Status == Breached {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package budget_test
+
+import (
+	"stori-technical-challenge/pkg/budget"
+	"stori-technical-challenge/pkg/transactions"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tx(month time.Month, day int, amount float64, category string) transactions.Transaction {
+	return transactions.Transaction{Date: time.Date(2024, month, day, 0, 0, 0, 0, time.UTC), Amount: amount, Category: category}
+}
+
+func TestEvaluate(t *testing.T) {
+	budgets := []budget.Budget{
+		{Kind: budget.MinBalance, Amount: 500},
+		{Kind: budget.Spending, Amount: 1000},
+		{Kind: budget.Spending, Category: "restaurants", Amount: 100},
+		{Kind: budget.Spending, Category: "Groceries", Amount: 300},
+	}
+	history := []transactions.Transaction{
+		// Earlier in August, imported with a previous file
+		tx(8, 1, -60, "Restaurants"),
+		// Another month, so it doesn't count
+		tx(7, 30, -500, "Groceries"),
+	}
+	current := []transactions.Transaction{
+		tx(8, 5, 2500, "Income"),
+		tx(8, 9, -45.5, "Restaurants"),
+		tx(8, 12, -250, "Groceries"),
+		tx(8, 20, -120, ""),
+	}
+	results := budget.Evaluate(budgets, history, current, 420)
+	require.Len(t, results, 4)
+
+	balance := results[0]
+	assert.Equal(t, budget.MinBalance, balance.Budget.Kind)
+	assert.Equal(t, 420.0, balance.Actual)
+	assert.Equal(t, budget.Breached, balance.Status)
+
+	overall, restaurants, groceries := results[1], results[2], results[3]
+	assert.Equal(t, "2024-08", overall.Month)
+	assert.Equal(t, 475.5, overall.Actual)
+	assert.Equal(t, budget.OK, overall.Status)
+	assert.Equal(t, 105.5, restaurants.Actual, "categories match regardless of case")
+	assert.Equal(t, budget.Breached, restaurants.Status)
+	assert.Equal(t, -5.5, restaurants.Remaining())
+	assert.Equal(t, 5.5, restaurants.Over())
+	assert.Equal(t, 250.0, groceries.Actual)
+	assert.Equal(t, budget.Warning, groceries.Status)
+	assert.InDelta(t, 0.833, groceries.Used(), 0.001)
+
+	assert.True(t, budget.NeedsAttention(results))
+	assert.False(t, budget.NeedsAttention(results[1:2]))
+}
+
+func TestEvaluateEveryMonthOfTheFile(t *testing.T) {
+	results := budget.Evaluate([]budget.Budget{{Kind: budget.Spending, Amount: 50}}, nil, []transactions.Transaction{tx(8, 2, -60, ""), tx(7, 30, -10, "")}, 0)
+	require.Len(t, results, 2)
+	assert.Equal(t, "2024-07", results[0].Month)
+	assert.Equal(t, budget.OK, results[0].Status)
+	assert.Equal(t, "2024-08", results[1].Month)
+	assert.Equal(t, budget.Breached, results[1].Status)
+}
+
+func TestBudgetValidate(t *testing.T) {
+	b := budget.Budget{Account: "a", Amount: 5}
+	require.NoError(t, b.Validate())
+	assert.Equal(t, budget.Spending, b.Kind, "kind defaults to spending")
+
+	assert.ErrorContains(t, (&budget.Budget{Account: "a", Amount: -5}).Validate(), "must be positive")
+	assert.ErrorContains(t, (&budget.Budget{Account: "a", Kind: budget.MinBalance, Category: "Food"}).Validate(), "no category")
+	assert.ErrorContains(t, (&budget.Budget{Account: "a", Kind: "weekly", Amount: 5}).Validate(), "invalid budget kind")
+	assert.NoError(t, (&budget.Budget{Account: "a", Kind: budget.MinBalance, Amount: 0}).Validate(), "a zero threshold warns about overdrafts")
+}
@@ -0,0 +1,204 @@
+// Package category assigns spending categories to transactions with an
+// ordered list of rules, and totals spending by category.
+package category
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"stori-technical-challenge/pkg/transactions"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Uncategorized is the category of transactions no rule matches.
+const Uncategorized = "Uncategorized"
+
+//go:embed rules.yaml
+var defaultRules []byte
+
+// Rule assigns Category to the transactions that meet all of its
+// conditions. A rule needs at least one condition.
+type Rule struct {
+	Category string `yaml:"category"`
+	// Merchants match descriptions exactly, ignoring case and surrounding
+	// or repeated spaces.
+	Merchants []string `yaml:"merchants"`
+	// Pattern is a regular expression searched for in the description.
+	Pattern string `yaml:"pattern"`
+	// MinAmount and MaxAmount bound the signed amount, inclusive: debits
+	// are negative.
+	MinAmount *float64 `yaml:"min_amount"`
+	MaxAmount *float64 `yaml:"max_amount"`
+}
+
+// Engine categorizes transactions. The first rule that matches wins.
+type Engine struct {
+	rules []rule
+}
+
+type rule struct {
+	Rule
+	merchants map[string]bool
+	pattern   *regexp.Regexp
+}
+
+// New compiles rules, reporting every invalid one.
+func New(rules []Rule) (*Engine, error) {
+	e := &Engine{}
+	var problems []string
+	for i, r := range rules {
+		compiled, err := compile(r)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("rule %d (%s): %v", i+1, r.Category, err))
+			continue
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid category rules:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return e, nil
+}
+
+func compile(r Rule) (rule, error) {
+	c := rule{Rule: r}
+	if strings.TrimSpace(r.Category) == "" {
+		return c, fmt.Errorf("category is required")
+	}
+	if len(r.Merchants) == 0 && r.Pattern == "" && r.MinAmount == nil && r.MaxAmount == nil {
+		return c, fmt.Errorf("needs merchants, a pattern or an amount range")
+	}
+	if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+		return c, fmt.Errorf("min_amount %g is greater than max_amount %g", *r.MinAmount, *r.MaxAmount)
+	}
+	if r.Pattern != "" {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return c, fmt.Errorf("invalid pattern: %w", err)
+		}
+		c.pattern = pattern
+	}
+	if len(r.Merchants) > 0 {
+		c.merchants = make(map[string]bool, len(r.Merchants))
+		for _, m := range r.Merchants {
+			c.merchants[normalize(m)] = true
+		}
+	}
+	return c, nil
+}
+
+// Parse reads rules from a YAML document with a top-level "rules" list.
+func Parse(data []byte) (*Engine, error) {
+	var doc struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing category rules: %w", err)
+	}
+	return New(doc.Rules)
+}
+
+// Load reads rules from the YAML file at path, or returns the built-in
+// rules when path is empty.
+func Load(path string) (*Engine, error) {
+	if path == "" {
+		return Default(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading category rules: %w", err)
+	}
+	return Parse(data)
+}
+
+var builtin = func() *Engine {
+	e, err := Parse(defaultRules)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}()
+
+// Default returns the built-in rules.
+func Default() *Engine {
+	return builtin
+}
+
+// Categorize returns the category of a transaction, or Uncategorized.
+func (e *Engine) Categorize(description string, amount float64) string {
+	merchant := normalize(description)
+	for _, r := range e.rules {
+		if r.MinAmount != nil && amount < *r.MinAmount {
+			continue
+		}
+		if r.MaxAmount != nil && amount > *r.MaxAmount {
+			continue
+		}
+		if r.merchants != nil && !r.merchants[merchant] {
+			continue
+		}
+		if r.pattern != nil && !r.pattern.MatchString(description) {
+			continue
+		}
+		return r.Category
+	}
+	return Uncategorized
+}
+
+func normalize(merchant string) string {
+	return strings.ToLower(strings.Join(strings.Fields(merchant), " "))
+}
+
+// Total is the spending in one category.
+type Total struct {
+	Category string
+	// Amount is the magnitude of the category's debits.
+	Amount float64
+	Count  int
+	// Share is Amount as a fraction of all spending, between 0 and 1.
+	Share float64
+}
+
+// Spending totals the debits of txs by category, largest first. Credits
+// aren't spending and are left out.
+func Spending(txs []transactions.Transaction) []Total {
+	byCategory := make(map[string]*Total)
+	var totals []*Total
+	spent := 0.0
+	for _, t := range txs {
+		if t.Amount >= 0 {
+			continue
+		}
+		name := t.Category
+		if name == "" {
+			name = Uncategorized
+		}
+		total, ok := byCategory[name]
+		if !ok {
+			total = &Total{Category: name}
+			byCategory[name] = total
+			totals = append(totals, total)
+		}
+		total.Amount += math.Abs(t.Amount)
+		total.Count++
+		spent += math.Abs(t.Amount)
+	}
+
+	result := make([]Total, len(totals))
+	for i, t := range totals {
+		t.Share = t.Amount / spent
+		result[i] = *t
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Amount != result[j].Amount {
+			return result[i].Amount > result[j].Amount
+		}
+		return result[i].Category < result[j].Category
+	})
+	return result
+}
@@ -0,0 +1,119 @@
+package category_test
+
+import (
+	"path/filepath"
+	"stori-technical-challenge/pkg/category"
+	"stori-technical-challenge/pkg/transactions"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCategorize(t *testing.T) {
+	engine, err := category.Parse([]byte(`
+rules:
+  - category: Big purchases
+    max_amount: -1000
+  - category: Coffee
+    merchants: [Starbucks, "Cafe  Punta del Cielo"]
+    max_amount: 0
+  - category: Rides
+    pattern: (?i)^uber\b
+  - category: Refunds
+    pattern: (?i)refund
+    min_amount: 0.01
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		description string
+		amount      float64
+		want        string
+	}{
+		{"Starbucks", -4.5, "Coffee"},
+		{"  cafe punta del   cielo ", -3, "Coffee"},
+		{"Starbucks Reserve", -4.5, category.Uncategorized},
+		{"Starbucks", 4.5, category.Uncategorized},
+		{"Starbucks", -1500, "Big purchases"},
+		{"UBER *TRIP", -12, "Rides"},
+		{"Super Uber", -12, category.Uncategorized},
+		{"Refund from store", 20, "Refunds"},
+		{"Refund from store", -20, category.Uncategorized},
+		{"", -1000, "Big purchases"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, engine.Categorize(tt.description, tt.amount), "%q %g", tt.description, tt.amount)
+	}
+}
+
+func TestDefaultRules(t *testing.T) {
+	engine := category.Default()
+	assert.Equal(t, "Income", engine.Categorize("Nomina julio", 2500))
+	assert.Equal(t, "Subscriptions", engine.Categorize("Netflix", -15.99))
+	assert.Equal(t, "Transport", engine.Categorize("UBER *TRIP", -42.1))
+	assert.Equal(t, "Restaurants", engine.Categorize("UBER EATS MX", -18.5))
+	assert.Equal(t, "Restaurants", engine.Categorize("DiDi Food", -12))
+	assert.Equal(t, "Groceries", engine.Categorize("Walmart Supercenter", -120.35))
+	assert.Equal(t, category.Uncategorized, engine.Categorize("Unknown shop", -8.5))
+	assert.Equal(t, "Income", engine.Categorize("", 10))
+}
+
+// TestExampleRulesMatchDefault keeps the example users copy in line with
+// the built-in rules.
+func TestExampleRulesMatchDefault(t *testing.T) {
+	example, err := category.Load(filepath.Join("..", "..", "config", "category_rules.example.yaml"))
+	require.NoError(t, err)
+	engine := category.Default()
+	for _, tt := range []struct {
+		description string
+		amount      float64
+	}{
+		{"Nomina julio", 2500},
+		{"Netflix", -15.99},
+		{"UBER *TRIP", -42.1},
+		{"UBER EATS MX", -18.5},
+		{"DiDi Food", -12},
+		{"Walmart Supercenter", -120.35},
+		{"SPEI enviado", -300},
+		{"Unknown shop", -8.5},
+	} {
+		assert.Equal(t, engine.Categorize(tt.description, tt.amount), example.Categorize(tt.description, tt.amount), "%q", tt.description)
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	low, high := 10.0, 5.0
+	_, err := category.New([]category.Rule{
+		{Category: "", Pattern: "x"},
+		{Category: "Empty"},
+		{Category: "Bad pattern", Pattern: "("},
+		{Category: "Bad range", MinAmount: &low, MaxAmount: &high},
+		{Category: "Fine", Pattern: "ok"},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rule 1 (): category is required")
+	assert.Contains(t, err.Error(), "rule 2 (Empty): needs merchants")
+	assert.Contains(t, err.Error(), "rule 3 (Bad pattern): invalid pattern")
+	assert.Contains(t, err.Error(), "rule 4 (Bad range): min_amount 10 is greater than max_amount 5")
+	assert.NotContains(t, err.Error(), "Fine")
+}
+
+func TestSpending(t *testing.T) {
+	totals := category.Spending([]transactions.Transaction{
+		{Amount: 2500, Category: "Income"},
+		{Amount: -15.99, Category: "Subscriptions"},
+		{Amount: -60, Category: "Groceries"},
+		{Amount: -15.99, Category: "Subscriptions"},
+		{Amount: -8.02},
+	})
+	require.Len(t, totals, 3)
+	assert.Equal(t, "Groceries", totals[0].Category)
+	assert.Equal(t, "Subscriptions", totals[1].Category)
+	assert.Equal(t, 2, totals[1].Count)
+	assert.InDelta(t, 31.98, totals[1].Amount, 1e-9)
+	assert.Equal(t, category.Uncategorized, totals[2].Category)
+	assert.InDelta(t, 0.6, totals[0].Share, 1e-9)
+
+	assert.Empty(t, category.Spending([]transactions.Transaction{{Amount: 10}}))
+}
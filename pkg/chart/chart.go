@@ -0,0 +1,290 @@
+// Package chart draws the PNG charts embedded in the summary email. Charts
+// are drawn with the standard library and a bitmap font so they look the
+// same everywhere and need no external renderer.
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// Width and Height are the size of every chart, in pixels.
+	Width  = 600
+	Height = 280
+
+	padLeft   = 70
+	padRight  = 20
+	padTop    = 36
+	padBottom = 40
+	ticks     = 4
+)
+
+var (
+	background = color.RGBA{255, 255, 255, 255}
+	grid       = color.RGBA{226, 230, 232, 255}
+	axis       = color.RGBA{120, 120, 120, 255}
+	text       = color.RGBA{70, 70, 70, 255}
+	// CreditColor and DebitColor match the statement's palette.
+	CreditColor = color.RGBA{0, 168, 120, 255}
+	DebitColor  = color.RGBA{226, 80, 70, 255}
+	LineColor   = color.RGBA{0, 59, 70, 255}
+)
+
+// Bar is one group of the credits vs debits chart.
+type Bar struct {
+	Label   string
+	Credits float64
+	// Debits are drawn by magnitude; their sign is ignored.
+	Debits float64
+}
+
+// Point is one point of the balance line.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// CreditsDebits draws a bar chart with the credits and debits of each bar
+// side by side, as a PNG.
+func CreditsDebits(title string, bars []Bar) ([]byte, error) {
+	c := newCanvas(title)
+	c.legend([]string{"Credits", "Debits"}, []color.Color{CreditColor, DebitColor})
+
+	high := 0.0
+	for _, b := range bars {
+		high = math.Max(high, math.Max(b.Credits, math.Abs(b.Debits)))
+	}
+	scale := newScale(0, high)
+	c.yAxis(scale)
+
+	if len(bars) > 0 {
+		group := float64(c.plot.Dx()) / float64(len(bars))
+		barWidth := int(math.Min(group*0.35, 48))
+		for i, b := range bars {
+			center := c.plot.Min.X + int(group*(float64(i)+0.5))
+			c.bar(center-barWidth, barWidth, scale.y(c.plot, b.Credits), scale.y(c.plot, 0), CreditColor)
+			c.bar(center, barWidth, scale.y(c.plot, math.Abs(b.Debits)), scale.y(c.plot, 0), DebitColor)
+			c.label(b.Label, center, c.plot.Max.Y+18, alignCenter)
+		}
+	}
+	return c.png()
+}
+
+// Balance draws points as a line over time, as a PNG. The zero line is
+// always visible so an overdrawn balance stands out.
+func Balance(title string, points []Point) ([]byte, error) {
+	c := newCanvas(title)
+
+	low, high := 0.0, 0.0
+	for _, p := range points {
+		low, high = math.Min(low, p.Value), math.Max(high, p.Value)
+	}
+	scale := newScale(low, high)
+	c.yAxis(scale)
+	zero := scale.y(c.plot, 0)
+	c.hline(c.plot.Min.X, c.plot.Max.X, zero, axis)
+
+	if len(points) == 0 {
+		return c.png()
+	}
+	first, last := points[0].Time, points[len(points)-1].Time
+	x := func(t time.Time) int {
+		if !last.After(first) {
+			return (c.plot.Min.X + c.plot.Max.X) / 2
+		}
+		return c.plot.Min.X + int(float64(c.plot.Dx())*float64(t.Sub(first))/float64(last.Sub(first)))
+	}
+
+	for i := 1; i < len(points); i++ {
+		c.line(x(points[i-1].Time), scale.y(c.plot, points[i-1].Value), x(points[i].Time), scale.y(c.plot, points[i].Value), LineColor)
+	}
+	for _, p := range points {
+		c.dot(x(p.Time), scale.y(c.plot, p.Value), LineColor)
+	}
+
+	c.label(first.Format("Jan 2"), x(first), c.plot.Max.Y+18, alignLeft)
+	if last.After(first) {
+		c.label(last.Format("Jan 2"), x(last), c.plot.Max.Y+18, alignRight)
+	}
+	return c.png()
+}
+
+type canvas struct {
+	img  *image.RGBA
+	plot image.Rectangle
+	face font.Face
+}
+
+func newCanvas(title string) *canvas {
+	c := &canvas{
+		img:  image.NewRGBA(image.Rect(0, 0, Width, Height)),
+		plot: image.Rect(padLeft, padTop, Width-padRight, Height-padBottom),
+		face: basicfont.Face7x13,
+	}
+	draw.Draw(c.img, c.img.Bounds(), &image.Uniform{background}, image.Point{}, draw.Src)
+	c.label(title, padLeft, 18, alignLeft)
+	return c
+}
+
+func (c *canvas) png() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.img); err != nil {
+		return nil, fmt.Errorf("error encoding chart: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// yAxis draws the horizontal grid lines and their labels.
+func (c *canvas) yAxis(s scale) {
+	steps := int(math.Round((s.high - s.low) / s.step))
+	for i := 0; i <= steps; i++ {
+		v := s.low + float64(i)*s.step
+		y := s.y(c.plot, v)
+		c.hline(c.plot.Min.X, c.plot.Max.X, y, grid)
+		c.label(formatTick(v), c.plot.Min.X-8, y+4, alignRight)
+	}
+}
+
+func (c *canvas) legend(names []string, colors []color.Color) {
+	x := c.plot.Max.X
+	for i := len(names) - 1; i >= 0; i-- {
+		x -= font.MeasureString(c.face, names[i]).Ceil()
+		c.label(names[i], x, 18, alignLeft)
+		x -= 16
+		c.fill(image.Rect(x, 9, x+10, 19), colors[i])
+		x -= 14
+	}
+}
+
+func (c *canvas) bar(x, width, top, bottom int, col color.Color) {
+	if top > bottom {
+		top, bottom = bottom, top
+	}
+	c.fill(image.Rect(x, top, x+width, bottom), col)
+}
+
+func (c *canvas) fill(r image.Rectangle, col color.Color) {
+	draw.Draw(c.img, r, &image.Uniform{col}, image.Point{}, draw.Src)
+}
+
+func (c *canvas) hline(x0, x1, y int, col color.Color) {
+	c.fill(image.Rect(x0, y, x1, y+1), col)
+}
+
+// line draws a two pixel wide line with Bresenham's algorithm.
+func (c *canvas) line(x0, y0, x1, y1 int, col color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := sign(x1-x0), sign(y1-y0)
+	err := dx + dy
+	for {
+		c.fill(image.Rect(x0, y0, x0+2, y0+2), col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func (c *canvas) dot(x, y int, col color.Color) {
+	c.fill(image.Rect(x-2, y-2, x+4, y+4), col)
+}
+
+type alignment int
+
+const (
+	alignLeft alignment = iota
+	alignCenter
+	alignRight
+)
+
+// label draws s with its baseline at y.
+func (c *canvas) label(s string, x, y int, align alignment) {
+	width := font.MeasureString(c.face, s).Ceil()
+	switch align {
+	case alignCenter:
+		x -= width / 2
+	case alignRight:
+		x -= width
+	}
+	d := font.Drawer{Dst: c.img, Src: &image.Uniform{text}, Face: c.face, Dot: fixed.P(x, y)}
+	d.DrawString(s)
+}
+
+// scale maps values to pixel rows, rounded out to a whole number of steps.
+type scale struct {
+	low, high, step float64
+}
+
+func newScale(low, high float64) scale {
+	if high-low == 0 {
+		high = low + 1
+	}
+	step := niceStep((high - low) / ticks)
+	return scale{
+		low:  math.Floor(low/step) * step,
+		high: math.Ceil(high/step) * step,
+		step: step,
+	}
+}
+
+func (s scale) y(plot image.Rectangle, v float64) int {
+	return plot.Max.Y - int(math.Round(float64(plot.Dy())*(v-s.low)/(s.high-s.low)))
+}
+
+// niceStep rounds a raw tick interval up to 1, 2 or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatTick(v float64) string {
+	switch {
+	case math.Abs(v) >= 1e6:
+		return fmt.Sprintf("%gM", math.Round(v/1e5)/10)
+	case math.Abs(v) >= 1e4:
+		return fmt.Sprintf("%gk", math.Round(v/1e2)/10)
+	default:
+		return fmt.Sprintf("%g", math.Round(v*100)/100)
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func sign(v int) int {
+	switch {
+	case v < 0:
+		return -1
+	case v > 0:
+		return 1
+	}
+	return 0
+}
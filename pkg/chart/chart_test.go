@@ -0,0 +1,65 @@
+package chart_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"stori-technical-challenge/pkg/chart"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, data []byte) image.Image {
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, chart.Width, chart.Height), img.Bounds())
+	return img
+}
+
+// countColor returns how many pixels of img are exactly c.
+func countColor(img image.Image, c color.RGBA) int {
+	n := 0
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			if color.RGBAModel.Convert(img.At(x, y)) == c {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestCreditsDebits(t *testing.T) {
+	data, err := chart.CreditsDebits("Credits vs debits", []chart.Bar{
+		{Label: "Jul 2024", Credits: 60.5, Debits: -10.3},
+		{Label: "Aug 2024", Credits: 10, Debits: -20.46},
+	})
+	require.NoError(t, err)
+
+	img := decode(t, data)
+	credits, debits := countColor(img, chart.CreditColor), countColor(img, chart.DebitColor)
+	assert.Greater(t, credits, debits, "July's credits dwarf every debit")
+	assert.Greater(t, debits, 100)
+}
+
+func TestBalance(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 7, d, 0, 0, 0, 0, time.UTC) }
+	data, err := chart.Balance("Balance", []chart.Point{{day(1), 100}, {day(10), -50}, {day(20), 25}})
+	require.NoError(t, err)
+
+	assert.Greater(t, countColor(decode(t, data), chart.LineColor), 200)
+}
+
+func TestChartsWithoutData(t *testing.T) {
+	data, err := chart.CreditsDebits("Credits vs debits", nil)
+	require.NoError(t, err)
+	decode(t, data)
+
+	data, err = chart.Balance("Balance", []chart.Point{{time.Now(), 0}})
+	require.NoError(t, err)
+	decode(t, data)
+}
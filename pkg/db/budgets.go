@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"stori-technical-challenge/pkg/budget"
+)
+
+const budgetsSchema = `
+    CREATE TABLE IF NOT EXISTS budgets (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        account TEXT NOT NULL,
+        kind TEXT NOT NULL,
+        category TEXT NOT NULL DEFAULT '',
+        amount REAL NOT NULL,
+        UNIQUE (account, kind, category)
+    );`
+
+// SaveBudget adds b or, if the account already has a budget of that kind
+// and category, updates its amount.
+func SaveBudget(ctx context.Context, b budget.Budget) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	_, err := DB.ExecContext(ctx, `
+        INSERT INTO budgets (account, kind, category, amount) VALUES (?, ?, ?, ?)
+        ON CONFLICT (account, kind, category) DO UPDATE SET amount = excluded.amount`,
+		b.Account, b.Kind, b.Category, b.Amount)
+	if err != nil {
+		return fmt.Errorf("error saving budget: %v", err)
+	}
+	return nil
+}
+
+// DeleteBudget removes a budget from an account. It returns sql.ErrNoRows
+// if the account has no such budget.
+func DeleteBudget(ctx context.Context, account string, kind budget.Kind, category string) error {
+	result, err := DB.ExecContext(ctx, `DELETE FROM budgets WHERE account = ? AND kind = ? AND category = ?`, account, kind, category)
+	if err != nil {
+		return fmt.Errorf("error deleting budget: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetBudgets returns an account's budgets, or every budget when account is
+// empty, ordered by account, kind and category.
+func GetBudgets(ctx context.Context, account string) ([]budget.Budget, error) {
+	query := `SELECT id, account, kind, category, amount FROM budgets`
+	var args []interface{}
+	if account != "" {
+		query += ` WHERE account = ?`
+		args = append(args, account)
+	}
+	rows, err := DB.QueryContext(ctx, query+` ORDER BY account, kind, category`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving budgets: %v", err)
+	}
+	defer rows.Close()
+
+	var budgets []budget.Budget
+	for rows.Next() {
+		var b budget.Budget
+		if err := rows.Scan(&b.ID, &b.Account, &b.Kind, &b.Category, &b.Amount); err != nil {
+			return nil, fmt.Errorf("error scanning budget: %v", err)
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets, rows.Err()
+}
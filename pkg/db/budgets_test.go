@@ -0,0 +1,41 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"stori-technical-challenge/pkg/budget"
+	"stori-technical-challenge/pkg/db"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudgetsLifecycle(t *testing.T) {
+	require.NoError(t, db.InitDB())
+	ctx := context.Background()
+	account := t.Name() + time.Now().Format(time.RFC3339Nano)
+
+	require.NoError(t, db.SaveBudget(ctx, budget.Budget{Account: account, Amount: 1500}))
+	require.NoError(t, db.SaveBudget(ctx, budget.Budget{Account: account, Category: "Restaurants", Amount: 200}))
+	require.NoError(t, db.SaveBudget(ctx, budget.Budget{Account: account, Kind: budget.MinBalance, Amount: 100}))
+	// Saving the same kind and category again updates the amount
+	require.NoError(t, db.SaveBudget(ctx, budget.Budget{Account: account, Category: "Restaurants", Amount: 250}))
+
+	budgets, err := db.GetBudgets(ctx, account)
+	require.NoError(t, err)
+	require.Len(t, budgets, 3)
+	assert.Equal(t, budget.MinBalance, budgets[0].Kind)
+	assert.Equal(t, budget.Spending, budgets[1].Kind, "kind defaults to spending")
+	assert.Equal(t, "", budgets[1].Category)
+	assert.Equal(t, 1500.0, budgets[1].Amount)
+	assert.Equal(t, "Restaurants", budgets[2].Category)
+	assert.Equal(t, 250.0, budgets[2].Amount)
+
+	require.NoError(t, db.DeleteBudget(ctx, account, budget.Spending, "Restaurants"))
+	assert.ErrorIs(t, db.DeleteBudget(ctx, account, budget.Spending, "Restaurants"), sql.ErrNoRows)
+	budgets, err = db.GetBudgets(ctx, account)
+	require.NoError(t, err)
+	assert.Len(t, budgets, 2)
+}
@@ -1,48 +1,144 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	"encoding/csv"
 	"fmt"
-	"os"
+	"log/slog"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/metrics"
+	"stori-technical-challenge/pkg/tracing"
+	"stori-technical-challenge/pkg/transactions"
 	"strconv"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var DB *sql.DB
 
+// logger reports database operations; see SetLogger. When nil, slog.Default() is used.
+var logger *slog.Logger
+
+// SetLogger sets the logger used by the package. A nil logger restores slog.Default().
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
 type Transaction struct {
-	Date   string  `json:"date"`
-	Amount float64 `json:"amount"`
+	// ID is assigned by the database; it is ignored when saving.
+	ID          int64   `json:"id"`
+	Date        string  `json:"date"`
+	Amount      float64 `json:"amount"`
+	Description string  `json:"description"`
+	Category    string  `json:"category"`
+	// Account is the account the transaction belongs to. Empty saves it to
+	// DefaultAccount.
+	Account string `json:"account"`
+}
+
+// DefaultAccount owns the transactions saved without an account, including
+// those imported before transactions had one. It matches
+// config.DefaultAccount.
+const DefaultAccount = "default"
+
+// execer is implemented by both *sql.DB and *sql.Tx, so inserts can run
+// on their own or as part of a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+var schema = []string{`
+    CREATE TABLE IF NOT EXISTS transactions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        date TEXT NOT NULL,
+        amount REAL NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        category TEXT NOT NULL DEFAULT '',
+        account TEXT NOT NULL DEFAULT 'default'
+    );`,
+	outboxSchema,
+	outboxAttachmentsSchema,
+	recipientsSchema,
+	budgetsSchema,
+}
+
+// column is a column added after its table was first created. InitDB adds
+// it to databases that predate it.
+type column struct {
+	table, name, definition string
+}
+
+var addedColumns = []column{
+	{"transactions", "description", "TEXT NOT NULL DEFAULT ''"},
+	{"transactions", "category", "TEXT NOT NULL DEFAULT ''"},
+	{"transactions", "account", "TEXT NOT NULL DEFAULT 'default'"},
+	{"outbox", "cc", "TEXT NOT NULL DEFAULT ''"},
+	{"outbox", "bcc", "TEXT NOT NULL DEFAULT ''"},
+	{"outbox_attachments", "content_id", "TEXT NOT NULL DEFAULT ''"},
+	{"outbox", "recipient_id", "INTEGER"},
+	{"outbox", "webhook_url", "TEXT NOT NULL DEFAULT ''"},
 }
 
 func InitDB() error {
 	var err error
-	DB, err = sql.Open("sqlite3", "./transactions.db")
+	DB, err = sql.Open(driverName, "./transactions.db")
 	if err != nil {
 		return fmt.Errorf("error opening database: %v", err)
 	}
 
-	createTableQuery := `
-    CREATE TABLE IF NOT EXISTS transactions (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        date TEXT NOT NULL,
-        amount REAL NOT NULL
-    );`
+	for _, createTableQuery := range schema {
+		_, err = DB.Exec(createTableQuery)
+		if err != nil {
+			return fmt.Errorf("error creating table: %v", err)
+		}
+	}
+	for _, c := range addedColumns {
+		if err := addColumnIfMissing(c); err != nil {
+			return err
+		}
+	}
 
-	_, err = DB.Exec(createTableQuery)
+	return nil
+}
+
+func addColumnIfMissing(c column) error {
+	rows, err := DB.Query(`SELECT name FROM pragma_table_info(?)`, c.table)
 	if err != nil {
-		return fmt.Errorf("error creating table: %v", err)
+		return fmt.Errorf("error reading table %s: %v", c.table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("error reading table %s: %v", c.table, err)
+		}
+		if name == c.name {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading table %s: %v", c.table, err)
 	}
 
+	if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition)); err != nil {
+		return fmt.Errorf("error adding column %s.%s: %v", c.table, c.name, err)
+	}
 	return nil
 }
 
-func SaveTransaction(transaction Transaction) error {
-	insertQuery := `INSERT INTO transactions (date, amount) VALUES (?, ?)`
-	_, err := DB.Exec(insertQuery, transaction.Date, transaction.Amount)
+func SaveTransaction(ctx context.Context, transaction Transaction) error {
+	return saveTransaction(ctx, DB, transaction)
+}
+
+func saveTransaction(ctx context.Context, exec execer, transaction Transaction) error {
+	if transaction.Account == "" {
+		transaction.Account = DefaultAccount
+	}
+	insertQuery := `INSERT INTO transactions (date, amount, description, category, account) VALUES (?, ?, ?, ?, ?)`
+	start := time.Now()
+	_, err := exec.ExecContext(ctx, insertQuery, transaction.Date, transaction.Amount, transaction.Description, transaction.Category, transaction.Account)
+	metrics.DBInsertDuration.Observe(metrics.Since(start))
 	if err != nil {
 		return fmt.Errorf("error saving transaction: %v", err)
 	}
@@ -50,7 +146,65 @@ func SaveTransaction(transaction Transaction) error {
 }
 
 func GetAllTransactions() ([]Transaction, error) {
-	rows, err := DB.Query("SELECT date, amount FROM transactions")
+	return queryTransactions(context.Background(), "SELECT id, date, amount, description, category, account FROM transactions ORDER BY id")
+}
+
+// GetTransactions returns the transactions of account dated between from
+// and to, both inclusive and formatted as YYYY-MM-DD, ordered by date. An
+// empty bound leaves that side open.
+func GetTransactions(ctx context.Context, account, from, to string) ([]Transaction, error) {
+	query := "SELECT id, date, amount, description, category, account FROM transactions WHERE account = ?"
+	args := []interface{}{account}
+	if from != "" {
+		query += " AND date >= ?"
+		args = append(args, from)
+	}
+	if to != "" {
+		query += " AND date <= ?"
+		args = append(args, to)
+	}
+	return queryTransactions(ctx, query+" ORDER BY date, id", args...)
+}
+
+// GetHistory returns the transactions of account dated on or after since,
+// oldest first, in the form the analyzers work with.
+func GetHistory(ctx context.Context, account string, since time.Time) ([]transactions.Transaction, error) {
+	rows, err := queryTransactions(ctx, "SELECT id, date, amount, description, category, account FROM transactions WHERE account = ? AND date >= ? ORDER BY date, id",
+		account, since.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	history := make([]transactions.Transaction, 0, len(rows))
+	for _, row := range rows {
+		date, err := time.Parse("2006-01-02", row.Date)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing date of transaction %d: %v", row.ID, err)
+		}
+		history = append(history, transactions.Transaction{
+			ID:          strconv.FormatInt(row.ID, 10),
+			Date:        date,
+			Amount:      row.Amount,
+			Description: row.Description,
+			Category:    row.Category,
+		})
+	}
+	return history, nil
+}
+
+// BalanceBefore returns what the stored transactions dated before the day
+// of before add up to: the balance the day opened with.
+func BalanceBefore(ctx context.Context, account string, before time.Time) (float64, error) {
+	var balance float64
+	err := DB.QueryRowContext(ctx, `SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE account = ? AND date < ?`,
+		account, before.Format("2006-01-02")).Scan(&balance)
+	if err != nil {
+		return 0, fmt.Errorf("error computing balance: %v", err)
+	}
+	return balance, nil
+}
+
+func queryTransactions(ctx context.Context, query string, args ...interface{}) ([]Transaction, error) {
+	rows, err := DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving transactions: %v", err)
 	}
@@ -59,58 +213,61 @@ func GetAllTransactions() ([]Transaction, error) {
 	var transactions []Transaction
 	for rows.Next() {
 		var transaction Transaction
-		if err := rows.Scan(&transaction.Date, &transaction.Amount); err != nil {
+		if err := rows.Scan(&transaction.ID, &transaction.Date, &transaction.Amount, &transaction.Description, &transaction.Category, &transaction.Account); err != nil {
 			return nil, fmt.Errorf("error scanning transaction: %v", err)
 		}
 		transactions = append(transactions, transaction)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error retrieving transactions: %v", err)
+	}
 
 	return transactions, nil
 }
 
-func SaveTransactionsFromCSV(filePath string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("error opening transactions file: %v", err)
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return fmt.Errorf("error reading transactions file: %v", err)
-	}
-
-	for i, record := range records {
-		if i == 0 {
-			continue // skip header
-		}
-		dateStr := record[1]
-		amountStr := record[2]
-
-		// Parse the date
-		date, err := time.Parse("1/2", dateStr)
+// SaveTransactionsFromCSV processes filePath with the transactions
+// processor, configured by opts, and saves the transactions it accepts.
+// Rows the processor rejects are skipped, as they are in the summary.
+// Categories are saved as the processor assigns them, so pass
+// transactions.WithCategorizer to fill them.
+func SaveTransactionsFromCSV(ctx context.Context, filePath string, opts ...transactions.Option) (err error) {
+	ctx, span := tracing.Start(ctx, "db.SaveTransactionsFromCSV", attribute.String("source", filePath))
+	start := time.Now()
+	saved := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("rows_saved", saved))
+		tracing.End(span, err)
 		if err != nil {
-			return fmt.Errorf("error parsing date: %v", err)
+			logging.OrDefault(logger).Error("saving transactions failed", "source", filePath, "outcome", "failure", "rows_saved", saved, "error", err, "duration_ms", logging.Since(start))
+			return
 		}
+		logging.OrDefault(logger).Info("transactions saved", "source", filePath, "outcome", "success", "rows_saved", saved, "duration_ms", logging.Since(start))
+	}()
 
-		// Assume current year
-		currentYear := time.Now().Year()
-		date = date.AddDate(currentYear-date.Year(), 0, 0)
-		formattedDate := date.Format("2006-01-02")
+	result, err := transactions.NewProcessor(transactions.DefaultCSVReader{}, opts...).Process(ctx, filePath)
+	if err != nil {
+		return fmt.Errorf("error processing transactions file: %v", err)
+	}
+	saved, err = saveTransactions(ctx, DB, DefaultAccount, result.Transactions)
+	return err
+}
 
-		amount, err := strconv.ParseFloat(amountStr, 64)
+// saveTransactions inserts txs into account through exec and returns how
+// many were saved.
+func saveTransactions(ctx context.Context, exec execer, account string, txs []transactions.Transaction) (int, error) {
+	saved := 0
+	for _, t := range txs {
+		err := saveTransaction(ctx, exec, Transaction{
+			Date:        t.Date.Format("2006-01-02"),
+			Amount:      t.Amount,
+			Description: t.Description,
+			Category:    t.Category,
+			Account:     account,
+		})
 		if err != nil {
-			return fmt.Errorf("error parsing transaction amount: %v", err)
-		}
-		transaction := Transaction{
-			Date:   formattedDate,
-			Amount: amount,
-		}
-		err = SaveTransaction(transaction)
-		if err != nil {
-			return fmt.Errorf("error saving transaction: %v", err)
+			return saved, err
 		}
+		saved++
 	}
-	return nil
+	return saved, nil
 }
@@ -1,10 +1,17 @@
 package db_test
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"stori-technical-challenge/pkg/category"
 	"stori-technical-challenge/pkg/db"
+	"stori-technical-challenge/pkg/transactions"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestInitDB(t *testing.T) {
@@ -29,7 +36,7 @@ func TestSaveTransaction(t *testing.T) {
 		Amount: 100.50,
 	}
 
-	err = db.SaveTransaction(transaction)
+	err = db.SaveTransaction(context.Background(), transaction)
 	assert.NoError(t, err, "Error saving transaction")
 
 	// Retrieve the transaction from the database
@@ -41,3 +48,92 @@ func TestSaveTransaction(t *testing.T) {
 	assert.Equal(t, transaction.Date, lastTransaction.Date, "Transaction date does not match")
 	assert.Equal(t, transaction.Amount, lastTransaction.Amount, "Transaction amount does not match")
 }
+
+func TestGetTransactionsBetween(t *testing.T) {
+	assert.NoError(t, db.InitDB(), "Error initializing database")
+	ctx := context.Background()
+
+	// Far in the past so other tests' rows stay out of the range
+	for _, date := range []string{"1901-03-02", "1901-01-15", "1901-02-01", "1901-04-01"} {
+		assert.NoError(t, db.SaveTransaction(ctx, db.Transaction{Date: date, Amount: 1}))
+	}
+	assert.NoError(t, db.SaveTransaction(ctx, db.Transaction{Date: "1901-01-20", Amount: 1, Account: "other"}))
+
+	transactions, err := db.GetTransactions(ctx, db.DefaultAccount, "1901-01-15", "1901-03-02")
+	assert.NoError(t, err)
+	// The database outlives test runs, so each date may appear repeatedly
+	var dates []string
+	for _, tr := range transactions {
+		if len(dates) == 0 || dates[len(dates)-1] != tr.Date {
+			dates = append(dates, tr.Date)
+		}
+		assert.NotZero(t, tr.ID)
+	}
+	assert.Equal(t, []string{"1901-01-15", "1901-02-01", "1901-03-02"}, dates, "ordered by date, bounds included, only the account's")
+}
+
+func TestGetHistory(t *testing.T) {
+	assert.NoError(t, db.InitDB(), "Error initializing database")
+	ctx := context.Background()
+	account := t.Name() + time.Now().Format(time.RFC3339Nano)
+	require.NoError(t, db.SaveTransaction(ctx, db.Transaction{Date: "1903-05-01", Amount: 1, Account: account}))
+	require.NoError(t, db.SaveTransaction(ctx, db.Transaction{Date: "1903-05-02", Amount: -9.99, Description: "Spotify", Category: "Subscriptions", Account: account}))
+	require.NoError(t, db.SaveTransaction(ctx, db.Transaction{Date: "1903-05-03", Amount: -500, Account: account + "-other"}))
+
+	history, err := db.GetHistory(ctx, account, time.Date(1903, 5, 2, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, history, 1, "only the account's transactions")
+	assert.Equal(t, time.Date(1903, 5, 2, 0, 0, 0, 0, time.UTC), history[0].Date, "since is inclusive")
+
+	latest := history[0]
+	assert.NotEmpty(t, latest.ID)
+	assert.Equal(t, -9.99, latest.Amount)
+	assert.Equal(t, "Spotify", latest.Description)
+	assert.Equal(t, "Subscriptions", latest.Category)
+}
+
+func TestBalanceBefore(t *testing.T) {
+	require.NoError(t, db.InitDB())
+	ctx := context.Background()
+	day := time.Date(1902, 3, 4, 0, 0, 0, 0, time.UTC)
+	account := t.Name() + time.Now().Format(time.RFC3339Nano)
+
+	require.NoError(t, db.SaveTransaction(ctx, db.Transaction{Date: "1902-03-03", Amount: 100, Account: account}))
+	require.NoError(t, db.SaveTransaction(ctx, db.Transaction{Date: "1902-03-04", Amount: 60.5, Account: account}))
+	require.NoError(t, db.SaveTransaction(ctx, db.Transaction{Date: "1902-03-04", Amount: -10.25, Account: account}))
+	require.NoError(t, db.SaveTransaction(ctx, db.Transaction{Date: "1902-03-01", Amount: -999, Account: account + "-other"}))
+
+	got, err := db.BalanceBefore(ctx, account, day.Add(15*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, got, "the day's own transactions come after its opening balance")
+	got, err = db.BalanceBefore(ctx, account, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.InDelta(t, 150.25, got, 1e-9)
+}
+
+func TestSaveTransactionsFromCSVCategorizes(t *testing.T) {
+	assert.NoError(t, db.InitDB(), "Error initializing database")
+
+	path := filepath.Join(t.TempDir(), "described.csv")
+	require.NoError(t, os.WriteFile(path, []byte("Id,Date,Transaction,Description\n0,1/2/1902,-15.99, Netflix \n1,1/3/1902,+100,\n2,1/4/1902,abc,Rejected\n"), 0o644))
+	require.NoError(t, db.SaveTransactionsFromCSV(context.Background(), path, transactions.WithCategorizer(category.Default())))
+
+	// Each day's last row is this run's
+	last := func(date string) db.Transaction {
+		transactions, err := db.GetTransactions(context.Background(), db.DefaultAccount, date, date)
+		require.NoError(t, err)
+		require.NotEmpty(t, transactions)
+		return transactions[len(transactions)-1]
+	}
+	debit, credit := last("1902-01-02"), last("1902-01-03")
+	assert.Equal(t, "Netflix", debit.Description)
+	assert.Equal(t, "Subscriptions", debit.Category)
+	assert.Equal(t, "", credit.Description)
+	assert.Equal(t, "Income", credit.Category)
+
+	rejected, err := db.GetTransactions(context.Background(), db.DefaultAccount, "1902-01-04", "1902-01-04")
+	require.NoError(t, err)
+	for _, r := range rejected {
+		assert.NotEqual(t, "Rejected", r.Description, "rows the processor rejects are not saved")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !purego
+
+package db
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// driverName is the database/sql driver used by InitDB. The default build
+// links mattn/go-sqlite3, which requires cgo.
+const driverName = "sqlite3"
@@ -0,0 +1,12 @@
+//go:build purego
+
+package db
+
+import (
+	_ "modernc.org/sqlite"
+)
+
+// driverName is the database/sql driver used by InitDB. Building with
+// -tags purego links modernc.org/sqlite so the binary can be compiled with
+// CGO_ENABLED=0 and cross-compiled without a C toolchain.
+const driverName = "sqlite"
@@ -0,0 +1,430 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/tracing"
+	"stori-technical-challenge/pkg/transactions"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// OutboxStatus is the delivery state of a queued email.
+type OutboxStatus string
+
+const (
+	// OutboxPending messages are waiting for their first or next attempt.
+	OutboxPending OutboxStatus = "pending"
+	// OutboxSent messages were accepted by the email backend.
+	OutboxSent OutboxStatus = "sent"
+	// OutboxFailed messages ran out of attempts and won't be retried.
+	OutboxFailed OutboxStatus = "failed"
+	// OutboxSkipped messages had no recipients. They are kept so the
+	// statement they belong to isn't imported again.
+	OutboxSkipped OutboxStatus = "skipped"
+)
+
+// outboxTimeLayout is fixed-width so timestamps compare correctly as text.
+const outboxTimeLayout = "2006-01-02T15:04:05.000Z"
+
+const outboxSchema = `
+    CREATE TABLE IF NOT EXISTS outbox (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        dedup_key TEXT NOT NULL UNIQUE,
+        to_email TEXT NOT NULL,
+        cc TEXT NOT NULL DEFAULT '',
+        bcc TEXT NOT NULL DEFAULT '',
+        subject TEXT NOT NULL,
+        body TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'pending',
+        attempts INTEGER NOT NULL DEFAULT 0,
+        last_error TEXT NOT NULL DEFAULT '',
+        next_attempt_at TEXT NOT NULL,
+        created_at TEXT NOT NULL,
+        sent_at TEXT,
+        recipient_id INTEGER,
+        webhook_url TEXT NOT NULL DEFAULT ''
+    );`
+
+const outboxAttachmentsSchema = `
+    CREATE TABLE IF NOT EXISTS outbox_attachments (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        outbox_id INTEGER NOT NULL REFERENCES outbox (id),
+        filename TEXT NOT NULL,
+        content_type TEXT NOT NULL,
+        content_id TEXT NOT NULL DEFAULT '',
+        data BLOB NOT NULL
+    );`
+
+// OutboxMessage is an email waiting in, or delivered from, the outbox.
+type OutboxMessage struct {
+	ID int64 `json:"id"`
+	// DedupKey identifies what the message is about, e.g. one statement for
+	// one recipient. A key is only ever enqueued once.
+	DedupKey      string       `json:"dedup_key"`
+	To            []string     `json:"to"`
+	Cc            []string     `json:"cc,omitempty"`
+	Bcc           []string     `json:"bcc,omitempty"`
+	Subject       string       `json:"subject"`
+	Body          string       `json:"-"`
+	Status        OutboxStatus `json:"status"`
+	Attempts      int          `json:"attempts"`
+	LastError     string       `json:"last_error,omitempty"`
+	NextAttemptAt time.Time    `json:"next_attempt_at"`
+	CreatedAt     time.Time    `json:"created_at"`
+	SentAt        *time.Time   `json:"sent_at,omitempty"`
+	// Attachments are stored alongside the message so a retry sends the
+	// same files.
+	Attachments []OutboxAttachment `json:"attachments,omitempty"`
+	// RecipientID marks a digest part: a summary held for one recipient
+	// until their frequency lets them hear from the account again. A
+	// recipient's pending parts are sent together as one email.
+	RecipientID int64 `json:"recipient_id,omitempty"`
+	// WebhookURL, when set, makes the message a webhook call instead of an
+	// email: Body is the JSON document posted to it.
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// OutboxAttachment is a file sent along with an outbox message. Files with
+// a ContentID are embedded inline in the body.
+type OutboxAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"`
+	Data        []byte `json:"-"`
+}
+
+// EnqueueEmail adds msg to the outbox as pending, or as skipped when it has
+// no recipients or webhook. A pending message is first attempted at its
+// NextAttemptAt, or right away when that is zero or past. It reports false,
+// without an error, when a message with the same DedupKey was already
+// enqueued.
+func EnqueueEmail(ctx context.Context, msg OutboxMessage) (bool, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	enqueued, err := enqueueEmail(ctx, tx, msg, time.Now())
+	if err != nil || !enqueued {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return true, nil
+}
+
+func enqueueEmail(ctx context.Context, exec execer, msg OutboxMessage, now time.Time) (bool, error) {
+	if msg.DedupKey == "" {
+		return false, errors.New("error enqueueing email: missing dedup key")
+	}
+	status := OutboxPending
+	if len(msg.To)+len(msg.Cc)+len(msg.Bcc) == 0 && msg.WebhookURL == "" {
+		status = OutboxSkipped
+	}
+	next := now
+	if msg.NextAttemptAt.After(now) {
+		next = msg.NextAttemptAt
+	}
+	recipientID := sql.NullInt64{Int64: msg.RecipientID, Valid: msg.RecipientID != 0}
+	result, err := exec.ExecContext(ctx, `
+        INSERT INTO outbox (dedup_key, to_email, cc, bcc, subject, body, status, next_attempt_at, created_at, recipient_id, webhook_url)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (dedup_key) DO NOTHING`,
+		msg.DedupKey, joinAddresses(msg.To), joinAddresses(msg.Cc), joinAddresses(msg.Bcc), msg.Subject, msg.Body,
+		status, formatOutboxTime(next), formatOutboxTime(now), recipientID, msg.WebhookURL)
+	if err != nil {
+		return false, fmt.Errorf("error enqueueing email: %v", err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error enqueueing email: %v", err)
+	}
+	if inserted == 0 {
+		return false, nil
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return false, fmt.Errorf("error enqueueing email: %v", err)
+	}
+	for _, a := range msg.Attachments {
+		_, err := exec.ExecContext(ctx, `
+            INSERT INTO outbox_attachments (outbox_id, filename, content_type, content_id, data) VALUES (?, ?, ?, ?, ?)`,
+			id, a.Filename, a.ContentType, a.ContentID, a.Data)
+		if err != nil {
+			return false, fmt.Errorf("error enqueueing attachment: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// Import is a statement to import along with the emails that notify it.
+type Import struct {
+	// Source names the statement in logs and traces.
+	Source string
+	// Account owns the statement's transactions. Empty is DefaultAccount.
+	Account string
+	// Transactions are the rows the processor accepted from the statement.
+	Transactions []transactions.Transaction
+	// Messages are queued with the statement. The first one's DedupKey
+	// identifies the statement, so it must always be present, even when
+	// it has no recipients.
+	Messages []OutboxMessage
+	// Notified are the IDs of the recipients the messages go to. They are
+	// marked as notified at Now.
+	Notified []int64
+	Now      time.Time
+}
+
+// ImportStatement saves the transactions of imp, enqueues its messages and
+// marks its recipients as notified in a single database transaction, so a
+// statement is either fully imported and queued for notification or not
+// at all. A statement whose first message was already enqueued is skipped
+// entirely and ImportStatement reports false.
+func ImportStatement(ctx context.Context, imp Import) (enqueued bool, err error) {
+	ctx, span := tracing.Start(ctx, "db.ImportStatement", attribute.String("source", imp.Source))
+	start := time.Now()
+	saved := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("rows_saved", saved), attribute.Bool("enqueued", enqueued))
+		tracing.End(span, err)
+		if err != nil {
+			logging.OrDefault(logger).Error("importing statement failed", "source", imp.Source, "outcome", "failure", "error", err, "duration_ms", logging.Since(start))
+			return
+		}
+		logging.OrDefault(logger).Info("statement imported", "source", imp.Source, "outcome", "success", "rows_saved", saved, "enqueued", enqueued, "messages", len(imp.Messages), "duration_ms", logging.Since(start))
+	}()
+	if len(imp.Messages) == 0 {
+		return false, errors.New("error importing statement: no message identifies it")
+	}
+	if imp.Now.IsZero() {
+		imp.Now = time.Now()
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for i, msg := range imp.Messages {
+		queued, err := enqueueEmail(ctx, tx, msg, imp.Now)
+		if err != nil {
+			return false, err
+		}
+		if i == 0 && !queued {
+			return false, nil
+		}
+	}
+	if saved, err = saveTransactions(ctx, tx, imp.Account, imp.Transactions); err != nil {
+		return false, err
+	}
+	if err := markNotified(ctx, tx, imp.Notified, imp.Now); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return true, nil
+}
+
+// DueEmails returns up to limit pending messages whose next attempt is due
+// at now, oldest first.
+func DueEmails(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+	return queryOutbox(ctx, `WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?`,
+		OutboxPending, formatOutboxTime(now), limit)
+}
+
+// GetOutboxMessages returns every message with the given status, or every
+// message when status is empty, oldest first.
+func GetOutboxMessages(ctx context.Context, status OutboxStatus) ([]OutboxMessage, error) {
+	if status == "" {
+		return queryOutbox(ctx, `ORDER BY id`)
+	}
+	return queryOutbox(ctx, `WHERE status = ? ORDER BY id`, status)
+}
+
+// GetOutboxMessage returns the message with the given dedup key, or
+// sql.ErrNoRows.
+func GetOutboxMessage(ctx context.Context, dedupKey string) (OutboxMessage, error) {
+	messages, err := queryOutbox(ctx, `WHERE dedup_key = ?`, dedupKey)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+	if len(messages) == 0 {
+		return OutboxMessage{}, sql.ErrNoRows
+	}
+	return messages[0], nil
+}
+
+// DigestParts returns the pending digest parts of a recipient, oldest
+// first, whether they are due or not.
+func DigestParts(ctx context.Context, recipientID int64) ([]OutboxMessage, error) {
+	return queryOutbox(ctx, `WHERE status = ? AND recipient_id = ? ORDER BY id`, OutboxPending, recipientID)
+}
+
+// DigestRecipients returns the IDs of the recipients with pending digest
+// parts. A summary for them joins their digest, even once they are due, so
+// they get a single email.
+func DigestRecipients(ctx context.Context) (map[int64]bool, error) {
+	rows, err := DB.QueryContext(ctx, `SELECT DISTINCT recipient_id FROM outbox WHERE status = ? AND recipient_id IS NOT NULL`, OutboxPending)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving outbox: %v", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning outbox: %v", err)
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// MarkDigestSent records the delivery of a recipient's digest: its parts
+// are marked sent and the recipient notified, together.
+func MarkDigestSent(ctx context.Context, recipientID int64, ids []int64, at time.Time) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if err := markEmailSent(ctx, tx, id, at); err != nil {
+			return err
+		}
+	}
+	if err := markNotified(ctx, tx, []int64{recipientID}, at); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// MarkEmailSent records a successful delivery attempt.
+func MarkEmailSent(ctx context.Context, id int64, at time.Time) error {
+	return markEmailSent(ctx, DB, id, at)
+}
+
+func markEmailSent(ctx context.Context, exec execer, id int64, at time.Time) error {
+	_, err := exec.ExecContext(ctx, `
+        UPDATE outbox SET status = ?, attempts = attempts + 1, last_error = '', sent_at = ?
+        WHERE id = ?`, OutboxSent, formatOutboxTime(at), id)
+	if err != nil {
+		return fmt.Errorf("error updating outbox: %v", err)
+	}
+	return nil
+}
+
+// MarkEmailFailed records a failed delivery attempt. The message is retried
+// at nextAttempt, or marked failed for good when giveUp is set.
+func MarkEmailFailed(ctx context.Context, id int64, sendErr error, nextAttempt time.Time, giveUp bool) error {
+	status := OutboxPending
+	if giveUp {
+		status = OutboxFailed
+	}
+	_, err := DB.ExecContext(ctx, `
+        UPDATE outbox SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = ?
+        WHERE id = ?`, status, sendErr.Error(), formatOutboxTime(nextAttempt), id)
+	if err != nil {
+		return fmt.Errorf("error updating outbox: %v", err)
+	}
+	return nil
+}
+
+func queryOutbox(ctx context.Context, where string, args ...interface{}) ([]OutboxMessage, error) {
+	rows, err := DB.QueryContext(ctx, `
+        SELECT id, dedup_key, to_email, cc, bcc, subject, body, status, attempts, last_error, next_attempt_at, created_at, sent_at, recipient_id, webhook_url
+        FROM outbox `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving outbox: %v", err)
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var msg OutboxMessage
+		var to, cc, bcc, nextAttempt, created string
+		var sent sql.NullString
+		var recipientID sql.NullInt64
+		if err := rows.Scan(&msg.ID, &msg.DedupKey, &to, &cc, &bcc, &msg.Subject, &msg.Body, &msg.Status,
+			&msg.Attempts, &msg.LastError, &nextAttempt, &created, &sent, &recipientID, &msg.WebhookURL); err != nil {
+			return nil, fmt.Errorf("error scanning outbox: %v", err)
+		}
+		msg.To, msg.Cc, msg.Bcc = splitAddresses(to), splitAddresses(cc), splitAddresses(bcc)
+		msg.NextAttemptAt = parseOutboxTime(nextAttempt)
+		msg.CreatedAt = parseOutboxTime(created)
+		msg.RecipientID = recipientID.Int64
+		if sent.Valid {
+			at := parseOutboxTime(sent.String)
+			msg.SentAt = &at
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error retrieving outbox: %v", err)
+	}
+	rows.Close()
+
+	for i := range messages {
+		if messages[i].Attachments, err = queryAttachments(ctx, messages[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+func queryAttachments(ctx context.Context, outboxID int64) ([]OutboxAttachment, error) {
+	rows, err := DB.QueryContext(ctx, `
+        SELECT filename, content_type, content_id, data FROM outbox_attachments WHERE outbox_id = ? ORDER BY id`, outboxID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving attachments: %v", err)
+	}
+	defer rows.Close()
+
+	var attachments []OutboxAttachment
+	for rows.Next() {
+		var a OutboxAttachment
+		if err := rows.Scan(&a.Filename, &a.ContentType, &a.ContentID, &a.Data); err != nil {
+			return nil, fmt.Errorf("error scanning attachment: %v", err)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// joinAddresses stores a list of bare addresses, which can't contain commas,
+// in a single column.
+func joinAddresses(addresses []string) string {
+	return strings.Join(addresses, ",")
+}
+
+func splitAddresses(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func formatOutboxTime(t time.Time) string {
+	return t.UTC().Format(outboxTimeLayout)
+}
+
+func parseOutboxTime(value string) time.Time {
+	t, _ := time.Parse(outboxTimeLayout, value)
+	return t
+}
@@ -0,0 +1,143 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"stori-technical-challenge/pkg/db"
+	"stori-technical-challenge/pkg/transactions"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countTransactions(t *testing.T) int {
+	var n int
+	require.NoError(t, db.DB.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&n))
+	return n
+}
+
+// newRecipient adds a recipient to an account of its own and returns it.
+func newRecipient(t *testing.T) db.Recipient {
+	account := t.Name() + time.Now().Format(time.RFC3339Nano)
+	require.NoError(t, db.SaveRecipient(context.Background(), db.Recipient{Account: account, Email: "owner@example.com"}))
+	recipients, err := db.GetRecipients(context.Background(), account)
+	require.NoError(t, err)
+	require.Len(t, recipients, 1)
+	return recipients[0]
+}
+
+func TestImportStatementEnqueuesOnce(t *testing.T) {
+	require.NoError(t, db.InitDB())
+	ctx := context.Background()
+
+	txs := []transactions.Transaction{
+		{Date: time.Date(1904, 7, 15, 0, 0, 0, 0, time.UTC), Amount: 60.5},
+		{Date: time.Date(1904, 7, 28, 0, 0, 0, 0, time.UTC), Amount: -10.3, Description: "Netflix", Category: "Subscriptions"},
+	}
+	msg := db.OutboxMessage{
+		DedupKey: "statement:" + t.Name() + time.Now().Format(time.RFC3339Nano),
+		To:       []string{"user@example.com"},
+		Subject:  "Summary",
+		Body:     "<p>hi</p>",
+		Attachments: []db.OutboxAttachment{
+			{Filename: "statement.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.3")},
+		},
+	}
+
+	recipient := newRecipient(t)
+	now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+
+	before := countTransactions(t)
+	enqueued, err := db.ImportStatement(ctx, db.Import{Source: "txns.csv", Account: recipient.Account, Transactions: txs, Messages: []db.OutboxMessage{msg}, Notified: []int64{recipient.ID}, Now: now})
+	require.NoError(t, err)
+	assert.True(t, enqueued)
+	assert.Equal(t, before+2, countTransactions(t))
+
+	recipients, err := db.GetRecipients(ctx, recipient.Account)
+	require.NoError(t, err)
+	require.NotNil(t, recipients[0].LastNotifiedAt)
+	assert.True(t, now.Equal(*recipients[0].LastNotifiedAt))
+
+	enqueued, err = db.ImportStatement(ctx, db.Import{Source: "txns.csv", Transactions: txs, Messages: []db.OutboxMessage{msg}})
+	require.NoError(t, err)
+	assert.False(t, enqueued, "the same statement must not be queued twice")
+	assert.Equal(t, before+2, countTransactions(t), "nor its transactions saved twice")
+
+	saved, err := db.GetTransactions(ctx, recipient.Account, "1904-07-28", "1904-07-28")
+	require.NoError(t, err)
+	require.NotEmpty(t, saved)
+	assert.Equal(t, "Netflix", saved[len(saved)-1].Description)
+	assert.Equal(t, "Subscriptions", saved[len(saved)-1].Category)
+	assert.Equal(t, recipient.Account, saved[len(saved)-1].Account)
+
+	queued, err := db.GetOutboxMessage(ctx, msg.DedupKey)
+	require.NoError(t, err)
+	assert.Equal(t, db.OutboxPending, queued.Status)
+	assert.Equal(t, "<p>hi</p>", queued.Body)
+	assert.Equal(t, msg.Attachments, queued.Attachments)
+}
+
+func TestImportStatementRollsBackOnFailedInsert(t *testing.T) {
+	require.NoError(t, db.InitDB())
+	ctx := context.Background()
+
+	// SQLite stores NaN as NULL, which the amount column refuses
+	txs := []transactions.Transaction{
+		{Date: time.Date(1904, 7, 15, 0, 0, 0, 0, time.UTC), Amount: 60.5},
+		{Date: time.Date(1904, 7, 28, 0, 0, 0, 0, time.UTC), Amount: math.NaN()},
+	}
+	msg := db.OutboxMessage{DedupKey: "statement:" + t.Name() + time.Now().Format(time.RFC3339Nano), To: []string{"user@example.com"}}
+
+	recipient := newRecipient(t)
+
+	before := countTransactions(t)
+	_, err := db.ImportStatement(ctx, db.Import{Source: "txns.csv", Transactions: txs, Messages: []db.OutboxMessage{msg}, Notified: []int64{recipient.ID}})
+	require.Error(t, err)
+	assert.Equal(t, before, countTransactions(t))
+
+	recipients, err := db.GetRecipients(ctx, recipient.Account)
+	require.NoError(t, err)
+	assert.Nil(t, recipients[0].LastNotifiedAt, "a failed import notifies nobody")
+
+	_, err = db.GetOutboxMessage(ctx, msg.DedupKey)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestOutboxStatusTransitions(t *testing.T) {
+	require.NoError(t, db.InitDB())
+	ctx := context.Background()
+	key := "statement:" + t.Name() + time.Now().Format(time.RFC3339Nano)
+
+	enqueued, err := db.EnqueueEmail(ctx, db.OutboxMessage{DedupKey: key, To: []string{"user@example.com"}})
+	require.NoError(t, err)
+	require.True(t, enqueued)
+	msg, err := db.GetOutboxMessage(ctx, key)
+	require.NoError(t, err)
+
+	retryAt := time.Now().Add(time.Hour)
+	require.NoError(t, db.MarkEmailFailed(ctx, msg.ID, errors.New("connection refused"), retryAt, false))
+	msg, err = db.GetOutboxMessage(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, db.OutboxPending, msg.Status)
+	assert.Equal(t, 1, msg.Attempts)
+	assert.Equal(t, "connection refused", msg.LastError)
+	assert.WithinDuration(t, retryAt, msg.NextAttemptAt, time.Millisecond)
+
+	due, err := db.DueEmails(ctx, time.Now(), 1000)
+	require.NoError(t, err)
+	for _, d := range due {
+		assert.NotEqual(t, key, d.DedupKey, "not due before its next attempt")
+	}
+
+	require.NoError(t, db.MarkEmailSent(ctx, msg.ID, time.Now()))
+	msg, err = db.GetOutboxMessage(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, db.OutboxSent, msg.Status)
+	assert.Equal(t, 2, msg.Attempts)
+	assert.Empty(t, msg.LastError)
+	assert.NotNil(t, msg.SentAt)
+}
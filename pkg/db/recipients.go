@@ -0,0 +1,202 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/mail"
+	"stori-technical-challenge/pkg/email"
+	"time"
+)
+
+// RecipientRole is how a recipient is addressed on an account's emails.
+type RecipientRole string
+
+const (
+	RoleTo  RecipientRole = "to"
+	RoleCc  RecipientRole = "cc"
+	RoleBcc RecipientRole = "bcc"
+)
+
+// Frequency is how often a recipient wants to hear about an account.
+type Frequency string
+
+const (
+	Daily   Frequency = "daily"
+	Weekly  Frequency = "weekly"
+	Monthly Frequency = "monthly"
+)
+
+// DefaultLocale is used for recipients that haven't chosen one. It is the
+// templates' own language, which the to_email fallback and alerts use too.
+const DefaultLocale = email.TemplateLocale
+
+const recipientsSchema = `
+    CREATE TABLE IF NOT EXISTS recipients (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        account TEXT NOT NULL,
+        email TEXT NOT NULL,
+        role TEXT NOT NULL DEFAULT 'to',
+        opted_out INTEGER NOT NULL DEFAULT 0,
+        locale TEXT NOT NULL DEFAULT '` + DefaultLocale + `',
+        frequency TEXT NOT NULL DEFAULT 'daily',
+        last_notified_at TEXT,
+        UNIQUE (account, email)
+    );`
+
+// Recipient links an account to an email address.
+type Recipient struct {
+	ID        int64         `json:"id"`
+	Account   string        `json:"account"`
+	Email     string        `json:"email"`
+	Role      RecipientRole `json:"role"`
+	OptedOut  bool          `json:"opted_out"`
+	Locale    string        `json:"locale"`
+	Frequency Frequency     `json:"frequency"`
+	// LastNotifiedAt is when the recipient was last included in a summary.
+	LastNotifiedAt *time.Time `json:"last_notified_at,omitempty"`
+}
+
+// Validate fills in defaults and checks the address, role and frequency.
+func (r *Recipient) Validate() error {
+	if r.Account == "" || r.Email == "" {
+		return fmt.Errorf("account and email are required")
+	}
+	// Only bare addresses, since the outbox stores them comma-separated
+	if addr, err := mail.ParseAddress(r.Email); err != nil || addr.Address != r.Email {
+		return fmt.Errorf("%q is not a valid email address", r.Email)
+	}
+	if r.Role == "" {
+		r.Role = RoleTo
+	}
+	if r.Locale == "" {
+		r.Locale = DefaultLocale
+	}
+	if r.Frequency == "" {
+		r.Frequency = Daily
+	}
+	switch r.Role {
+	case RoleTo, RoleCc, RoleBcc:
+	default:
+		return fmt.Errorf("invalid role %q: use to, cc or bcc", r.Role)
+	}
+	switch r.Frequency {
+	case Daily, Weekly, Monthly:
+	default:
+		return fmt.Errorf("invalid frequency %q: use daily, weekly or monthly", r.Frequency)
+	}
+	return nil
+}
+
+// DueAt reports whether the recipient should be included in a summary sent
+// at now, given their frequency and when they were last notified.
+func (r Recipient) DueAt(now time.Time) bool {
+	return !r.OptedOut && !now.Before(r.NextDue())
+}
+
+// NextDue is the earliest time the recipient's frequency allows another
+// summary; the zero time for recipients who were never notified. An hour of
+// slack keeps a nightly run that starts a little early from skipping a day.
+func (r Recipient) NextDue() time.Time {
+	if r.LastNotifiedAt == nil {
+		return time.Time{}
+	}
+	var next time.Time
+	switch r.Frequency {
+	case Weekly:
+		next = r.LastNotifiedAt.AddDate(0, 0, 7)
+	case Monthly:
+		next = r.LastNotifiedAt.AddDate(0, 1, 0)
+	default:
+		next = r.LastNotifiedAt.AddDate(0, 0, 1)
+	}
+	return next.Add(-time.Hour)
+}
+
+// SaveRecipient adds r or, if the account already has that address, updates
+// its role, opt-out flag, locale and frequency.
+func SaveRecipient(ctx context.Context, r Recipient) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	_, err := DB.ExecContext(ctx, `
+        INSERT INTO recipients (account, email, role, opted_out, locale, frequency)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (account, email) DO UPDATE SET
+            role = excluded.role, opted_out = excluded.opted_out,
+            locale = excluded.locale, frequency = excluded.frequency`,
+		r.Account, r.Email, r.Role, r.OptedOut, r.Locale, r.Frequency)
+	if err != nil {
+		return fmt.Errorf("error saving recipient: %v", err)
+	}
+	return nil
+}
+
+// SetOptOut opts the recipient in or out of an account's emails. It returns
+// sql.ErrNoRows if the account has no such recipient.
+func SetOptOut(ctx context.Context, account, email string, optedOut bool) error {
+	return updateRecipient(ctx, `UPDATE recipients SET opted_out = ? WHERE account = ? AND email = ?`, optedOut, account, email)
+}
+
+// DeleteRecipient removes a recipient from an account. It returns
+// sql.ErrNoRows if the account has no such recipient.
+func DeleteRecipient(ctx context.Context, account, email string) error {
+	return updateRecipient(ctx, `DELETE FROM recipients WHERE account = ? AND email = ?`, account, email)
+}
+
+// MarkNotified records that the recipients with the given IDs were included
+// in a summary at the given time.
+func MarkNotified(ctx context.Context, ids []int64, at time.Time) error {
+	return markNotified(ctx, DB, ids, at)
+}
+
+func markNotified(ctx context.Context, exec execer, ids []int64, at time.Time) error {
+	for _, id := range ids {
+		if _, err := exec.ExecContext(ctx, `UPDATE recipients SET last_notified_at = ? WHERE id = ?`, formatOutboxTime(at), id); err != nil {
+			return fmt.Errorf("error updating recipient: %v", err)
+		}
+	}
+	return nil
+}
+
+func updateRecipient(ctx context.Context, query string, args ...interface{}) error {
+	result, err := DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating recipient: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetRecipients returns an account's recipients, or every recipient when
+// account is empty, ordered by account and address.
+func GetRecipients(ctx context.Context, account string) ([]Recipient, error) {
+	query := `SELECT id, account, email, role, opted_out, locale, frequency, last_notified_at FROM recipients`
+	var args []interface{}
+	if account != "" {
+		query += ` WHERE account = ?`
+		args = append(args, account)
+	}
+	rows, err := DB.QueryContext(ctx, query+` ORDER BY account, email`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving recipients: %v", err)
+	}
+	defer rows.Close()
+
+	var recipients []Recipient
+	for rows.Next() {
+		var r Recipient
+		var notified sql.NullString
+		if err := rows.Scan(&r.ID, &r.Account, &r.Email, &r.Role, &r.OptedOut, &r.Locale, &r.Frequency, &notified); err != nil {
+			return nil, fmt.Errorf("error scanning recipient: %v", err)
+		}
+		if notified.Valid {
+			at := parseOutboxTime(notified.String)
+			r.LastNotifiedAt = &at
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
@@ -0,0 +1,91 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"stori-technical-challenge/pkg/db"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecipientsLifecycle(t *testing.T) {
+	require.NoError(t, db.InitDB())
+	ctx := context.Background()
+	account := t.Name() + time.Now().Format(time.RFC3339Nano)
+
+	require.NoError(t, db.SaveRecipient(ctx, db.Recipient{Account: account, Email: "owner@example.com"}))
+	require.NoError(t, db.SaveRecipient(ctx, db.Recipient{Account: account, Email: "cfo@example.com", Role: db.RoleCc, Locale: "en", Frequency: db.Monthly}))
+
+	recipients, err := db.GetRecipients(ctx, account)
+	require.NoError(t, err)
+	require.Len(t, recipients, 2)
+	assert.Equal(t, "cfo@example.com", recipients[0].Email)
+	assert.Equal(t, db.RoleCc, recipients[0].Role)
+	assert.Equal(t, "en", recipients[0].Locale)
+	assert.Equal(t, db.Monthly, recipients[0].Frequency)
+	assert.Equal(t, db.RoleTo, recipients[1].Role, "role defaults to to")
+	assert.Equal(t, db.DefaultLocale, recipients[1].Locale)
+	assert.Equal(t, db.Daily, recipients[1].Frequency)
+
+	// Saving an existing address updates it instead of adding another row
+	require.NoError(t, db.SaveRecipient(ctx, db.Recipient{Account: account, Email: "owner@example.com", Role: db.RoleBcc}))
+	require.NoError(t, db.SetOptOut(ctx, account, "cfo@example.com", true))
+	recipients, err = db.GetRecipients(ctx, account)
+	require.NoError(t, err)
+	require.Len(t, recipients, 2)
+	assert.True(t, recipients[0].OptedOut)
+	assert.Equal(t, db.RoleBcc, recipients[1].Role)
+
+	now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	require.NoError(t, db.MarkNotified(ctx, []int64{recipients[1].ID}, now))
+	recipients, err = db.GetRecipients(ctx, account)
+	require.NoError(t, err)
+	require.NotNil(t, recipients[1].LastNotifiedAt)
+	assert.True(t, now.Equal(*recipients[1].LastNotifiedAt))
+
+	require.NoError(t, db.DeleteRecipient(ctx, account, "cfo@example.com"))
+	assert.ErrorIs(t, db.DeleteRecipient(ctx, account, "cfo@example.com"), sql.ErrNoRows)
+	assert.ErrorIs(t, db.SetOptOut(ctx, account, "nobody@example.com", true), sql.ErrNoRows)
+
+	recipients, err = db.GetRecipients(ctx, account)
+	require.NoError(t, err)
+	assert.Len(t, recipients, 1)
+}
+
+func TestSaveRecipientValidates(t *testing.T) {
+	require.NoError(t, db.InitDB())
+	ctx := context.Background()
+
+	assert.ErrorContains(t, db.SaveRecipient(ctx, db.Recipient{Account: "a", Email: "Owner <owner@example.com>"}), "not a valid email address")
+	assert.ErrorContains(t, db.SaveRecipient(ctx, db.Recipient{Account: "a", Email: "owner@example.com", Role: "reply-to"}), "invalid role")
+	assert.ErrorContains(t, db.SaveRecipient(ctx, db.Recipient{Account: "a", Email: "owner@example.com", Frequency: "hourly"}), "invalid frequency")
+	assert.ErrorContains(t, db.SaveRecipient(ctx, db.Recipient{Email: "owner@example.com"}), "required")
+}
+
+func TestRecipientDueAt(t *testing.T) {
+	last := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		recipient db.Recipient
+		now       time.Time
+		want      bool
+	}{
+		{"never notified", db.Recipient{Frequency: db.Weekly}, last, true},
+		{"opted out", db.Recipient{OptedOut: true}, last, false},
+		{"daily, next day", db.Recipient{Frequency: db.Daily, LastNotifiedAt: &last}, last.AddDate(0, 0, 1), true},
+		{"daily, run starts early", db.Recipient{Frequency: db.Daily, LastNotifiedAt: &last}, last.Add(23*time.Hour + 30*time.Minute), true},
+		{"daily, same day", db.Recipient{Frequency: db.Daily, LastNotifiedAt: &last}, last.Add(12 * time.Hour), false},
+		{"weekly, after six days", db.Recipient{Frequency: db.Weekly, LastNotifiedAt: &last}, last.AddDate(0, 0, 6), false},
+		{"weekly, after a week", db.Recipient{Frequency: db.Weekly, LastNotifiedAt: &last}, last.AddDate(0, 0, 7), true},
+		{"monthly, after three weeks", db.Recipient{Frequency: db.Monthly, LastNotifiedAt: &last}, last.AddDate(0, 0, 21), false},
+		{"monthly, next month", db.Recipient{Frequency: db.Monthly, LastNotifiedAt: &last}, last.AddDate(0, 1, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.recipient.DueAt(tt.now))
+		})
+	}
+}
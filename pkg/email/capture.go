@@ -0,0 +1,53 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"stori-technical-challenge/pkg/metrics"
+	"strings"
+	"sync"
+)
+
+// CaptureSender is an EmailSender that records messages instead of sending
+// them, for local runs and tests. When Dir is set every message is also
+// written there as an HTML file, next to its attachments.
+type CaptureSender struct {
+	Dir string
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (s *CaptureSender) SendEmail(ctx context.Context, msg Message) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() { metrics.ObserveEmail("capture", err) }()
+
+	s.sent = append(s.sent, msg)
+	if s.Dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return fmt.Errorf("error creating capture directory: %w", err)
+	}
+	name := fmt.Sprintf("%03d-%s", len(s.sent), strings.NewReplacer("@", "_at_", "/", "_").Replace(strings.Join(msg.To, "_")))
+	if err := os.WriteFile(filepath.Join(s.Dir, name+".html"), []byte(msg.Body), 0o644); err != nil {
+		return fmt.Errorf("error writing captured email: %w", err)
+	}
+	for _, a := range msg.Attachments {
+		if err := os.WriteFile(filepath.Join(s.Dir, name+"-"+filepath.Base(a.Filename)), a.Data, 0o644); err != nil {
+			return fmt.Errorf("error writing captured attachment: %w", err)
+		}
+	}
+	return nil
+}
+
+// Sent returns the messages captured so far.
+func (s *CaptureSender) Sent() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.sent...)
+}
@@ -0,0 +1,164 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// dialTimeout bounds a dial when the context has no earlier deadline, as
+// gomail.Dialer.Dial does.
+const dialTimeout = 10 * time.Second
+
+// dialSMTP connects and authenticates to the server of d the way
+// gomail.Dialer.Dial does, but gives up as soon as ctx is done: a server
+// that accepts the connection and then hangs would otherwise block the
+// dispatcher and shutdown.
+func dialSMTP(ctx context.Context, d *gomail.Dialer) (gomail.SendCloser, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(d.Host, strconv.Itoa(d.Port)))
+	if err != nil {
+		return nil, err
+	}
+	// Closing the connection unblocks a handshake stuck on the server
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := handshake(conn, d)
+	if ctx.Err() != nil {
+		if c != nil {
+			c.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return smtpConn{c}, nil
+}
+
+// handshake greets the server and upgrades and authenticates the
+// connection as the server allows.
+func handshake(conn net.Conn, d *gomail.Dialer) (*smtp.Client, error) {
+	tlsConfig := d.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: d.Host}
+	}
+	if d.SSL {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, d.Host)
+	if err != nil {
+		return nil, err
+	}
+	if d.LocalName != "" {
+		if err := c.Hello(d.LocalName); err != nil {
+			return c, err
+		}
+	}
+	if !d.SSL {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return c, err
+			}
+		}
+	}
+
+	auth := d.Auth
+	if auth == nil && d.Username != "" {
+		if ok, mechanisms := c.Extension("AUTH"); ok {
+			switch {
+			case strings.Contains(mechanisms, "CRAM-MD5"):
+				auth = smtp.CRAMMD5Auth(d.Username, d.Password)
+			case strings.Contains(mechanisms, "LOGIN") && !strings.Contains(mechanisms, "PLAIN"):
+				auth = &loginAuth{username: d.Username, password: d.Password, host: d.Host}
+			default:
+				auth = smtp.PlainAuth("", d.Username, d.Password, d.Host)
+			}
+		}
+	}
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// smtpConn sends messages over an open SMTP connection.
+type smtpConn struct {
+	*smtp.Client
+}
+
+func (c smtpConn) Send(from string, to []string, msg io.WriterTo) error {
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (c smtpConn) Close() error {
+	return c.Quit()
+}
+
+// loginAuth is the LOGIN mechanism, for servers that offer it but not
+// PLAIN. net/smtp doesn't provide it.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		advertised := false
+		for _, mechanism := range server.Auth {
+			if mechanism == "LOGIN" {
+				advertised = true
+				break
+			}
+		}
+		if !advertised {
+			return "", nil, errors.New("unencrypted connection")
+		}
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch {
+	case strings.EqualFold(string(fromServer), "username:"):
+		return []byte(a.username), nil
+	case strings.EqualFold(string(fromServer), "password:"):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
@@ -2,46 +2,193 @@ package email
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"html/template"
+	"io"
+	"log/slog"
+	"stori-technical-challenge/assets"
 	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/budget"
+	"stori-technical-challenge/pkg/category"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/metrics"
+	"stori-technical-challenge/pkg/recurring"
+	"stori-technical-challenge/pkg/tracing"
 	"stori-technical-challenge/pkg/transactions"
+	"stori-technical-challenge/pkg/trend"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/gomail.v2"
 )
 
-const LogoPath = "assets/Stori_Logo_2023-min.png"
+// logoFilename names the logo embedded in every email as cid:logo. The
+// image itself is built into the binary.
+const logoFilename = "Stori_Logo_2023-min.png"
 
 type EmailSender interface {
-	SendEmail(subject, body, toEmail string) error
+	SendEmail(ctx context.Context, msg Message) error
 }
 
-type SMTPSender struct{}
+// Message is an HTML email. Bcc recipients get the message without
+// appearing in its headers; a message only for them has no To header.
+type Message struct {
+	To          []string
+	Cc          []string
+	Bcc         []string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with a message. Attachments with a
+// ContentID are embedded inline instead, for the body to show as
+// <img src="cid:ContentID">, the same way the logo is.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	Data        []byte
+}
+
+// ChartImage is an inline image shown in the summary body.
+type ChartImage struct {
+	ContentID string
+	Alt       string
+}
+
+// NewMessage returns a message for a single recipient.
+func NewMessage(toEmail, subject, body string) Message {
+	return Message{To: []string{toEmail}, Subject: subject, Body: body}
+}
+
+// Recipients returns every address the message goes to.
+func (m Message) Recipients() []string {
+	return append(append(append([]string(nil), m.To...), m.Cc...), m.Bcc...)
+}
+
+type SMTPSender struct {
+	// Logger reports each delivery. Defaults to slog.Default().
+	Logger *slog.Logger
+}
 
 type EmailData struct {
 	TotalBalance    float64
 	NumTransactions map[string]int
 	AvgDebitAmount  float64
 	AvgCreditAmount float64
+	// Charts are shown in the body, in order. Their images must be embedded
+	// in the message with matching content IDs.
+	Charts []ChartImage
+	// Comparisons set each month against the month before and the same
+	// month a year earlier, oldest first.
+	Comparisons []trend.Comparison
+	// Budgets are the account's budgets and balance threshold, checked
+	// against the period.
+	Budgets []budget.Result
+	// Spending is the debits by category, largest first.
+	Spending []category.Total
+	// Subscriptions are the active recurring charges, most expensive first.
+	Subscriptions []recurring.Series
+	// RecurringAlerts are the recurring charges and deposits that were
+	// missed or changed amount during the period.
+	RecurringAlerts []recurring.Alert
 }
 
-func (s SMTPSender) SendEmail(subject, body string) error {
+// SpendingBudgets are the results of the spending budgets, leaving out the
+// balance threshold.
+func (d EmailData) SpendingBudgets() []budget.Result {
+	var results []budget.Result
+	for _, r := range d.Budgets {
+		if r.Budget.Kind == budget.Spending {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// SubscriptionsCost is what the subscriptions add up to in a month.
+func (d EmailData) SubscriptionsCost() float64 {
+	total := 0.0
+	for _, s := range d.Subscriptions {
+		total += s.MonthlyCost()
+	}
+	return total
+}
+
+// newMessage builds msg as a gomail message with a plain-text alternative
+// to the HTML body, the logo embedded as cid:logo and msg's attachments
+// attached or embedded.
+func newMessage(from string, msg Message) *gomail.Message {
 	m := gomail.NewMessage()
-	m.SetHeader("From", config.AppConfig.FromEmail)
-	m.SetHeader("To", config.AppConfig.ToEmail)
-	m.SetHeader("Subject", subject)
+	m.SetHeader("From", from)
+	if len(msg.To) > 0 {
+		m.SetHeader("To", msg.To...)
+	}
+	if len(msg.Cc) > 0 {
+		m.SetHeader("Cc", msg.Cc...)
+	}
+	if len(msg.Bcc) > 0 {
+		m.SetHeader("Bcc", msg.Bcc...)
+	}
+	m.SetHeader("Subject", msg.Subject)
+
+	if text, err := PlainText(msg.Body); err == nil && text != "" {
+		m.SetBody("text/plain", text)
+		m.AddAlternative("text/html", msg.Body)
+	} else {
+		m.SetBody("text/html", msg.Body)
+	}
+	m.Embed(logoFilename, gomail.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write(assets.Logo)
+		return err
+	}), gomail.SetHeader(map[string][]string{"Content-ID": {"<logo>"}}))
+	for _, a := range msg.Attachments {
+		copyData := gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(a.Data)
+			return err
+		})
+		if a.ContentID != "" {
+			m.Embed(a.Filename, copyData, gomail.SetHeader(map[string][]string{
+				"Content-Type": {a.ContentType},
+				"Content-ID":   {"<" + a.ContentID + ">"},
+			}))
+			continue
+		}
+		m.Attach(a.Filename, copyData, gomail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}}))
+	}
+	return m
+}
 
-	m.SetBody("text/html", body)
-	m.Embed(LogoPath, gomail.SetHeader(map[string][]string{"Content-ID": {"<logo>"}}))
+func (s SMTPSender) SendEmail(ctx context.Context, msg Message) (err error) {
+	_, span := tracing.Start(ctx, "SMTPSender.SendEmail", attribute.String("smtp.host", config.AppConfig.SMTPHost))
+	defer func() { tracing.End(span, err) }()
 
-	d := gomail.NewDialer(config.AppConfig.SMTPHost, config.AppConfig.SMTPPort, config.AppConfig.SMTPUser, config.AppConfig.SMTPPassword)
+	// gomail can't be cancelled mid-send, so at least don't start late
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	m := newMessage(config.AppConfig.FromEmail, msg)
+	d := gomail.NewDialer(config.AppConfig.SMTPHost, config.AppConfig.SMTPPort, config.AppConfig.SMTPUser, config.AppConfig.SMTPPassword.Reveal())
 
-	if err := d.DialAndSend(m); err != nil {
+	start := time.Now()
+	logger := logging.OrDefault(s.Logger).With("backend", "smtp", "smtp_host", config.AppConfig.SMTPHost, "to", logging.MaskEmails(msg.To), "recipients", len(msg.Recipients()))
+	err = d.DialAndSend(m)
+	metrics.ObserveEmail("smtp", err)
+	if err != nil {
+		logger.Error("sending email failed", "outcome", "failure", "error", err, "duration_ms", logging.Since(start))
 		return err
 	}
+	logger.Info("email sent", "outcome", "success", "duration_ms", logging.Since(start))
 	return nil
 }
 
+// LoadTemplate parses and executes the template file at templatePath.
+//
+// Deprecated: use a Registry, which parses once, shares layouts and
+// partials and inlines CSS.
 func LoadTemplate(templatePath string, data EmailData) (string, error) {
 	tmpl, err := template.ParseFiles(templatePath)
 	if err != nil {
@@ -71,6 +218,9 @@ func GenerateEmailData(totalBalance float64, summary map[string]transactions.Sum
 	return emailData
 }
 
+// RenderTemplate parses and executes the template file at templatePath.
+//
+// Deprecated: use a Registry.
 func RenderTemplate(templatePath string, data interface{}) (string, error) {
 	tmpl, err := template.ParseFiles(templatePath)
 	if err != nil {
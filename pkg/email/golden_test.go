@@ -0,0 +1,105 @@
+package email_test
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"stori-technical-challenge/pkg/anomaly"
+	"stori-technical-challenge/pkg/email"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run "go test ./pkg/email -run TestGolden -update" after changing a
+// template, and review the diff of testdata before committing it.
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenPages says how to render each page's JSON fixtures: the type to
+// decode them into and the locales to render them in. Every page needs an
+// entry, so a new template can't ship without golden files.
+var goldenPages = map[string]struct {
+	newData func() interface{}
+	locales []string
+}{
+	email.SummaryTemplate: {func() interface{} { return &email.EmailData{} }, []string{"en", "es"}},
+	// Alerts only go to alert_email, in English
+	email.AlertTemplate: {func() interface{} { return &anomaly.Notice{} }, []string{"en"}},
+}
+
+// TestGolden renders every fixture in testdata/<page>/ through its page in
+// each of the page's locales, and compares the HTML and its plain-text
+// alternative with the golden files next to the fixture:
+// <fixture>.golden.html and .golden.txt in English, and
+// <fixture>.<locale>.golden.html and .txt in other locales.
+func TestGolden(t *testing.T) {
+	pages, err := email.DefaultTemplates.Pages()
+	require.NoError(t, err)
+	require.NotEmpty(t, pages)
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(page, filepath.Ext(page))
+		t.Run(name, func(t *testing.T) {
+			golden, ok := goldenPages[page]
+			require.True(t, ok, "no fixture type for %s", page)
+
+			fixtures, err := filepath.Glob(filepath.Join("testdata", name, "*.json"))
+			require.NoError(t, err)
+			require.NotEmpty(t, fixtures, "no fixtures in testdata/%s", name)
+
+			for _, fixture := range fixtures {
+				for _, locale := range golden.locales {
+					t.Run(filepath.Base(fixture)+"/"+locale, func(t *testing.T) {
+						data := golden.newData()
+						readFixture(t, fixture, data)
+
+						got, err := email.DefaultTemplates.RenderLocale(page, locale, data)
+						require.NoError(t, err)
+						text, err := email.PlainText(got)
+						require.NoError(t, err)
+
+						base := strings.TrimSuffix(fixture, ".json")
+						if locale != email.TemplateLocale {
+							base += "." + locale
+						}
+						checkGolden(t, base+".golden.html", got)
+						checkGolden(t, base+".golden.txt", text)
+					})
+				}
+			}
+		})
+	}
+}
+
+// TestGoldenLegacyTemplate renders the single-file template deployments
+// used before the registry, which LoadTemplate still accepts.
+func TestGoldenLegacyTemplate(t *testing.T) {
+	var data email.EmailData
+	readFixture(t, filepath.Join("testdata", "summary", "basic.json"), &data)
+
+	got, err := email.LoadTemplate(filepath.Join("testdata", "legacy", "email_template.html"), data)
+	require.NoError(t, err)
+	checkGolden(t, filepath.Join("testdata", "legacy", "basic.golden.html"), got)
+}
+
+func readFixture(t *testing.T, fixture string, data interface{}) {
+	t.Helper()
+	raw, err := os.ReadFile(fixture)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(raw, data))
+}
+
+// checkGolden compares got with the golden file, rewriting it first with
+// -update.
+func checkGolden(t *testing.T, golden, got string) {
+	t.Helper()
+	if *update {
+		require.NoError(t, os.WriteFile(golden, []byte(got), 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err, "run with -update to create it")
+	assert.Equal(t, string(want), got, "output differs from %s; if the change is intended run with -update", golden)
+}
@@ -0,0 +1,137 @@
+package email
+
+import (
+	"fmt"
+	"html/template"
+	"stori-technical-challenge/pkg/transactions"
+	"strings"
+	"time"
+)
+
+// TemplateLocale is the language the templates are written in. Other
+// locales translate the templates' text through the t function.
+const TemplateLocale = "en"
+
+// translations maps each supported language to the translations of the
+// templates' English text. Text missing from a catalog stays in English.
+var translations = map[string]map[string]string{
+	"es": {
+		// Subjects
+		"Stori - Transaction Summary":                 "Stori - Resumen de transacciones",
+		"Stori - Transaction Summary - Action needed": "Stori - Resumen de transacciones - Acción requerida",
+
+		// summary.html
+		"Transaction Summary":              "Resumen de transacciones",
+		"Total balance is %s":              "El saldo total es %s",
+		"Number of transactions in %s: %d": "Número de transacciones en %s: %d",
+		"Average debit amount: %s":         "Monto promedio de débito: %s",
+		"Average credit amount: %s":        "Monto promedio de crédito: %s",
+
+		// budgets.html
+		"Your balance of %s is below your threshold of %s.": "Tu saldo de %s está por debajo de tu umbral de %s.",
+		"Budgets":      "Presupuestos",
+		"Budget":       "Presupuesto",
+		"Month":        "Mes",
+		"Spent":        "Gastado",
+		"Limit":        "Límite",
+		"Used":         "Usado",
+		"All spending": "Todo el gasto",
+		"%s over":      "%s por encima",
+
+		// comparison.html and the names of trend metrics
+		"%s compared":       "%s comparado",
+		"vs previous month": "vs. mes anterior",
+		"vs a year ago":     "vs. hace un año",
+		"from 0":            "desde 0",
+		"Transactions":      "Transacciones",
+		"Total credits":     "Total de créditos",
+		"Total debits":      "Total de débitos",
+		"Average credit":    "Crédito promedio",
+		"Average debit":     "Débito promedio",
+		"Net":               "Neto",
+
+		// Charts and footer
+		"Monthly credits and debits": "Créditos y débitos por mes",
+		"Running balance":            "Saldo acumulado",
+		"Stori Company Logo":         "Logo de Stori",
+
+		// recurring.html
+		"Subscriptions":               "Suscripciones",
+		"Subscription":                "Suscripción",
+		"Every":                       "Cada",
+		"Week":                        "Semana",
+		"Monthly cost":                "Costo mensual",
+		"Next charge":                 "Próximo cargo",
+		"Recurring charge":            "Cargo recurrente",
+		"Total":                       "Total",
+		"Recurring payments to check": "Pagos recurrentes por revisar",
+		"A recurring payment":         "Un pago recurrente",
+		"%s of %s was expected on %s but didn't arrive.": "Se esperaba %s de %s el %s, pero no llegó.",
+		"%s was %s on %s instead of the usual %s.":       "%s fue de %s el %s en lugar de los %s habituales.",
+
+		// spending.html
+		"Spending by category": "Gasto por categoría",
+		"Category":             "Categoría",
+		"Amount":               "Monto",
+		"Share":                "Porcentaje",
+	},
+}
+
+// monthNames are the names of the months in each supported language other
+// than English, January first.
+var monthNames = map[string][12]string{
+	"es": {"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+}
+
+// Translate returns text in the language of locale, formatted with args as
+// by fmt.Sprintf. Locales are matched by language, so "es-MX" gets the
+// Spanish text; unsupported ones get English.
+func Translate(locale, text string, args ...interface{}) string {
+	if translated, ok := translations[language(locale)][text]; ok {
+		text = translated
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// language returns the supported language of locale, or TemplateLocale.
+func language(locale string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	lang, _, _ = strings.Cut(lang, "_")
+	if _, ok := translations[lang]; ok {
+		return lang
+	}
+	return TemplateLocale
+}
+
+// localeFuncs replaces the template functions whose output depends on the
+// language.
+func localeFuncs(lang string) template.FuncMap {
+	names, ok := monthNames[lang]
+	if !ok {
+		return template.FuncMap{"t": translator(lang)}
+	}
+	return template.FuncMap{
+		"t": translator(lang),
+		// "15 jul 2024"
+		"date": func(t time.Time) string {
+			return fmt.Sprintf("%d %s %d", t.Day(), names[t.Month()-1][:3], t.Year())
+		},
+		// "julio 2024"
+		"month": func(key string) string {
+			month, err := time.Parse(transactions.MonthLayout, key)
+			if err != nil {
+				return key
+			}
+			return fmt.Sprintf("%s %d", names[month.Month()-1], month.Year())
+		},
+	}
+}
+
+func translator(lang string) func(string, ...interface{}) string {
+	return func(text string, args ...interface{}) string {
+		return Translate(lang, text, args...)
+	}
+}
@@ -0,0 +1,292 @@
+package email
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// InlineCSS copies the rules of the document's <style> blocks into the
+// style attribute of every element in <body> they match, since Gmail and
+// Outlook ignore or strip stylesheets. Declarations already in a style
+// attribute win, as they would in a browser. The <style> blocks are kept
+// for clients that do honor them, and for at-rules such as @media, which
+// can't be inlined.
+//
+// Selectors made of element names, classes and ids, optionally combined
+// with descendant combinators ("div.card p"), are supported; rules using
+// anything else are left to the <style> block.
+func InlineCSS(document string) (string, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+
+	var css strings.Builder
+	var body *html.Node
+	walk(root, func(n *html.Node) {
+		switch {
+		case n.DataAtom == atom.Style && n.FirstChild != nil:
+			css.WriteString(n.FirstChild.Data)
+			css.WriteString("\n")
+		case n.DataAtom == atom.Body:
+			body = n
+		}
+	})
+	rules := parseCSS(css.String())
+	if body == nil || len(rules) == 0 {
+		return document, nil
+	}
+
+	walk(body, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		var matched []match
+		for _, r := range rules {
+			for _, sel := range r.selectors {
+				if sel.matches(n) {
+					matched = append(matched, match{specificity: sel.specificity(), declarations: r.declarations})
+				}
+			}
+		}
+		if len(matched) == 0 {
+			return
+		}
+		// Stable, so rules of equal specificity keep their source order
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].specificity < matched[j].specificity })
+
+		var style declarations
+		for _, m := range matched {
+			style = style.merge(m.declarations)
+		}
+		for i, a := range n.Attr {
+			if a.Key == "style" {
+				style = style.merge(parseDeclarations(a.Val))
+				n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+				break
+			}
+		}
+		n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: style.String()})
+	})
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, root); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func walk(n *html.Node, visit func(*html.Node)) {
+	visit(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, visit)
+	}
+}
+
+type rule struct {
+	selectors    []selector
+	declarations declarations
+}
+
+type match struct {
+	specificity  int
+	declarations declarations
+}
+
+var (
+	cssComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	// simpleSelector is an element name, classes and an id, in any order.
+	simpleSelector = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*)?((?:[.#][a-zA-Z_-][a-zA-Z0-9_-]*)*)$`)
+	selectorToken  = regexp.MustCompile(`[.#][^.#]+`)
+)
+
+// parseCSS returns the inlinable rules of a stylesheet, in source order.
+func parseCSS(css string) []rule {
+	css = cssComment.ReplaceAllString(css, "")
+	var rules []rule
+	for len(css) > 0 {
+		open := strings.IndexByte(css, '{')
+		if open < 0 {
+			break
+		}
+		prelude := strings.TrimSpace(css[:open])
+		end := matchingBrace(css, open)
+		block := css[open+1 : end]
+		css = css[min(end+1, len(css)):]
+
+		if strings.HasPrefix(prelude, "@") {
+			continue
+		}
+		r := rule{declarations: parseDeclarations(block)}
+		for _, s := range strings.Split(prelude, ",") {
+			if sel, ok := parseSelector(s); ok {
+				r.selectors = append(r.selectors, sel)
+			}
+		}
+		if len(r.selectors) > 0 && len(r.declarations) > 0 {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// matchingBrace returns the index of the brace closing the one at open, or
+// len(css) if it is never closed.
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(css)
+}
+
+// selector is a chain of compound selectors joined by descendant
+// combinators, outermost first.
+type selector []compound
+
+type compound struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+func parseSelector(s string) (selector, bool) {
+	var sel selector
+	for _, part := range strings.Fields(s) {
+		m := simpleSelector.FindStringSubmatch(part)
+		if m == nil {
+			return nil, false
+		}
+		c := compound{tag: strings.ToLower(m[1])}
+		for _, token := range selectorToken.FindAllString(m[2], -1) {
+			if token[0] == '#' {
+				c.id = token[1:]
+			} else {
+				c.classes = append(c.classes, token[1:])
+			}
+		}
+		sel = append(sel, c)
+	}
+	return sel, len(sel) > 0
+}
+
+// specificity orders selectors the way CSS does: ids, then classes, then
+// element names.
+func (s selector) specificity() int {
+	total := 0
+	for _, c := range s {
+		if c.id != "" {
+			total += 10000
+		}
+		total += 100 * len(c.classes)
+		if c.tag != "" {
+			total++
+		}
+	}
+	return total
+}
+
+func (s selector) matches(n *html.Node) bool {
+	last := len(s) - 1
+	if !s[last].matches(n) {
+		return false
+	}
+	i := last - 1
+	for p := n.Parent; p != nil && i >= 0; p = p.Parent {
+		if p.Type == html.ElementNode && s[i].matches(p) {
+			i--
+		}
+	}
+	return i < 0
+}
+
+func (c compound) matches(n *html.Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	classes := strings.Fields(attr(n, "class"))
+	for _, want := range c.classes {
+		found := false
+		for _, have := range classes {
+			if have == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// declarations are CSS property/value pairs in the order they were set.
+type declarations []declaration
+
+type declaration struct {
+	property, value string
+}
+
+func parseDeclarations(block string) declarations {
+	var d declarations
+	for _, decl := range strings.Split(block, ";") {
+		property, value, ok := strings.Cut(decl, ":")
+		property, value = strings.ToLower(strings.TrimSpace(property)), strings.TrimSpace(value)
+		if ok && property != "" && value != "" {
+			d = d.merge(declarations{{property, value}})
+		}
+	}
+	return d
+}
+
+// merge returns d with other's declarations applied on top.
+func (d declarations) merge(other declarations) declarations {
+	merged := append(declarations(nil), d...)
+	for _, o := range other {
+		replaced := false
+		for i := range merged {
+			if merged[i].property == o.property {
+				merged[i].value = o.value
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
+
+func (d declarations) String() string {
+	parts := make([]string, len(d))
+	for i, decl := range d {
+		parts[i] = decl.property + ": " + decl.value
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,40 @@
+package email_test
+
+import (
+	"stori-technical-challenge/pkg/email"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInlineCSS(t *testing.T) {
+	doc := `<html><head><style>
+		/* comment { color: red } */
+		p { color: black; margin: 0 }
+		.note { color: gray }
+		#total.note { color: green }
+		div p { font-size: 12px }
+		a:hover { color: blue }
+		@media (max-width: 600px) { p { color: purple } }
+	</style></head><body>
+		<div><p class="note" style="margin: 4px">One</p></div>
+		<p id="total" class="note">Two</p>
+		<a href="#">Link</a>
+	</body></html>`
+
+	out, err := email.InlineCSS(doc)
+	require.NoError(t, err)
+
+	assert.Contains(t, out, `<p class="note" style="color: gray; margin: 4px; font-size: 12px">One</p>`)
+	assert.Contains(t, out, `<p id="total" class="note" style="color: green; margin: 0">Two</p>`)
+	assert.Contains(t, out, `<a href="#">Link</a>`, "pseudo-classes can't be inlined")
+	assert.Contains(t, out, "@media (max-width: 600px)", "the <style> block is kept")
+}
+
+func TestInlineCSSWithoutStyles(t *testing.T) {
+	doc := `<p>No styles</p>`
+	out, err := email.InlineCSS(doc)
+	require.NoError(t, err)
+	assert.Equal(t, doc, out)
+}
@@ -0,0 +1,182 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/metrics"
+	"stori-technical-challenge/pkg/tracing"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/gomail.v2"
+)
+
+// PooledSMTPSender is an EmailSender that keeps one SMTP connection open and
+// reuses it for many messages, for bulk sends where a connection per message
+// gets throttled by the provider. Sends are serialized and spaced out to
+// honor the rate limit. Call Close when done.
+type PooledSMTPSender struct {
+	dialer *gomail.Dialer
+	host   string
+	from   string
+	// interval is the minimum time between two sends; zero means no limit.
+	interval    time.Duration
+	maxPerConn  int
+	idleTimeout time.Duration
+	logger      *slog.Logger
+
+	mu         sync.Mutex
+	conn       gomail.SendCloser
+	sentOnConn int
+	nextSend   time.Time
+	idleTimer  *time.Timer
+}
+
+// PoolOptions tunes a PooledSMTPSender. Zero values select the defaults.
+type PoolOptions struct {
+	// IdleTimeout closes the connection after this long without sends, before
+	// the server drops it. Defaults to 30s.
+	IdleTimeout time.Duration
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// NewPooledSMTPSender returns a sender for the SMTP server in cfg, limited
+// to cfg.SMTPRateLimit messages per second and cfg.SMTPMaxPerConnection
+// messages per connection.
+func NewPooledSMTPSender(cfg config.Config, opts PoolOptions) *PooledSMTPSender {
+	s := &PooledSMTPSender{
+		dialer:      gomail.NewDialer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPassword.Reveal()),
+		from:        cfg.FromEmail,
+		maxPerConn:  cfg.SMTPMaxPerConnection,
+		idleTimeout: opts.IdleTimeout,
+		logger:      logging.OrDefault(opts.Logger).With("backend", "smtp", "smtp_host", cfg.SMTPHost),
+		host:        cfg.SMTPHost,
+	}
+	if s.idleTimeout <= 0 {
+		s.idleTimeout = 30 * time.Second
+	}
+	if cfg.SMTPRateLimit > 0 {
+		s.interval = time.Duration(float64(time.Second) / cfg.SMTPRateLimit)
+	}
+	return s
+}
+
+func (s *PooledSMTPSender) SendEmail(ctx context.Context, msg Message) (err error) {
+	_, span := tracing.Start(ctx, "PooledSMTPSender.SendEmail", attribute.String("smtp.host", s.host))
+	defer func() { tracing.End(span, err) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	logger := s.logger.With("to", logging.MaskEmails(msg.To), "recipients", len(msg.Recipients()))
+	m := newMessage(s.from, msg)
+
+	reused := s.conn != nil
+	err = s.send(ctx, m)
+	if err != nil && reused && ctx.Err() == nil {
+		// The server may have dropped the idle connection; try once more
+		// on a fresh one
+		logger.Warn("pooled connection failed, reconnecting", "error", err)
+		err = s.send(ctx, m)
+	}
+	metrics.ObserveEmail("smtp", err)
+	if err != nil {
+		logger.Error("sending email failed", "outcome", "failure", "error", err, "duration_ms", logging.Since(start))
+		return err
+	}
+	logger.Info("email sent", "outcome", "success", "duration_ms", logging.Since(start))
+	return nil
+}
+
+// send delivers m over the current connection, dialing a new one first if
+// there is none or the current one has reached its message limit. The
+// connection is discarded if the send fails.
+func (s *PooledSMTPSender) send(ctx context.Context, m *gomail.Message) error {
+	if s.conn != nil && s.maxPerConn > 0 && s.sentOnConn >= s.maxPerConn {
+		s.closeConn()
+	}
+	if s.conn == nil {
+		conn, err := dialSMTP(ctx, s.dialer)
+		if err != nil {
+			return fmt.Errorf("error connecting to SMTP server: %w", err)
+		}
+		s.conn = conn
+		s.sentOnConn = 0
+	}
+
+	if err := gomail.Send(s.conn, m); err != nil {
+		s.closeConn()
+		return err
+	}
+	s.sentOnConn++
+	s.resetIdleTimer()
+	return nil
+}
+
+// wait blocks until the rate limit allows another send.
+func (s *PooledSMTPSender) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.interval == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if delay := s.nextSend.Sub(now); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		now = s.nextSend
+	}
+	s.nextSend = now.Add(s.interval)
+	return nil
+}
+
+func (s *PooledSMTPSender) resetIdleTimer() {
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	s.idleTimer = time.AfterFunc(s.idleTimeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closeConn()
+	})
+}
+
+func (s *PooledSMTPSender) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// Close closes the open connection, if any. The sender can still be used
+// afterwards; it reconnects on the next send.
+func (s *PooledSMTPSender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
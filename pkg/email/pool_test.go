@@ -0,0 +1,259 @@
+package email_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"stori-technical-challenge/assets"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/email"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTP is a minimal SMTP server that counts connections and records
+// accepted messages. With dropAfter set it hangs up after that many messages on a
+// connection without telling the client.
+type fakeSMTP struct {
+	ln        net.Listener
+	dropAfter int
+
+	mu          sync.Mutex
+	connections int
+	recipients  []string
+	messages    []string
+}
+
+func startFakeSMTP(t *testing.T, dropAfter int) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeSMTP{ln: ln, dropAfter: dropAfter}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.connections++
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	var rcpt string
+	accepted := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 fake")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			rcpt = strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var message strings.Builder
+			for {
+				data, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if data == ".\r\n" {
+					break
+				}
+				message.WriteString(data)
+			}
+			f.mu.Lock()
+			f.recipients = append(f.recipients, rcpt)
+			f.messages = append(f.messages, message.String())
+			f.mu.Unlock()
+			reply("250 OK")
+			accepted++
+			if f.dropAfter > 0 && accepted >= f.dropAfter {
+				return
+			}
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (f *fakeSMTP) stats() (int, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connections, append([]string(nil), f.recipients...)
+}
+
+func (f *fakeSMTP) config() config.Config {
+	_, port, _ := net.SplitHostPort(f.ln.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return config.Config{SMTPHost: "127.0.0.1", SMTPPort: n, FromEmail: "from@example.com"}
+}
+
+func newPooledSender(cfg config.Config) *email.PooledSMTPSender {
+	return email.NewPooledSMTPSender(cfg, email.PoolOptions{})
+}
+
+func sendAll(t *testing.T, sender email.EmailSender, n int) {
+	for i := 0; i < n; i++ {
+		require.NoError(t, sender.SendEmail(context.Background(), email.NewMessage("user"+strconv.Itoa(i)+"@example.com", "Summary", "<p>hi</p>")))
+	}
+}
+
+func TestPooledSenderReusesConnections(t *testing.T) {
+	server := startFakeSMTP(t, 0)
+	cfg := server.config()
+	cfg.SMTPMaxPerConnection = 2
+	sender := newPooledSender(cfg)
+	defer sender.Close()
+
+	sendAll(t, sender, 5)
+
+	connections, recipients := server.stats()
+	assert.Equal(t, 3, connections, "2 messages per connection")
+	assert.Len(t, recipients, 5)
+}
+
+func TestPooledSenderReconnectsAfterDrop(t *testing.T) {
+	server := startFakeSMTP(t, 1)
+	sender := newPooledSender(server.config())
+	defer sender.Close()
+
+	sendAll(t, sender, 3)
+
+	connections, recipients := server.stats()
+	assert.Equal(t, 3, connections)
+	assert.Equal(t, []string{"user0@example.com", "user1@example.com", "user2@example.com"}, recipients)
+}
+
+func TestPooledSenderRateLimit(t *testing.T) {
+	server := startFakeSMTP(t, 0)
+	cfg := server.config()
+	cfg.SMTPRateLimit = 20
+	sender := newPooledSender(cfg)
+	defer sender.Close()
+
+	start := time.Now()
+	sendAll(t, sender, 4)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond, "4 messages at 20/s take at least 3 intervals")
+
+	connections, _ := server.stats()
+	assert.Equal(t, 1, connections)
+}
+
+func TestPooledSenderHonorsContext(t *testing.T) {
+	server := startFakeSMTP(t, 0)
+	cfg := server.config()
+	cfg.SMTPRateLimit = 0.1
+	sender := newPooledSender(cfg)
+	defer sender.Close()
+
+	sendAll(t, sender, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := sender.SendEmail(ctx, email.NewMessage("late@example.com", "Summary", "<p>hi</p>"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPooledSenderSendsBccOnlyMessages(t *testing.T) {
+	server := startFakeSMTP(t, 0)
+	sender := newPooledSender(server.config())
+	defer sender.Close()
+
+	require.NoError(t, sender.SendEmail(context.Background(), email.Message{Bcc: []string{"auditor@example.com"}, Subject: "Summary", Body: "<p>hi</p>"}))
+	_, recipients := server.stats()
+	assert.Equal(t, []string{"auditor@example.com"}, recipients)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	assert.NotContains(t, server.messages[0], "To:")
+	assert.NotContains(t, server.messages[0], "auditor@example.com", "Bcc addresses stay out of the headers")
+}
+
+func TestPooledSenderGivesUpOnHungDial(t *testing.T) {
+	// Accepts connections but never greets, like a wedged server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	n, _ := strconv.Atoi(port)
+	sender := newPooledSender(config.Config{SMTPHost: "127.0.0.1", SMTPPort: n, FromEmail: "from@example.com"})
+	defer sender.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = sender.SendEmail(ctx, email.NewMessage("user@example.com", "Summary", "<p>hi</p>"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestPooledSenderSendsAttachmentsAndInlineImages(t *testing.T) {
+	server := startFakeSMTP(t, 0)
+	sender := newPooledSender(server.config())
+	defer sender.Close()
+
+	msg := email.NewMessage("user@example.com", "Summary", "<p>hi</p>")
+	msg.Attachments = []email.Attachment{
+		{Filename: "statement.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.3")},
+		{Filename: "chart.png", ContentType: "image/png", ContentID: "chart", Data: []byte("PNG")},
+	}
+	require.NoError(t, sender.SendEmail(context.Background(), msg))
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	require.Len(t, server.messages, 1)
+	assert.Contains(t, server.messages[0], "Content-Type: application/pdf")
+	assert.Contains(t, server.messages[0], `filename="statement.pdf"`)
+	assert.Contains(t, server.messages[0], base64.StdEncoding.EncodeToString([]byte("%PDF-1.3")))
+	assert.Contains(t, server.messages[0], "Content-ID: <chart>")
+	assert.Contains(t, server.messages[0], `Content-Disposition: inline; filename="chart.png"`)
+
+	// The logo comes from the binary, whatever the working directory
+	assert.Contains(t, server.messages[0], "Content-ID: <logo>")
+	assert.Contains(t, server.messages[0], base64.StdEncoding.EncodeToString(assets.Logo[:57]), "the first line of the encoded logo")
+}
@@ -0,0 +1,168 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"sort"
+	"stori-technical-challenge/pkg/format"
+	"sync"
+)
+
+// SummaryTemplate is the template of the transaction summary email.
+const SummaryTemplate = "summary.html"
+
+// AlertTemplate is the template of the unusual activity email. It renders
+// an anomaly.Notice.
+const AlertTemplate = "alert.html"
+
+// templateFS holds the built-in templates. Pages live at the top level;
+// every page is parsed together with all of layouts/ and partials/.
+//
+//go:embed templates
+var templateFS embed.FS
+
+// Funcs are the functions available to every template. t translates text
+// to the locale the page is rendered in, formatting it with its arguments
+// as fmt.Sprintf does; date and month name months in that locale.
+var Funcs = template.FuncMap{
+	"currency": format.Currency,
+	"percent":  format.Percent,
+	"date":     format.Date,
+	"month":    format.Month,
+	"t":        translator(TemplateLocale),
+}
+
+// DefaultTemplates renders the built-in templates.
+var DefaultTemplates = NewRegistry("")
+
+// Registry renders email templates, parsing each page once per locale and
+// inlining its CSS so clients that drop <style> blocks still show it styled.
+type Registry struct {
+	fsys fs.FS
+
+	mu    sync.Mutex
+	pages map[string]*template.Template
+}
+
+// NewRegistry returns a registry over the built-in templates. Files in
+// overrideDir replace built-in files with the same path, so a deployment
+// can restyle a single partial or add a page without rebuilding.
+func NewRegistry(overrideDir string) *Registry {
+	builtin, _ := fs.Sub(templateFS, "templates")
+	fsys := builtin
+	if overrideDir != "" {
+		fsys = overlayFS{override: os.DirFS(overrideDir), base: builtin}
+	}
+	return &Registry{fsys: fsys, pages: make(map[string]*template.Template)}
+}
+
+// Render executes the page name with data in English and inlines its CSS.
+func (r *Registry) Render(name string, data interface{}) (string, error) {
+	return r.RenderLocale(name, TemplateLocale, data)
+}
+
+// RenderLocale executes the page name with data in the language of locale,
+// e.g. "es" or "es-MX", and inlines its CSS. Unsupported locales get
+// English.
+func (r *Registry) RenderLocale(name, locale string, data interface{}) (string, error) {
+	page, err := r.page(name, language(locale))
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := page.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", fmt.Errorf("error executing template %s: %w", name, err)
+	}
+	html, err := InlineCSS(buf.String())
+	if err != nil {
+		return "", fmt.Errorf("error inlining CSS for %s: %w", name, err)
+	}
+	return html, nil
+}
+
+// Pages returns the names of the pages Render accepts, sorted.
+func (r *Registry) Pages() ([]string, error) {
+	pages, err := fs.Glob(r.fsys, "*.html")
+	if err != nil {
+		return nil, fmt.Errorf("error listing templates: %w", err)
+	}
+	return pages, nil
+}
+
+// page returns the page name parsed with the functions of lang.
+func (r *Registry) page(name, lang string) (*template.Template, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := name
+	if lang != TemplateLocale {
+		key += "@" + lang
+	}
+	if page, ok := r.pages[key]; ok {
+		return page, nil
+	}
+
+	patterns := []string{name}
+	for _, dir := range []string{"layouts", "partials"} {
+		matches, err := fs.Glob(r.fsys, dir+"/*.html")
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s: %w", dir, err)
+		}
+		patterns = append(matches, patterns...)
+	}
+	// The page is parsed last so its definitions override the layout's
+	// default blocks
+	page, err := template.New(name).Funcs(Funcs).Funcs(localeFuncs(lang)).ParseFS(r.fsys, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %s: %w", name, err)
+	}
+	r.pages[key] = page
+	return page, nil
+}
+
+// overlayFS reads files from override when they exist there and from base
+// otherwise. Directory listings merge both.
+type overlayFS struct {
+	override, base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.override.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	byName := make(map[string]fs.DirEntry)
+	found := false
+	for _, fsys := range []fs.FS{o.base, o.override} {
+		entries, err := fs.ReadDir(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, e := range entries {
+			byName[e.Name()] = e
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(byName))
+	for _, e := range byName {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
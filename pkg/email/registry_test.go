@@ -0,0 +1,107 @@
+package email_test
+
+import (
+	"os"
+	"path/filepath"
+	"stori-technical-challenge/pkg/budget"
+	"stori-technical-challenge/pkg/email"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryRendersSummary(t *testing.T) {
+	body, err := email.DefaultTemplates.Render(email.SummaryTemplate, email.EmailData{
+		TotalBalance:    1234.5,
+		NumTransactions: map[string]int{"July": 2},
+		AvgDebitAmount:  -10.3,
+		Charts:          []email.ChartImage{{ContentID: "chart-balance", Alt: "Balance"}},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, body, "Total balance is $1,234.50")
+	assert.Contains(t, body, "Average debit amount: -$10.30")
+	assert.Contains(t, body, "Number of transactions in July: 2")
+	assert.Contains(t, body, `src="cid:chart-balance"`)
+	assert.Contains(t, body, `src="cid:logo"`, "the footer partial is included")
+	assert.NotContains(t, body, "bootstrap")
+	assert.Regexp(t, `<h2 style="[^"]*color: #003b46`, body, "CSS is inlined")
+}
+
+func TestRegistryRendersLocales(t *testing.T) {
+	data := email.EmailData{
+		TotalBalance:    1234.5,
+		NumTransactions: map[string]int{"2024-07": 2},
+		Budgets:         []budget.Result{{Budget: budget.Budget{Kind: budget.Spending, Amount: 300}, Month: "2024-07", Actual: 315.99, Status: budget.Breached}},
+	}
+	for _, locale := range []string{"es", "es-MX", "ES_mx"} {
+		body, err := email.DefaultTemplates.RenderLocale(email.SummaryTemplate, locale, data)
+		require.NoError(t, err)
+		assert.Contains(t, body, "<title>Resumen de transacciones</title>", locale)
+		assert.Contains(t, body, "El saldo total es $1,234.50", locale)
+		assert.Contains(t, body, "julio 2024", locale)
+		assert.Contains(t, body, "Todo el gasto", locale)
+		assert.Contains(t, body, "$15.99 por encima", locale)
+	}
+
+	// Unsupported locales and Render get English
+	for _, render := range []func() (string, error){
+		func() (string, error) { return email.DefaultTemplates.RenderLocale(email.SummaryTemplate, "fr", data) },
+		func() (string, error) { return email.DefaultTemplates.Render(email.SummaryTemplate, data) },
+	} {
+		body, err := render()
+		require.NoError(t, err)
+		assert.Contains(t, body, "Total balance is $1,234.50")
+		assert.Contains(t, body, "July 2024")
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	assert.Equal(t, "Stori - Resumen de transacciones", email.Translate("es", "Stori - Transaction Summary"))
+	assert.Equal(t, "El saldo total es $5.00", email.Translate("es-AR", "Total balance is %s", "$5.00"))
+	assert.Equal(t, "Total balance is $5.00", email.Translate("en", "Total balance is %s", "$5.00"))
+	assert.Equal(t, "Not in the catalog", email.Translate("es", "Not in the catalog"))
+}
+
+func TestRegistryOverrideDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "partials"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "partials", "footer.html"), []byte(`{{define "footer"}}<p class="footer">Custom footer</p>{{end}}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notice.html"), []byte(`{{template "base" .}}{{define "content"}}<p>Due {{date .}}</p>{{end}}`), 0o644))
+
+	registry := email.NewRegistry(dir)
+	summary, err := registry.Render(email.SummaryTemplate, email.EmailData{})
+	require.NoError(t, err)
+	assert.Contains(t, summary, "Custom footer")
+	assert.NotContains(t, summary, "cid:logo")
+
+	// A page that only exists in the override directory still gets the
+	// built-in layout
+	notice, err := registry.Render("notice.html", time.Date(2024, 7, 15, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Contains(t, notice, "<title>")
+	assert.Contains(t, notice, "Due Jul 15, 2024")
+}
+
+func TestRegistryCachesParsedPages(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	require.NoError(t, os.WriteFile(page, []byte(`{{define "content"}}first{{end}}{{template "base" .}}`), 0o644))
+
+	registry := email.NewRegistry(dir)
+	body, err := registry.Render("page.html", nil)
+	require.NoError(t, err)
+	assert.Contains(t, body, "first")
+
+	require.NoError(t, os.WriteFile(page, []byte(`second`), 0o644))
+	body, err = registry.Render("page.html", nil)
+	require.NoError(t, err)
+	assert.Contains(t, body, "first", "pages are parsed once")
+}
+
+func TestRegistryMissingPage(t *testing.T) {
+	_, err := email.DefaultTemplates.Render("missing.html", nil)
+	assert.Error(t, err)
+}
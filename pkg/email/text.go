@@ -0,0 +1,167 @@
+package email
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// PlainText renders the <body> of an HTML email as plain text, for clients
+// that don't show HTML and for spam filters that penalize HTML-only mail.
+// Block elements start a new line, headings and tables are set off by
+// blank lines, table cells are separated by " | " and images are replaced
+// by their alt text in brackets.
+func PlainText(document string) (string, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+	// html.Parse always adds a <body>
+	var body *html.Node
+	walk(root, func(n *html.Node) {
+		if n.DataAtom == atom.Body && body == nil {
+			body = n
+		}
+	})
+	var w textWriter
+	w.node(body)
+
+	var lines []string
+	blank := true
+	for _, line := range strings.Split(w.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, line)
+		blank = false
+	}
+	text := strings.TrimSpace(strings.Join(lines, "\n"))
+	if text == "" {
+		return "", nil
+	}
+	return text + "\n", nil
+}
+
+// textWriter accumulates the text of a document, collapsing whitespace the
+// way a browser would.
+type textWriter struct {
+	strings.Builder
+	// space is set when whitespace was seen since the last word.
+	space bool
+}
+
+func (w *textWriter) word(s string) {
+	if w.space && w.Len() > 0 && !strings.HasSuffix(w.String(), "\n") {
+		w.WriteString(" ")
+	}
+	w.space = false
+	w.WriteString(s)
+}
+
+func (w *textWriter) text(s string) {
+	if s == "" {
+		return
+	}
+	if strings.TrimLeft(s, " \t\r\n") != s {
+		w.space = true
+	}
+	for _, word := range strings.Fields(s) {
+		w.word(word)
+		w.space = true
+	}
+	if strings.TrimRight(s, " \t\r\n") == s {
+		w.space = false
+	}
+}
+
+// breakLine ends the current line, unless it's already empty.
+func (w *textWriter) breakLine() {
+	if w.Len() > 0 && !strings.HasSuffix(w.String(), "\n") {
+		w.WriteString("\n")
+	}
+	w.space = false
+}
+
+// blankLine ends the current line and leaves an empty one after it.
+func (w *textWriter) blankLine() {
+	w.breakLine()
+	if w.Len() > 0 && !strings.HasSuffix(w.String(), "\n\n") {
+		w.WriteString("\n")
+	}
+}
+
+func (w *textWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Style, atom.Script, atom.Title:
+	case atom.Br:
+		w.WriteString("\n")
+		w.space = false
+	case atom.Hr:
+		w.breakLine()
+		w.WriteString("----\n")
+	case atom.Img:
+		if alt := attr(n, "alt"); alt != "" {
+			w.word("[" + alt + "]")
+		}
+	case atom.Tr:
+		w.breakLine()
+		w.row(n)
+		w.breakLine()
+	case atom.Table, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.blankLine()
+		w.children(n)
+		w.blankLine()
+	case atom.P, atom.Div, atom.Li, atom.Ul, atom.Ol, atom.Blockquote:
+		w.breakLine()
+		w.children(n)
+		w.breakLine()
+	case atom.A:
+		w.children(n)
+		if href := attr(n, "href"); href != "" && !strings.HasPrefix(href, "cid:") {
+			w.space = true
+			w.word("<" + href + ">")
+		}
+	default:
+		w.children(n)
+	}
+}
+
+func (w *textWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+// row writes the cells of a table row on one line, separated by " | ".
+// Empty cells at the end of the row are left out.
+func (w *textWriter) row(tr *html.Node) {
+	var cells []string
+	for c := tr.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom != atom.Td && c.DataAtom != atom.Th {
+			continue
+		}
+		var cell textWriter
+		cell.children(c)
+		cells = append(cells, strings.Join(strings.Fields(cell.String()), " "))
+	}
+	for len(cells) > 0 && cells[len(cells)-1] == "" {
+		cells = cells[:len(cells)-1]
+	}
+	w.word(strings.Join(cells, " | "))
+}
@@ -0,0 +1,31 @@
+package email_test
+
+import (
+	"stori-technical-challenge/pkg/email"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlainText(t *testing.T) {
+	document := `<html><head><style>p { color: red }</style></head><body>
+		<h2>Budgets</h2>
+		<p>Your   balance is <strong>$10.00</strong>.<br>See <a href="https://example.com">details</a>.</p>
+		<table>
+			<tr><th>Category</th><th>Spent</th><th></th></tr>
+			<tr><td>Groceries</td><td>$20.46</td><td></td></tr>
+		</table>
+		<img src="cid:logo" alt="Logo">
+	</body></html>`
+
+	text, err := email.PlainText(document)
+	require.NoError(t, err)
+	assert.Equal(t, "Budgets\n\nYour balance is $10.00.\nSee details <https://example.com>.\n\nCategory | Spent\nGroceries | $20.46\n\n[Logo]\n", text)
+}
+
+func TestPlainTextEmptyBody(t *testing.T) {
+	text, err := email.PlainText("<html><body> </body></html>")
+	require.NoError(t, err)
+	assert.Empty(t, text)
+}
@@ -0,0 +1,249 @@
+// Package export writes the computed summary and the stored transaction
+// ledger as JSON, CSV or XLSX, for loading into spreadsheets and finance
+// tools.
+//
+// The JSON documents carry a schema_version. Fields may be added without
+// changing it; renaming or removing one, or changing its meaning, bumps it.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"stori-technical-challenge/pkg/db"
+	"stori-technical-challenge/pkg/transactions"
+	"strconv"
+	"strings"
+)
+
+// SchemaVersion is the version of the JSON documents.
+const SchemaVersion = 1
+
+// Format is an export file format.
+type Format string
+
+const (
+	JSON Format = "json"
+	CSV  Format = "csv"
+	XLSX Format = "xlsx"
+)
+
+// Formats are the supported formats, in order of preference.
+var Formats = []Format{JSON, CSV, XLSX}
+
+// ParseFormat returns the format named s, such as "csv".
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if strings.EqualFold(s, string(f)) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown export format %q (want json, csv or xlsx)", s)
+}
+
+// ContentType is the MIME type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "application/json"
+}
+
+// Negotiate picks the format for an HTTP Accept header. An empty header, or
+// one that accepts anything, gets JSON. Quality values are honored.
+func Negotiate(accept string) (Format, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return JSON, true
+	}
+
+	type candidate struct {
+		format Format
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		for _, f := range Formats {
+			base, _, _ := mime.ParseMediaType(f.ContentType())
+			if mediaType == base || mediaType == "*/*" || (mediaType == "application/*" && f != CSV) || (mediaType == "text/*" && f == CSV) {
+				candidates = append(candidates, candidate{f, q})
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	// Stable, so equal quality values keep the client's order
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].format, true
+}
+
+// Dataset is something that can be exported.
+type Dataset interface {
+	// document is the JSON representation.
+	document() interface{}
+	// table is the tabular representation used by CSV and XLSX.
+	table() table
+}
+
+// Write writes d to w in format f.
+func Write(w io.Writer, f Format, d Dataset) error {
+	switch f {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d.document())
+	case CSV:
+		return writeCSV(w, d.table())
+	case XLSX:
+		return writeXLSX(w, d.table())
+	}
+	return fmt.Errorf("unknown export format %q", f)
+}
+
+// table is a sheet of cells, each a string, an int or a float64. A nil cell
+// is left empty.
+type table struct {
+	name    string
+	columns []string
+	rows    [][]interface{}
+}
+
+func writeCSV(w io.Writer, t table) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.columns); err != nil {
+		return err
+	}
+	record := make([]string, len(t.columns))
+	for _, row := range t.rows {
+		for i, cell := range row {
+			record[i] = csvCell(cell)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvCell formats a cell for CSV. Descriptions come from imported files,
+// so text that a spreadsheet would take for a formula, starting with =, +,
+// -, @, a tab or a carriage return, is prefixed with ' to be shown as is.
+func csvCell(cell interface{}) string {
+	if s, ok := cell.(string); ok && s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return formatCell(cell)
+}
+
+func formatCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return fmt.Sprint(cell)
+}
+
+// Summary is the summary of a transactions file.
+type Summary struct {
+	Source       string
+	TotalBalance float64
+	AvgDebit     float64
+	AvgCredit    float64
+	Months       map[string]transactions.Summary
+}
+
+type summaryDocument struct {
+	SchemaVersion int            `json:"schema_version"`
+	Source        string         `json:"source,omitempty"`
+	TotalBalance  float64        `json:"total_balance"`
+	AvgDebit      float64        `json:"avg_debit"`
+	AvgCredit     float64        `json:"avg_credit"`
+	Months        []monthSummary `json:"months"`
+}
+
+type monthSummary struct {
+	Month string `json:"month"`
+	transactions.Summary
+}
+
+// months returns the monthly summaries, oldest first.
+func (s Summary) months() []monthSummary {
+	months := make([]monthSummary, 0, len(s.Months))
+	for month, summary := range s.Months {
+		months = append(months, monthSummary{Month: month, Summary: summary})
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Month < months[j].Month })
+	return months
+}
+
+func (s Summary) document() interface{} {
+	return summaryDocument{
+		SchemaVersion: SchemaVersion,
+		Source:        s.Source,
+		TotalBalance:  s.TotalBalance,
+		AvgDebit:      s.AvgDebit,
+		AvgCredit:     s.AvgCredit,
+		Months:        s.months(),
+	}
+}
+
+// table has a row per month and a final "total" row, the only one with a
+// balance.
+func (s Summary) table() table {
+	t := table{name: "Summary", columns: []string{"month", "num_transactions", "avg_credit", "avg_debit", "total_balance"}}
+	total := 0
+	for _, m := range s.months() {
+		t.rows = append(t.rows, []interface{}{m.Month, m.NumTransactions, m.AvgCredit, m.AvgDebit, nil})
+		total += m.NumTransactions
+	}
+	t.rows = append(t.rows, []interface{}{"total", total, s.AvgCredit, s.AvgDebit, s.TotalBalance})
+	return t
+}
+
+// Ledger is a list of stored transactions.
+type Ledger []db.Transaction
+
+type ledgerDocument struct {
+	SchemaVersion int              `json:"schema_version"`
+	Transactions  []db.Transaction `json:"transactions"`
+}
+
+func (l Ledger) document() interface{} {
+	rows := []db.Transaction(l)
+	if rows == nil {
+		rows = []db.Transaction{}
+	}
+	return ledgerDocument{SchemaVersion: SchemaVersion, Transactions: rows}
+}
+
+func (l Ledger) table() table {
+	t := table{name: "Transactions", columns: []string{"id", "date", "amount", "description", "category", "account"}}
+	for _, tr := range l {
+		t.rows = append(t.rows, []interface{}{tr.ID, tr.Date, tr.Amount, tr.Description, tr.Category, tr.Account})
+	}
+	return t
+}
@@ -0,0 +1,151 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"stori-technical-challenge/pkg/db"
+	"stori-technical-challenge/pkg/export"
+	"stori-technical-challenge/pkg/transactions"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleSummary() export.Summary {
+	return export.Summary{
+		Source:       "txns.csv",
+		TotalBalance: 39.74,
+		AvgDebit:     -15.38,
+		AvgCredit:    35.25,
+		Months: map[string]transactions.Summary{
+			"2024-08": {NumTransactions: 2, AvgCredit: 10, AvgDebit: -20.46},
+			"2024-07": {NumTransactions: 2, AvgCredit: 60.5, AvgDebit: -10.3},
+		},
+	}
+}
+
+func write(t *testing.T, f export.Format, d export.Dataset) string {
+	var buf bytes.Buffer
+	require.NoError(t, export.Write(&buf, f, d))
+	return buf.String()
+}
+
+func TestSummaryJSON(t *testing.T) {
+	assert.JSONEq(t, `{
+		"schema_version": 1,
+		"source": "txns.csv",
+		"total_balance": 39.74,
+		"avg_debit": -15.38,
+		"avg_credit": 35.25,
+		"months": [
+			{"month": "2024-07", "num_transactions": 2, "avg_credit": 60.5, "avg_debit": -10.3},
+			{"month": "2024-08", "num_transactions": 2, "avg_credit": 10, "avg_debit": -20.46}
+		]
+	}`, write(t, export.JSON, sampleSummary()))
+}
+
+func TestSummaryCSV(t *testing.T) {
+	assert.Equal(t, "month,num_transactions,avg_credit,avg_debit,total_balance\n"+
+		"2024-07,2,60.5,-10.3,\n"+
+		"2024-08,2,10,-20.46,\n"+
+		"total,4,35.25,-15.38,39.74\n", write(t, export.CSV, sampleSummary()))
+}
+
+func TestLedgerJSONAndCSV(t *testing.T) {
+	ledger := export.Ledger{
+		{ID: 1, Date: "2024-07-15", Amount: 60.5, Category: "Income", Account: "default"},
+		{ID: 2, Date: "2024-07-28", Amount: -10.3, Description: "Netflix, Inc.", Category: "Subscriptions", Account: "acme"},
+	}
+	assert.JSONEq(t, `{"schema_version": 1, "transactions": [
+		{"id": 1, "date": "2024-07-15", "amount": 60.5, "description": "", "category": "Income", "account": "default"},
+		{"id": 2, "date": "2024-07-28", "amount": -10.3, "description": "Netflix, Inc.", "category": "Subscriptions", "account": "acme"}
+	]}`, write(t, export.JSON, ledger))
+	assert.Equal(t, "id,date,amount,description,category,account\n"+
+		"1,2024-07-15,60.5,,Income,default\n"+
+		"2,2024-07-28,-10.3,\"Netflix, Inc.\",Subscriptions,acme\n", write(t, export.CSV, ledger))
+
+	assert.JSONEq(t, `{"schema_version": 1, "transactions": []}`, write(t, export.JSON, export.Ledger(nil)), "an empty ledger is an empty list, not null")
+}
+
+func TestFormulasAreExportedAsText(t *testing.T) {
+	ledger := export.Ledger{
+		{ID: 1, Date: "2024-07-15", Amount: -10, Description: `=HYPERLINK("http://evil.example","x")`},
+		{ID: 2, Date: "2024-07-16", Amount: -10, Description: "+52 55 1234"},
+		{ID: 3, Date: "2024-07-17", Amount: -10, Description: "-2+3"},
+		{ID: 4, Date: "2024-07-18", Amount: -10, Description: "@SUM(A1)"},
+		{ID: 5, Date: "2024-07-19", Amount: -10, Description: "Netflix = fun"},
+	}
+	assert.Equal(t, "id,date,amount,description,category,account\n"+
+		"1,2024-07-15,-10,\"'=HYPERLINK(\"\"http://evil.example\"\",\"\"x\"\")\",,\n"+
+		"2,2024-07-16,-10,'+52 55 1234,,\n"+
+		"3,2024-07-17,-10,'-2+3,,\n"+
+		"4,2024-07-18,-10,'@SUM(A1),,\n"+
+		"5,2024-07-19,-10,Netflix = fun,,\n", write(t, export.CSV, ledger), "negative amounts are numbers and stay as they are")
+
+	sheet := unzip(t, write(t, export.XLSX, ledger[:1]))["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `<c r="D2" t="inlineStr"><is><t>=HYPERLINK(&#34;http://evil.example&#34;,&#34;x&#34;)</t></is></c>`)
+	assert.NotContains(t, sheet, "<f>")
+}
+
+func TestXLSX(t *testing.T) {
+	out := write(t, export.XLSX, export.Ledger([]db.Transaction{{ID: 7, Date: "2024-07-15", Amount: 60.5}}))
+	assert.Equal(t, out, write(t, export.XLSX, export.Ledger([]db.Transaction{{ID: 7, Date: "2024-07-15", Amount: 60.5}})), "exports are reproducible")
+
+	files := unzip(t, out)
+	require.Contains(t, files, "[Content_Types].xml")
+	assert.Contains(t, files["xl/workbook.xml"], `<sheet name="Transactions"`)
+	sheet := files["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `<c r="A1" t="inlineStr" s="1"><is><t>id</t></is></c>`)
+	assert.Contains(t, sheet, `<c r="A2"><v>7</v></c>`)
+	assert.Contains(t, sheet, `<c r="B2" t="inlineStr"><is><t>2024-07-15</t></is></c>`)
+	assert.Contains(t, sheet, `<c r="C2"><v>60.5</v></c>`)
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := export.ParseFormat("XLSX")
+	require.NoError(t, err)
+	assert.Equal(t, export.XLSX, f)
+
+	_, err = export.ParseFormat("pdf")
+	assert.Error(t, err)
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   export.Format
+		ok     bool
+	}{
+		{"", export.JSON, true},
+		{"*/*", export.JSON, true},
+		{"text/csv", export.CSV, true},
+		{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", export.XLSX, true},
+		{"application/json;q=0.5, text/csv", export.CSV, true},
+		{"text/html, text/*;q=0.2", export.CSV, true},
+		{"text/html", "", false},
+		{"text/csv;q=0", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := export.Negotiate(tt.accept)
+		assert.Equal(t, tt.ok, ok, tt.accept)
+		assert.Equal(t, tt.want, got, tt.accept)
+	}
+}
+
+// unzip returns the files of an XLSX document by name.
+func unzip(t *testing.T, out string) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader([]byte(out)), int64(len(out)))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		body, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		files[f.Name] = string(body)
+	}
+	return files
+}
@@ -0,0 +1,50 @@
+// Package format renders amounts, percentages and dates the same way in the
+// email templates and the PDF statement.
+package format
+
+import (
+	"fmt"
+	"math"
+	"stori-technical-challenge/pkg/transactions"
+	"strings"
+	"time"
+)
+
+// Currency formats an amount of money with thousands separators, e.g.
+// "-$1,234.50".
+func Currency(amount float64) string {
+	sign := ""
+	cents := int64(math.Round(math.Abs(amount) * 100))
+	if amount < 0 && cents > 0 {
+		sign = "-"
+	}
+	whole := fmt.Sprint(cents / 100)
+	var groups []string
+	for len(whole) > 3 {
+		groups = append([]string{whole[len(whole)-3:]}, groups...)
+		whole = whole[:len(whole)-3]
+	}
+	groups = append([]string{whole}, groups...)
+	return fmt.Sprintf("%s$%s.%02d", sign, strings.Join(groups, ","), cents%100)
+}
+
+// Percent formats a ratio as a percentage with one decimal, e.g. 0.125 as
+// "12.5%".
+func Percent(ratio float64) string {
+	return fmt.Sprintf("%.1f%%", ratio*100)
+}
+
+// Date formats a day, e.g. "Jul 15, 2024".
+func Date(t time.Time) string {
+	return t.Format("Jan 2, 2006")
+}
+
+// Month formats a transactions.MonthLayout key, e.g. "2024-07" as
+// "July 2024". Keys that don't parse are returned as they are.
+func Month(key string) string {
+	month, err := time.Parse(transactions.MonthLayout, key)
+	if err != nil {
+		return key
+	}
+	return month.Format("January 2006")
+}
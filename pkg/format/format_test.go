@@ -0,0 +1,28 @@
+package format_test
+
+import (
+	"stori-technical-challenge/pkg/format"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrency(t *testing.T) {
+	assert.Equal(t, "$0.00", format.Currency(0))
+	assert.Equal(t, "$0.00", format.Currency(-0.001), "no negative zero")
+	assert.Equal(t, "$60.50", format.Currency(60.5))
+	assert.Equal(t, "-$10.30", format.Currency(-10.3))
+	assert.Equal(t, "$1,234,567.89", format.Currency(1234567.891))
+}
+
+func TestPercent(t *testing.T) {
+	assert.Equal(t, "12.5%", format.Percent(0.125))
+	assert.Equal(t, "-3.0%", format.Percent(-0.03))
+}
+
+func TestDates(t *testing.T) {
+	assert.Equal(t, "Jul 15, 2024", format.Date(time.Date(2024, 7, 15, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "July 2024", format.Month("2024-07"))
+	assert.Equal(t, "someday", format.Month("someday"))
+}
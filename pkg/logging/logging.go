@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// CorrelationIDKey is the attribute that ties together every log line of a
+// run (CLI) or of one file (Lambda).
+const CorrelationIDKey = "correlation_id"
+
+// New returns a logger writing to w. format is "json" (the default) or
+// "text"; level is one of debug, info, warn or error (default info).
+func New(w io.Writer, format, level string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: parseLevel(level)}
+	if strings.EqualFold(format, "text") {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// FromEnv builds a logger on stderr configured by LOG_FORMAT and LOG_LEVEL.
+func FromEnv() *slog.Logger {
+	return New(os.Stderr, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+}
+
+func parseLevel(level string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// NewCorrelationID returns a random identifier for a run.
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithCorrelationID returns a logger that tags every record with id.
+func WithCorrelationID(logger *slog.Logger, id string) *slog.Logger {
+	return logger.With(CorrelationIDKey, id)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or slog.Default().
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// OrDefault returns logger, or slog.Default() when it is nil.
+func OrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// MaskEmail hides the local part of an address but its first letter, so
+// logs can tell deliveries apart without recording who received them.
+func MaskEmail(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at <= 0 {
+		return "***"
+	}
+	return address[:1] + "***" + address[at:]
+}
+
+// MaskEmails applies MaskEmail to every address.
+func MaskEmails(addresses []string) []string {
+	masked := make([]string, len(addresses))
+	for i, address := range addresses {
+		masked[i] = MaskEmail(address)
+	}
+	return masked
+}
+
+// Since returns the elapsed time since start in milliseconds, the unit used
+// for every duration attribute.
+func Since(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
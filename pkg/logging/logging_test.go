@@ -0,0 +1,16 @@
+package logging_test
+
+import (
+	"stori-technical-challenge/pkg/logging"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskEmail(t *testing.T) {
+	assert.Equal(t, "u***@example.com", logging.MaskEmail("user@example.com"))
+	assert.Equal(t, "a***@b.c", logging.MaskEmail("a@b.c"))
+	assert.Equal(t, "***", logging.MaskEmail("not an address"))
+	assert.Equal(t, "***", logging.MaskEmail("@example.com"))
+	assert.Equal(t, []string{"c***@acme.com", "f***@acme.com"}, logging.MaskEmails([]string{"cfo@acme.com", "finance@acme.com"}))
+}
@@ -0,0 +1,116 @@
+// Package metrics holds the Prometheus instrumentation shared by the CLI,
+// the server mode and the Lambda handler.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "stori"
+
+// Registry holds every metric of the application. It is exposed by Handler
+// in server mode and written by WriteTextfile in one-shot mode.
+var Registry = prometheus.NewRegistry()
+
+var (
+	RowsRead = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_read_total",
+		Help:      "Transaction rows read from input files, excluding headers.",
+	})
+	RowsAccepted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_accepted_total",
+		Help:      "Transaction rows that were parsed and summarized.",
+	})
+	RowsRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_rejected_total",
+		Help:      "Transaction rows skipped because they could not be parsed.",
+	})
+	ProcessingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "processing_duration_seconds",
+		Help:      "Time spent reading and summarizing a transactions file.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+	DBInsertDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_insert_duration_seconds",
+		Help:      "Latency of a single transaction insert.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+	})
+	EmailsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_sent_total",
+		Help:      "Summary emails handed to the delivery backend.",
+	}, []string{"backend"})
+	EmailsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_failed_total",
+		Help:      "Summary emails the delivery backend failed to send.",
+	}, []string{"backend"})
+	LastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last run that delivered a summary email.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		RowsRead,
+		RowsAccepted,
+		RowsRejected,
+		ProcessingDuration,
+		DBInsertDuration,
+		EmailsSent,
+		EmailsFailed,
+		LastSuccess,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ObserveEmail counts a delivery attempt on backend as sent or failed.
+func ObserveEmail(backend string, err error) {
+	if err != nil {
+		EmailsFailed.WithLabelValues(backend).Inc()
+		return
+	}
+	EmailsSent.WithLabelValues(backend).Inc()
+}
+
+// RecordSuccess marks the end of a run that delivered its summary. Alert on
+// time() - stori_last_success_timestamp_seconds to catch runs that silently
+// send nothing.
+func RecordSuccess() {
+	LastSuccess.SetToCurrentTime()
+}
+
+// Since returns the elapsed time since start in seconds, the unit used by
+// every histogram.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Handler serves Registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// WriteTextfile writes Registry to path for the node_exporter textfile
+// collector. The file is replaced atomically so a scrape never sees a
+// partial write.
+func WriteTextfile(path string) error {
+	if path == "" {
+		return errors.New("metrics textfile path is empty")
+	}
+	return prometheus.WriteToTextfile(path, Registry)
+}
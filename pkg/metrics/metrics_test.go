@@ -0,0 +1,58 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"stori-technical-challenge/pkg/metrics"
+	"stori-technical-challenge/pkg/transactions"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessorCountsRows(t *testing.T) {
+	read := testutil.ToFloat64(metrics.RowsRead)
+	accepted := testutil.ToFloat64(metrics.RowsAccepted)
+	rejected := testutil.ToFloat64(metrics.RowsRejected)
+
+	processor := transactions.NewProcessor(transactions.DefaultCSVReader{}, transactions.WithYear(2024))
+	_, err := processor.Process(context.Background(), filepath.Join("..", "..", "testdata", "fixtures", "invalid_rows.csv"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 4.0, testutil.ToFloat64(metrics.RowsRejected)-rejected)
+	assert.Equal(t, testutil.ToFloat64(metrics.RowsRead)-read, testutil.ToFloat64(metrics.RowsAccepted)-accepted+4)
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.ProcessingDuration))
+}
+
+func TestObserveEmailByBackend(t *testing.T) {
+	sent := testutil.ToFloat64(metrics.EmailsSent.WithLabelValues("test"))
+	failed := testutil.ToFloat64(metrics.EmailsFailed.WithLabelValues("test"))
+
+	metrics.ObserveEmail("test", nil)
+	metrics.ObserveEmail("test", nil)
+	metrics.ObserveEmail("test", errors.New("connection refused"))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.EmailsSent.WithLabelValues("test"))-sent)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.EmailsFailed.WithLabelValues("test"))-failed)
+}
+
+func TestWriteTextfile(t *testing.T) {
+	metrics.RecordSuccess()
+	path := filepath.Join(t.TempDir(), "stori.prom")
+	require.NoError(t, metrics.WriteTextfile(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	for _, name := range []string{
+		"stori_rows_read_total",
+		"stori_last_success_timestamp_seconds",
+		"stori_db_insert_duration_seconds",
+	} {
+		assert.True(t, strings.Contains(string(data), name), "missing %s", name)
+	}
+}
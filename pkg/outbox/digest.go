@@ -0,0 +1,56 @@
+package outbox
+
+import (
+	"fmt"
+	"path"
+	"stori-technical-challenge/pkg/db"
+	"strings"
+)
+
+// mergeDigest joins the parts of a recipient's digest into one message
+// addressed and laid out like the newest part, with the summaries one after
+// the other, oldest first. Each part's files are renamed so parts can't
+// clash: statement.pdf becomes statement-1.pdf, statement-2.pdf, and so on.
+func mergeDigest(parts []db.OutboxMessage) db.OutboxMessage {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	newest := parts[len(parts)-1]
+	merged := newest
+	merged.Subject = fmt.Sprintf("%s (%d)", newest.Subject, len(parts))
+	merged.Attachments = nil
+
+	var contents []string
+	for i, part := range parts {
+		suffix := fmt.Sprintf("-%d", i+1)
+		body := part.Body
+		for _, a := range part.Attachments {
+			if a.ContentID != "" {
+				body = strings.ReplaceAll(body, "cid:"+a.ContentID+`"`, "cid:"+a.ContentID+suffix+`"`)
+				a.ContentID += suffix
+			}
+			ext := path.Ext(a.Filename)
+			a.Filename = strings.TrimSuffix(a.Filename, ext) + suffix + ext
+			merged.Attachments = append(merged.Attachments, a)
+		}
+		_, content, _ := splitBody(body)
+		contents = append(contents, content)
+	}
+
+	before, _, after := splitBody(newest.Body)
+	merged.Body = before + strings.Join(contents, "\n<hr>\n") + after
+	return merged
+}
+
+// splitBody splits an HTML document around the contents of its <body>. A
+// fragment without one is all content.
+func splitBody(document string) (before, content, after string) {
+	lower := strings.ToLower(document)
+	open := strings.Index(lower, "<body")
+	end := strings.LastIndex(lower, "</body>")
+	if open < 0 || end < open {
+		return "", document, ""
+	}
+	start := open + strings.Index(lower[open:], ">") + 1
+	return document[:start], document[start:end], document[end:]
+}
@@ -0,0 +1,239 @@
+// Package outbox delivers the emails and webhook calls queued in the
+// database outbox, retrying failed sends with exponential backoff.
+package outbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"stori-technical-challenge/pkg/anomaly"
+	"stori-technical-challenge/pkg/db"
+	"stori-technical-challenge/pkg/email"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/metrics"
+	"stori-technical-challenge/pkg/tracing"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// dispatchMu serializes RunOnce across every Dispatcher in the process, so
+// a message can't be picked up twice while it's being sent.
+var dispatchMu sync.Mutex
+
+// Dispatcher sends due outbox messages through Sender. Dispatchers in one
+// process take turns; run a single process per database, since messages
+// aren't locked in the database while they're being sent.
+type Dispatcher struct {
+	Sender email.EmailSender
+	// HTTPClient posts the webhook messages. Defaults to a client with a 10
+	// second timeout.
+	HTTPClient *http.Client
+	// MaxAttempts is the number of sends tried before a message is marked
+	// failed. Defaults to 5.
+	MaxAttempts int
+	// BaseDelay is the wait after the first failure; it doubles after each
+	// further failure up to MaxDelay. They default to 1m and 1h.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// BatchSize limits how many messages one RunOnce sends. Defaults to 100.
+	BatchSize int
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Result counts what happened to the messages handled by RunOnce.
+type Result struct {
+	Sent    int
+	Retried int
+	Failed  int
+	// Unsent maps the DedupKey of each message retried or failed, every
+	// part of a digest included, to its new status, so a caller can tell
+	// its own messages from those left by earlier runs.
+	Unsent map[string]db.OutboxStatus
+}
+
+// RunOnce sends every message that is due. Send failures are recorded on
+// the message rather than returned; the error is only for database problems.
+func (d *Dispatcher) RunOnce(ctx context.Context) (result Result, err error) {
+	dispatchMu.Lock()
+	defer dispatchMu.Unlock()
+
+	ctx, span := tracing.Start(ctx, "outbox.Dispatch")
+	defer func() {
+		span.SetAttributes(attribute.Int("sent", result.Sent), attribute.Int("retried", result.Retried), attribute.Int("failed", result.Failed))
+		tracing.End(span, err)
+	}()
+
+	logger := logging.OrDefault(d.Logger)
+	messages, err := db.DueEmails(ctx, d.now(), d.batchSize())
+	if err != nil {
+		return result, err
+	}
+
+	digested := make(map[int64]bool)
+	for _, msg := range messages {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		// A digest goes out whole as soon as any of its parts is due
+		parts := []db.OutboxMessage{msg}
+		if msg.RecipientID != 0 {
+			if digested[msg.RecipientID] {
+				continue
+			}
+			digested[msg.RecipientID] = true
+			if parts, err = db.DigestParts(ctx, msg.RecipientID); err != nil {
+				return result, err
+			}
+			msg = mergeDigest(parts)
+		}
+
+		msgLogger := logger.With("outbox_id", msg.ID, "dedup_key", msg.DedupKey, "to", logging.MaskEmails(msg.To), "attempt", msg.Attempts+1)
+		if msg.RecipientID != 0 {
+			msgLogger = msgLogger.With("digest_parts", len(parts))
+		}
+		if msg.WebhookURL != "" {
+			msgLogger = msgLogger.With("webhook", true)
+		}
+		sendErr := d.send(ctx, msg)
+		if sendErr == nil {
+			if err := d.markSent(ctx, msg, parts); err != nil {
+				return result, err
+			}
+			if msg.WebhookURL == "" {
+				metrics.RecordSuccess()
+			}
+			msgLogger.Info("outbox message sent", "outcome", "success")
+			result.Sent++
+			continue
+		}
+
+		attempts := msg.Attempts + 1
+		giveUp := attempts >= d.maxAttempts()
+		next := d.now().Add(d.backoff(attempts))
+		status := db.OutboxPending
+		if giveUp {
+			status = db.OutboxFailed
+		}
+		if result.Unsent == nil {
+			result.Unsent = make(map[string]db.OutboxStatus)
+		}
+		for _, part := range parts {
+			if err := db.MarkEmailFailed(ctx, part.ID, sendErr, next, giveUp); err != nil {
+				return result, err
+			}
+			result.Unsent[part.DedupKey] = status
+		}
+		if giveUp {
+			msgLogger.Error("outbox message failed", "outcome", "failure", "error", sendErr)
+			result.Failed++
+		} else {
+			msgLogger.Warn("outbox message will be retried", "outcome", "retry", "error", sendErr, "next_attempt_at", next)
+			result.Retried++
+		}
+	}
+	return result, nil
+}
+
+// send emails msg, or posts it when it's a webhook message.
+func (d *Dispatcher) send(ctx context.Context, msg db.OutboxMessage) error {
+	if msg.WebhookURL != "" {
+		return anomaly.Webhook{URL: msg.WebhookURL, Client: d.HTTPClient}.PostJSON(ctx, []byte(msg.Body))
+	}
+	return d.Sender.SendEmail(ctx, toEmail(msg))
+}
+
+// markSent records the delivery of msg, which for a digest covers each of
+// its parts and notifies its recipient.
+func (d *Dispatcher) markSent(ctx context.Context, msg db.OutboxMessage, parts []db.OutboxMessage) error {
+	if msg.RecipientID == 0 {
+		return db.MarkEmailSent(ctx, msg.ID, d.now())
+	}
+	ids := make([]int64, len(parts))
+	for i, part := range parts {
+		ids[i] = part.ID
+	}
+	return db.MarkDigestSent(ctx, msg.RecipientID, ids, d.now())
+}
+
+func toEmail(msg db.OutboxMessage) email.Message {
+	m := email.Message{To: msg.To, Cc: msg.Cc, Bcc: msg.Bcc, Subject: msg.Subject, Body: msg.Body}
+	for _, a := range msg.Attachments {
+		m.Attachments = append(m.Attachments, email.Attachment{Filename: a.Filename, ContentType: a.ContentType, ContentID: a.ContentID, Data: a.Data})
+	}
+	return m
+}
+
+// Run calls RunOnce every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.RunOnce(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			logging.OrDefault(d.Logger).Error("outbox dispatch failed", "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// backoff returns the delay before the attempt following the given number
+// of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	base, limit := d.BaseDelay, d.MaxDelay
+	if base <= 0 {
+		base = time.Minute
+	}
+	if limit <= 0 {
+		limit = time.Hour
+	}
+	delay := base
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+func (d *Dispatcher) maxAttempts() int {
+	if d.MaxAttempts <= 0 {
+		return 5
+	}
+	return d.MaxAttempts
+}
+
+func (d *Dispatcher) batchSize() int {
+	if d.BatchSize <= 0 {
+		return 100
+	}
+	return d.BatchSize
+}
+
+func (d *Dispatcher) now() time.Time {
+	if d.Now == nil {
+		return time.Now()
+	}
+	return d.Now()
+}
+
+// StatementKey is the dedup key for notifying recipient, an address or an
+// account, about a statement with the given contents. Importing the same
+// file again for the same recipient yields the same key, so it is only ever
+// notified once.
+func StatementKey(statement []byte, recipient string) string {
+	sum := sha256.New()
+	sum.Write(statement)
+	sum.Write([]byte{0})
+	sum.Write([]byte(recipient))
+	return "statement:" + hex.EncodeToString(sum.Sum(nil))
+}
@@ -0,0 +1,214 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"stori-technical-challenge/pkg/db"
+	"stori-technical-challenge/pkg/email"
+	"stori-technical-challenge/pkg/outbox"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakySender fails its first `failures` sends and records the rest.
+type flakySender struct {
+	failures int
+	calls    int
+	sent     []string
+	messages []email.Message
+}
+
+func (s *flakySender) SendEmail(ctx context.Context, msg email.Message) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return errors.New("421 try again later")
+	}
+	s.sent = append(s.sent, msg.Recipients()...)
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func setupOutbox(t *testing.T) {
+	require.NoError(t, db.InitDB())
+	_, err := db.DB.Exec("DELETE FROM outbox")
+	require.NoError(t, err)
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	setupOutbox(t)
+	ctx := context.Background()
+	_, err := db.EnqueueEmail(ctx, db.OutboxMessage{DedupKey: "k1", To: []string{"user@example.com"}, Subject: "s", Body: "b"})
+	require.NoError(t, err)
+
+	now := time.Now()
+	sender := &flakySender{failures: 2}
+	d := &outbox.Dispatcher{Sender: sender, BaseDelay: time.Minute, MaxDelay: time.Hour, Now: func() time.Time { return now }}
+
+	result, err := d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{Retried: 1, Unsent: map[string]db.OutboxStatus{"k1": db.OutboxPending}}, result)
+	msg, err := db.GetOutboxMessage(ctx, "k1")
+	require.NoError(t, err)
+	assert.WithinDuration(t, now.Add(time.Minute), msg.NextAttemptAt, time.Millisecond)
+
+	// Not due yet
+	result, err = d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{}, result)
+
+	now = now.Add(time.Minute)
+	result, err = d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{Retried: 1, Unsent: map[string]db.OutboxStatus{"k1": db.OutboxPending}}, result)
+	msg, err = db.GetOutboxMessage(ctx, "k1")
+	require.NoError(t, err)
+	assert.WithinDuration(t, now.Add(2*time.Minute), msg.NextAttemptAt, time.Millisecond, "the delay doubles")
+
+	now = now.Add(2 * time.Minute)
+	result, err = d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{Sent: 1}, result)
+
+	msg, err = db.GetOutboxMessage(ctx, "k1")
+	require.NoError(t, err)
+	assert.Equal(t, db.OutboxSent, msg.Status)
+	assert.Equal(t, 3, msg.Attempts)
+	assert.Equal(t, []string{"user@example.com"}, sender.sent)
+
+	// Sent messages are never sent again
+	now = now.Add(24 * time.Hour)
+	result, err = d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{}, result)
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	setupOutbox(t)
+	ctx := context.Background()
+	_, err := db.EnqueueEmail(ctx, db.OutboxMessage{DedupKey: "k2", To: []string{"user@example.com"}})
+	require.NoError(t, err)
+
+	now := time.Now()
+	d := &outbox.Dispatcher{Sender: &flakySender{failures: 10}, MaxAttempts: 2, Now: func() time.Time { return now }}
+
+	result, err := d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{Retried: 1, Unsent: map[string]db.OutboxStatus{"k2": db.OutboxPending}}, result)
+
+	now = now.Add(time.Hour)
+	result, err = d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{Failed: 1, Unsent: map[string]db.OutboxStatus{"k2": db.OutboxFailed}}, result)
+
+	failed, err := db.GetOutboxMessages(ctx, db.OutboxFailed)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, "421 try again later", failed[0].LastError)
+	assert.Equal(t, 2, failed[0].Attempts)
+}
+
+func TestDispatcherRetriesWebhooks(t *testing.T) {
+	setupOutbox(t)
+	ctx := context.Background()
+	calls := 0
+	var posted string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		posted = string(body)
+	}))
+	defer server.Close()
+
+	enqueued, err := db.EnqueueEmail(ctx, db.OutboxMessage{DedupKey: "hook", Body: `{"schema_version":1}`, WebhookURL: server.URL})
+	require.NoError(t, err)
+	require.True(t, enqueued)
+	msg, err := db.GetOutboxMessage(ctx, "hook")
+	require.NoError(t, err)
+	assert.Equal(t, db.OutboxPending, msg.Status, "a webhook needs no email recipients")
+
+	now := time.Now()
+	sender := &flakySender{}
+	d := &outbox.Dispatcher{Sender: sender, Now: func() time.Time { return now }}
+	result, err := d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{Retried: 1, Unsent: map[string]db.OutboxStatus{"hook": db.OutboxPending}}, result)
+	msg, err = db.GetOutboxMessage(ctx, "hook")
+	require.NoError(t, err)
+	assert.Contains(t, msg.LastError, "503")
+
+	now = now.Add(time.Minute)
+	result, err = d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{Sent: 1}, result)
+	assert.Equal(t, `{"schema_version":1}`, posted)
+	assert.Zero(t, sender.calls, "webhooks aren't emailed")
+}
+
+func TestDispatcherSendsDigestsWhole(t *testing.T) {
+	setupOutbox(t)
+	ctx := context.Background()
+	account := t.Name() + time.Now().Format(time.RFC3339Nano)
+	require.NoError(t, db.SaveRecipient(ctx, db.Recipient{Account: account, Email: "cfo@example.com", Frequency: db.Weekly}))
+	recipients, err := db.GetRecipients(ctx, account)
+	require.NoError(t, err)
+	id := recipients[0].ID
+
+	now := time.Now()
+	due := now.Add(48 * time.Hour)
+	for i, day := range []string{"Monday", "Tuesday"} {
+		_, err := db.EnqueueEmail(ctx, db.OutboxMessage{
+			DedupKey:      "digest-" + day,
+			To:            []string{"cfo@example.com"},
+			Subject:       "Summary",
+			Body:          `<html><body style="margin:0"><p>` + day + `</p><img src="cid:chart"></body></html>`,
+			Attachments:   []db.OutboxAttachment{{Filename: "chart.png", ContentType: "image/png", ContentID: "chart", Data: []byte{byte(i)}}},
+			RecipientID:   id,
+			NextAttemptAt: due,
+		})
+		require.NoError(t, err)
+	}
+
+	sender := &flakySender{}
+	d := &outbox.Dispatcher{Sender: sender, Now: func() time.Time { return now }}
+	result, err := d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{}, result, "held until the recipient is due")
+
+	now = due
+	result, err = d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.Result{Sent: 1}, result)
+	require.Len(t, sender.messages, 1)
+	msg := sender.messages[0]
+	assert.Equal(t, "Summary (2)", msg.Subject)
+	assert.Equal(t, `<html><body style="margin:0"><p>Monday</p><img src="cid:chart-1">`+"\n<hr>\n"+`<p>Tuesday</p><img src="cid:chart-2"></body></html>`, msg.Body)
+	require.Len(t, msg.Attachments, 2)
+	assert.Equal(t, "chart-1.png", msg.Attachments[0].Filename)
+	assert.Equal(t, "chart-2", msg.Attachments[1].ContentID)
+
+	for _, key := range []string{"digest-Monday", "digest-Tuesday"} {
+		part, err := db.GetOutboxMessage(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, db.OutboxSent, part.Status)
+	}
+	recipients, err = db.GetRecipients(ctx, account)
+	require.NoError(t, err)
+	require.NotNil(t, recipients[0].LastNotifiedAt)
+	assert.WithinDuration(t, due, *recipients[0].LastNotifiedAt, time.Millisecond)
+}
+
+func TestStatementKey(t *testing.T) {
+	assert.Equal(t, outbox.StatementKey([]byte("a,b"), "x@example.com"), outbox.StatementKey([]byte("a,b"), "x@example.com"))
+	assert.NotEqual(t, outbox.StatementKey([]byte("a,b"), "x@example.com"), outbox.StatementKey([]byte("a,b"), "y@example.com"))
+	assert.NotEqual(t, outbox.StatementKey([]byte("a,b"), "x@example.com"), outbox.StatementKey([]byte("a,c"), "x@example.com"))
+}
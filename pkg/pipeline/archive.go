@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"stori-technical-challenge/pkg/statement"
+	"stori-technical-challenge/pkg/transactions"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ObjectWriter stores an object. storage.S3Client and storage.FSClient
+// satisfy it.
+type ObjectWriter interface {
+	PutObject(ctx context.Context, bucket, key string, body []byte, contentType string) error
+}
+
+// Archive persists what a run produced so it can be looked up later.
+type Archive struct {
+	Writer ObjectWriter
+	Bucket string
+	Prefix string
+	// Now defaults to time.Now; tests override it.
+	Now func() time.Time
+}
+
+// SummaryDocument is the JSON written as summary.json. Field names are part
+// of the output contract; add fields, don't rename them.
+type SummaryDocument struct {
+	Source       string          `json:"source"`
+	Recipient    string          `json:"recipient"`
+	Subject      string          `json:"subject"`
+	GeneratedAt  time.Time       `json:"generated_at"`
+	TotalBalance float64         `json:"total_balance"`
+	AvgDebit     float64         `json:"avg_debit"`
+	AvgCredit    float64         `json:"avg_credit"`
+	Months       []MonthDocument `json:"months"`
+	RejectedRows int             `json:"rejected_rows"`
+}
+
+type MonthDocument struct {
+	Month string `json:"month"`
+	transactions.Summary
+}
+
+// Save writes summary.json, email.html, statement.pdf and rejected.csv for
+// report under <prefix>/<date>/<source>/<time>/ and returns the directory
+// used.
+func (a *Archive) Save(ctx context.Context, report *Report, recipient string) (string, error) {
+	now := time.Now
+	if a.Now != nil {
+		now = a.Now
+	}
+	generatedAt := now().UTC()
+
+	dir := path.Join(a.Prefix, generatedAt.Format("2006-01-02"), strings.TrimSuffix(report.Source, path.Ext(report.Source)), generatedAt.Format("150405.000"))
+
+	summary, err := json.MarshalIndent(newSummaryDocument(report, recipient, generatedAt), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("encoding summary: %w", err)
+	}
+	rejected, err := encodeRejected(report)
+	if err != nil {
+		return "", fmt.Errorf("encoding rejected rows: %w", err)
+	}
+
+	objects := []struct {
+		name, contentType string
+		body              []byte
+	}{
+		{"summary.json", "application/json", summary},
+		{"email.html", "text/html; charset=utf-8", []byte(report.Body)},
+		{statement.Filename, statement.ContentType, report.Statement},
+		{"rejected.csv", "text/csv", rejected},
+	}
+	for _, obj := range objects {
+		key := path.Join(dir, obj.name)
+		if err := a.Writer.PutObject(ctx, a.Bucket, key, obj.body, obj.contentType); err != nil {
+			return "", fmt.Errorf("writing s3://%s/%s: %w", a.Bucket, key, err)
+		}
+	}
+	return dir, nil
+}
+
+func newSummaryDocument(report *Report, recipient string, generatedAt time.Time) SummaryDocument {
+	doc := SummaryDocument{
+		Source:       report.Source,
+		Recipient:    recipient,
+		Subject:      report.Subject(),
+		GeneratedAt:  generatedAt,
+		TotalBalance: report.TotalBalance,
+		AvgDebit:     report.AvgDebit,
+		AvgCredit:    report.AvgCredit,
+		Months:       make([]MonthDocument, 0, len(report.Summary)),
+		RejectedRows: len(report.Rejected),
+	}
+	for month, summary := range report.Summary {
+		doc.Months = append(doc.Months, MonthDocument{Month: month, Summary: summary})
+	}
+	sort.Slice(doc.Months, func(i, j int) bool { return doc.Months[i].Month < doc.Months[j].Month })
+	return doc
+}
+
+// encodeRejected writes the rejected rows with their original columns,
+// prefixed by the line number and the reason they were rejected.
+func encodeRejected(report *Report) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(append([]string{"Line", "Reason"}, report.Header...)); err != nil {
+		return nil, err
+	}
+	for _, row := range report.Rejected {
+		if err := w.Write(append([]string{strconv.Itoa(row.Line), row.Reason}, row.Record...)); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
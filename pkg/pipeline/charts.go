@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"stori-technical-challenge/pkg/chart"
+	"stori-technical-challenge/pkg/email"
+	"stori-technical-challenge/pkg/statement"
+	"stori-technical-challenge/pkg/transactions"
+	"time"
+)
+
+// Content IDs of the charts embedded in the summary email.
+const (
+	CreditsDebitsChartID = "chart-credits-debits"
+	BalanceChartID       = "chart-balance"
+)
+
+// summaryCharts draws the monthly credits vs debits bars and the running
+// balance line for data. It returns the images to embed and how the body
+// refers to them; both are empty when there are no transactions.
+func summaryCharts(data statement.Data) ([]email.Attachment, []email.ChartImage, error) {
+	if len(data.Transactions) == 0 {
+		return nil, nil, nil
+	}
+
+	var bars []chart.Bar
+	for _, m := range data.Months() {
+		label := m.Month
+		if month, err := time.Parse(transactions.MonthLayout, m.Month); err == nil {
+			label = month.Format("Jan 2006")
+		}
+		bars = append(bars, chart.Bar{Label: label, Credits: m.Credits, Debits: m.Debits})
+	}
+	creditsDebits, err := chart.CreditsDebits("Credits vs debits by month", bars)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var points []chart.Point
+	for _, b := range data.DailyBalances() {
+		points = append(points, chart.Point{Time: b.Date, Value: b.Balance})
+	}
+	balance, err := chart.Balance("Balance", points)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attachments := []email.Attachment{
+		{Filename: CreditsDebitsChartID + ".png", ContentType: "image/png", ContentID: CreditsDebitsChartID, Data: creditsDebits},
+		{Filename: BalanceChartID + ".png", ContentType: "image/png", ContentID: BalanceChartID, Data: balance},
+	}
+	images := []email.ChartImage{
+		{ContentID: CreditsDebitsChartID, Alt: "Monthly credits and debits"},
+		{ContentID: BalanceChartID, Alt: "Running balance"},
+	}
+	return attachments, images, nil
+}
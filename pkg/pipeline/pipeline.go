@@ -0,0 +1,323 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"stori-technical-challenge/pkg/anomaly"
+	"stori-technical-challenge/pkg/budget"
+	"stori-technical-challenge/pkg/category"
+	"stori-technical-challenge/pkg/email"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/recurring"
+	"stori-technical-challenge/pkg/statement"
+	"stori-technical-challenge/pkg/transactions"
+	"stori-technical-challenge/pkg/trend"
+	"time"
+)
+
+const Subject = "Stori - Transaction Summary"
+
+// AttentionSubject replaces Subject when a budget was overspent or the
+// balance fell below its threshold.
+const AttentionSubject = Subject + " - Action needed"
+
+// AlertSubject is the subject of the unusual activity email.
+const AlertSubject = "Stori - Unusual activity"
+
+// Pipeline turns a transactions file into a summary email. The CLI and the
+// Lambda handler both run through it so their output can't drift apart.
+type Pipeline struct {
+	// Templates renders the email body. Defaults to email.DefaultTemplates.
+	Templates        *email.Registry
+	Sender           email.EmailSender
+	ProcessorOptions []transactions.Option
+	// Logger carries the run's correlation ID. Defaults to slog.Default().
+	Logger *slog.Logger
+	// Account is printed on the PDF statement when set.
+	Account string
+	// Now dates the PDF statement. Defaults to time.Now; tests override it.
+	Now func() time.Time
+	// Categorizer assigns the categories of the spending breakdown.
+	// Defaults to category.Default().
+	Categorizer transactions.Categorizer
+	// History returns the stored transactions dated on or after since. They
+	// are the baseline for recurring charges and anomalies. When nil, only
+	// the file's transactions are used.
+	History func(ctx context.Context, since time.Time) ([]transactions.Transaction, error)
+	// OpeningBalance returns the balance of the stored transactions dated
+	// before the given day, which the file's statement opens with. When
+	// nil, statements open at zero.
+	OpeningBalance func(ctx context.Context, before time.Time) (float64, error)
+	// Budgets are the account's spending budgets and balance threshold,
+	// checked after every import.
+	Budgets []budget.Budget
+}
+
+// Report is the outcome of summarizing one transactions file.
+type Report struct {
+	Source string
+	// OpeningBalance is the balance before the file's first transaction.
+	OpeningBalance float64
+	TotalBalance   float64
+	Summary        map[string]transactions.Summary
+	AvgDebit       float64
+	AvgCredit      float64
+	Header         []string
+	Rejected       []transactions.RejectedRow
+	// Transactions are the rows the processor accepted, categorized. They
+	// are what gets saved when the statement is imported.
+	Transactions []transactions.Transaction
+	Body         string
+	// Statement is the PDF statement attached to the email.
+	Statement []byte
+	// Charts are the images embedded in the body.
+	Charts []email.Attachment
+	// Alert lists the unusual activity in the file and AlertBody is the
+	// email about it. Both are empty when nothing looked unusual.
+	Alert     *anomaly.Notice
+	AlertBody string
+	// Budgets are the account's budgets checked against the file.
+	Budgets []budget.Result
+
+	// emailData and templates render Body in other locales.
+	emailData email.EmailData
+	templates *email.Registry
+}
+
+// Subject is the summary email's subject, which asks for attention when a
+// budget was breached.
+func (r *Report) Subject() string {
+	if budget.NeedsAttention(r.Budgets) {
+		return AttentionSubject
+	}
+	return Subject
+}
+
+// Localized returns the summary email's subject and body in the language of
+// locale, e.g. a recipient's. Body is the English one.
+func (r *Report) Localized(locale string) (subject, body string, err error) {
+	subject = email.Translate(locale, r.Subject())
+	if r.templates == nil {
+		return subject, r.Body, nil
+	}
+	body, err = r.templates.RenderLocale(email.SummaryTemplate, locale, r.emailData)
+	if err != nil {
+		return "", "", fmt.Errorf("rendering email template: %w", err)
+	}
+	return subject, body, nil
+}
+
+// Attachments returns the files sent along with the report's email.
+func (r *Report) Attachments() []email.Attachment {
+	var attachments []email.Attachment
+	if r.Statement != nil {
+		attachments = append(attachments, email.Attachment{Filename: statement.Filename, ContentType: statement.ContentType, Data: r.Statement})
+	}
+	return append(attachments, r.Charts...)
+}
+
+// Summarize reads path with reader, computes the summary and renders the
+// email body with its charts and the PDF statement.
+func (p *Pipeline) Summarize(ctx context.Context, reader transactions.CSVReader, path string) (*Report, error) {
+	logger := logging.OrDefault(p.Logger)
+	var categorizer transactions.Categorizer = category.Default()
+	if p.Categorizer != nil {
+		categorizer = p.Categorizer
+	}
+	opts := append([]transactions.Option{transactions.WithLogger(logger), transactions.WithCategorizer(categorizer)}, p.ProcessorOptions...)
+	processor := transactions.NewProcessor(reader, opts...)
+	result, err := processor.Process(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("processing transactions: %w", err)
+	}
+
+	now := time.Now
+	if p.Now != nil {
+		now = p.Now
+	}
+	opening, err := p.openingBalance(ctx, result.Transactions)
+	if err != nil {
+		return nil, err
+	}
+	data := statement.Data{
+		Account:        p.Account,
+		Source:         filepath.Base(path),
+		OpeningBalance: opening,
+		Transactions:   result.Transactions,
+		GeneratedAt:    now(),
+	}
+	charts, images, err := summaryCharts(data)
+	if err != nil {
+		return nil, fmt.Errorf("drawing charts: %w", err)
+	}
+
+	emailData := email.GenerateEmailData(result.TotalBalance, result.Summary, result.AvgDebit, result.AvgCredit)
+	emailData.Charts = images
+	emailData.Spending = category.Spending(result.Transactions)
+	history, err := p.history(ctx, result.Transactions)
+	if err != nil {
+		return nil, err
+	}
+	detectRecurring(&emailData, history, result.Transactions)
+	emailData.Comparisons = trend.Compare(history, result.Transactions)
+	emailData.Budgets = budget.Evaluate(p.Budgets, history, result.Transactions, opening+result.TotalBalance)
+	if budget.NeedsAttention(emailData.Budgets) {
+		logger.Warn("budget breached", "source", path)
+	}
+	templates := email.DefaultTemplates
+	if p.Templates != nil {
+		templates = p.Templates
+	}
+	body, err := templates.Render(email.SummaryTemplate, emailData)
+	if err != nil {
+		return nil, fmt.Errorf("rendering email template: %w", err)
+	}
+
+	var alert *anomaly.Notice
+	var alertBody string
+	if anomalies := anomaly.Detect(history, result.Transactions, anomaly.Options{}); len(anomalies) > 0 {
+		logger.Warn("unusual activity found", "source", path, "anomalies", len(anomalies))
+		notice := anomaly.NewNotice(p.Account, filepath.Base(path), anomalies)
+		if alertBody, err = templates.Render(email.AlertTemplate, notice); err != nil {
+			return nil, fmt.Errorf("rendering alert template: %w", err)
+		}
+		alert = &notice
+	}
+
+	pdf, err := statement.Render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Source:         path,
+		OpeningBalance: opening,
+		TotalBalance:   result.TotalBalance,
+		Summary:        result.Summary,
+		AvgDebit:       result.AvgDebit,
+		AvgCredit:      result.AvgCredit,
+		Header:         result.Header,
+		Rejected:       result.Rejected,
+		Transactions:   result.Transactions,
+		Body:           body,
+		Statement:      pdf,
+		Charts:         charts,
+		Alert:          alert,
+		AlertBody:      alertBody,
+		Budgets:        emailData.Budgets,
+		emailData:      emailData,
+		templates:      templates,
+	}, nil
+}
+
+// history returns the stored transactions from the start of the file's
+// first month a year earlier up to the file's first transaction. Stored
+// rows dated within the file's period are left out: the file may have been
+// imported already, and its rows come from the file.
+func (p *Pipeline) history(ctx context.Context, current []transactions.Transaction) ([]transactions.Transaction, error) {
+	if p.History == nil || len(current) == 0 {
+		return nil, nil
+	}
+	start, _ := period(current)
+	stored, err := p.History(ctx, time.Date(start.Year()-1, start.Month(), 1, 0, 0, 0, 0, start.Location()))
+	if err != nil {
+		return nil, fmt.Errorf("loading transaction history: %w", err)
+	}
+	var history []transactions.Transaction
+	for _, t := range stored {
+		if t.Date.Before(start) {
+			history = append(history, t)
+		}
+	}
+	return history, nil
+}
+
+// openingBalance returns the stored balance before the first of current.
+// Stored rows dated within the file's period are left out, as in history.
+func (p *Pipeline) openingBalance(ctx context.Context, current []transactions.Transaction) (float64, error) {
+	if p.OpeningBalance == nil || len(current) == 0 {
+		return 0, nil
+	}
+	start, _ := period(current)
+	balance, err := p.OpeningBalance(ctx, start)
+	if err != nil {
+		return 0, fmt.Errorf("loading opening balance: %w", err)
+	}
+	return balance, nil
+}
+
+// period returns the dates of the first and last of txs.
+func period(txs []transactions.Transaction) (start, end time.Time) {
+	for i, t := range txs {
+		if i == 0 || t.Date.Before(start) {
+			start = t.Date
+		}
+		if i == 0 || t.Date.After(end) {
+			end = t.Date
+		}
+	}
+	return start, end
+}
+
+// detectRecurring lists the active subscriptions and the recurring
+// payments that were missed or changed during the file's period.
+// Everything is as of the file's last transaction, so an old statement
+// reads the same whenever it's sent.
+func detectRecurring(emailData *email.EmailData, history, current []transactions.Transaction) {
+	if len(current) == 0 {
+		return
+	}
+	start, end := period(current)
+	series := recurring.Detect(append(append([]transactions.Transaction(nil), history...), current...), end, recurring.Options{})
+	for _, s := range series {
+		if s.Subscription() && s.Active {
+			emailData.Subscriptions = append(emailData.Subscriptions, s)
+		}
+	}
+	emailData.RecurringAlerts = recurring.Alerts(series, start)
+}
+
+// DeliverAlert sends the report's unusual activity email to toEmail. It
+// does nothing when nothing looked unusual.
+func (p *Pipeline) DeliverAlert(ctx context.Context, report *Report, toEmail string) error {
+	if report.Alert == nil {
+		return nil
+	}
+	logger := logging.OrDefault(p.Logger).With("source", report.Source, "to", logging.MaskEmail(toEmail))
+	if err := p.Sender.SendEmail(ctx, email.NewMessage(toEmail, AlertSubject, report.AlertBody)); err != nil {
+		logger.Error("alert delivery failed", "outcome", "failure", "error", err)
+		return fmt.Errorf("sending alert email: %w", err)
+	}
+	logger.Info("alert delivered", "outcome", "success", "anomalies", len(report.Alert.Anomalies))
+	return nil
+}
+
+// Deliver sends the report's email to toEmail.
+func (p *Pipeline) Deliver(ctx context.Context, report *Report, toEmail string) error {
+	msg := email.NewMessage(toEmail, report.Subject(), report.Body)
+	return p.deliver(ctx, report, msg)
+}
+
+// DeliverLocalized sends the report's email, in the language of locale, to
+// the recipients of msg.
+func (p *Pipeline) DeliverLocalized(ctx context.Context, report *Report, msg email.Message, locale string) error {
+	var err error
+	if msg.Subject, msg.Body, err = report.Localized(locale); err != nil {
+		return err
+	}
+	return p.deliver(ctx, report, msg)
+}
+
+func (p *Pipeline) deliver(ctx context.Context, report *Report, msg email.Message) error {
+	start := time.Now()
+	logger := logging.OrDefault(p.Logger).With("source", report.Source, "to", logging.MaskEmails(msg.Recipients()))
+	msg.Attachments = report.Attachments()
+	if err := p.Sender.SendEmail(ctx, msg); err != nil {
+		logger.Error("summary delivery failed", "outcome", "failure", "error", err, "duration_ms", logging.Since(start))
+		return fmt.Errorf("sending email: %w", err)
+	}
+	logger.Info("summary delivered", "outcome", "success", "duration_ms", logging.Since(start))
+	return nil
+}
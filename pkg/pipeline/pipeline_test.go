@@ -0,0 +1,262 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"path/filepath"
+	"stori-technical-challenge/pkg/budget"
+	"stori-technical-challenge/pkg/email"
+	"stori-technical-challenge/pkg/pipeline"
+	"stori-technical-challenge/pkg/storage"
+	"stori-technical-challenge/pkg/transactions"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPipelineSpansShareTheCallersTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ctx, root := provider.Tracer("test").Start(context.Background(), "root")
+	p := &pipeline.Pipeline{
+		Sender:           &email.CaptureSender{},
+		ProcessorOptions: []transactions.Option{transactions.WithYear(2024)},
+	}
+	report, err := p.Summarize(ctx, transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "basic.csv"))
+	require.NoError(t, err)
+	require.NoError(t, p.Deliver(ctx, report, "user@example.com"))
+	root.End()
+
+	parents := make(map[string]string)
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, root.SpanContext().TraceID(), span.SpanContext().TraceID(), span.Name())
+		parents[span.Name()] = span.Parent().SpanID().String()
+		if span.Name() == "Processor.ProcessTransactions" {
+			parents["processor"] = span.SpanContext().SpanID().String()
+		}
+	}
+	assert.Equal(t, root.SpanContext().SpanID().String(), parents["Processor.ProcessTransactions"])
+	assert.Equal(t, parents["processor"], parents["CSVReader.Read"], "the read should be a child of processing")
+}
+
+func TestSummarizeEmbedsCharts(t *testing.T) {
+	p := &pipeline.Pipeline{
+		ProcessorOptions: []transactions.Option{transactions.WithYear(2024)},
+	}
+	report, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "basic.csv"))
+	require.NoError(t, err)
+
+	var inline []string
+	for _, a := range report.Attachments() {
+		if a.ContentID != "" {
+			inline = append(inline, a.ContentID)
+			assert.Equal(t, "image/png", a.ContentType)
+			assert.Contains(t, report.Body, `src="cid:`+a.ContentID+`"`)
+		}
+	}
+	assert.Equal(t, []string{pipeline.CreditsDebitsChartID, pipeline.BalanceChartID}, inline)
+}
+
+func TestSummarizeBreaksDownSpendingByCategory(t *testing.T) {
+	p := &pipeline.Pipeline{ProcessorOptions: []transactions.Option{transactions.WithYear(2024)}}
+	report, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "described.csv"))
+	require.NoError(t, err)
+
+	assert.Contains(t, report.Body, "Spending by category")
+	assert.Regexp(t, `(?s)Transfers.*\$300\.00.*Groceries.*\$120\.35.*Transport.*Subscriptions.*\$31\.98.*Uncategorized`, report.Body, "largest first")
+	assert.NotContains(t, report.Body, ">Income<", "credits aren't spending")
+}
+
+func TestSummarizeListsSubscriptionsFromHistory(t *testing.T) {
+	day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC) }
+	p := &pipeline.Pipeline{
+		ProcessorOptions: []transactions.Option{transactions.WithYear(2024)},
+		History: func(context.Context, time.Time) ([]transactions.Transaction, error) {
+			return []transactions.Transaction{
+				{Date: day(5, 3), Amount: -15.99, Description: "NETFLIX"},
+				{Date: day(6, 3), Amount: -15.99, Description: "Netflix"},
+				{Date: day(4, 10), Amount: -9.99, Description: "Spotify"},
+				{Date: day(5, 10), Amount: -9.99, Description: "Spotify"},
+				{Date: day(6, 10), Amount: -9.99, Description: "Spotify"},
+				// Already imported from this very file, and must not count twice
+				{Date: day(7, 3), Amount: -15.99, Description: "Netflix"},
+			}, nil
+		},
+	}
+	report, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "described.csv"))
+	require.NoError(t, err)
+
+	assert.Contains(t, report.Body, "Subscriptions</h3>")
+	assert.Regexp(t, `(?s)Netflix.*\$15\.99.*Sep 3, 2024.*Total.*\$15\.99`, report.Body, "Spotify stopped, so it isn't an active subscription")
+	assert.Contains(t, report.Body, "Spotify of -$9.99 was expected on Jul 10, 2024")
+}
+
+func TestSummarizeOpensAtTheStoredBalance(t *testing.T) {
+	var asked time.Time
+	p := &pipeline.Pipeline{
+		ProcessorOptions: []transactions.Option{transactions.WithYear(2024)},
+		OpeningBalance: func(_ context.Context, before time.Time) (float64, error) {
+			asked = before
+			return 1000, nil
+		},
+	}
+	report, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "basic.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 7, 15, 0, 0, 0, 0, time.UTC), asked, "the balance before the file's first transaction")
+	assert.Equal(t, 1000.0, report.OpeningBalance)
+
+	p.OpeningBalance = func(context.Context, time.Time) (float64, error) { return 0, errors.New("database is locked") }
+	_, err = p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "basic.csv"))
+	assert.ErrorContains(t, err, "loading opening balance: database is locked")
+}
+
+func TestSummarizeFailsWhenHistoryFails(t *testing.T) {
+	p := &pipeline.Pipeline{
+		ProcessorOptions: []transactions.Option{transactions.WithYear(2024)},
+		History: func(context.Context, time.Time) ([]transactions.Transaction, error) {
+			return nil, errors.New("database is locked")
+		},
+	}
+	_, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "described.csv"))
+	assert.ErrorContains(t, err, "database is locked")
+}
+
+func TestSummarizeAlertsOnUnusualActivity(t *testing.T) {
+	sender := &email.CaptureSender{}
+	p := &pipeline.Pipeline{
+		Sender:           sender,
+		Account:          "acme",
+		ProcessorOptions: []transactions.Option{transactions.WithYear(2024)},
+		History: func(context.Context, time.Time) ([]transactions.Transaction, error) {
+			var history []transactions.Transaction
+			for d := 1; d <= 28; d += 2 {
+				history = append(history, transactions.Transaction{Date: time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC), Amount: -float64(20 + d), Description: "Corner shop"})
+			}
+			return history, nil
+		},
+	}
+	report, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "described.csv"))
+	require.NoError(t, err)
+
+	require.NotNil(t, report.Alert)
+	assert.Equal(t, "acme", report.Alert.Account)
+	assert.Equal(t, "described.csv", report.Alert.Source)
+	assert.Contains(t, report.AlertBody, "SPEI transferencia a Juan, unusually large")
+	assert.NotContains(t, report.Body, "Unusual activity", "the customer's summary doesn't carry the alert")
+
+	require.NoError(t, p.DeliverAlert(context.Background(), report, "fraud@example.com"))
+	sent := sender.Sent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, []string{"fraud@example.com"}, sent[0].To)
+	assert.Equal(t, pipeline.AlertSubject, sent[0].Subject)
+}
+
+func TestSummarizeWithoutUnusualActivity(t *testing.T) {
+	sender := &email.CaptureSender{}
+	p := &pipeline.Pipeline{Sender: sender, ProcessorOptions: []transactions.Option{transactions.WithYear(2024)}}
+	report, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "basic.csv"))
+	require.NoError(t, err)
+
+	assert.Nil(t, report.Alert)
+	assert.Empty(t, report.AlertBody)
+	require.NoError(t, p.DeliverAlert(context.Background(), report, "fraud@example.com"))
+	assert.Empty(t, sender.Sent())
+}
+
+func TestSummarizeComparesWithStoredMonths(t *testing.T) {
+	var since time.Time
+	p := &pipeline.Pipeline{
+		ProcessorOptions: []transactions.Option{transactions.WithYear(2024)},
+		History: func(_ context.Context, from time.Time) ([]transactions.Transaction, error) {
+			since = from
+			return []transactions.Transaction{
+				{Date: time.Date(2023, 7, 3, 0, 0, 0, 0, time.UTC), Amount: 2000, Description: "Nomina"},
+				{Date: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), Amount: 2500, Description: "Nomina"},
+			}, nil
+		},
+	}
+	report, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "described.csv"))
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC), since, "the whole month a year before")
+	assert.Contains(t, report.Body, "July 2024 compared")
+	assert.Contains(t, report.Body, "August 2024 compared")
+	assert.Regexp(t, `(?s)Total credits</td>.*\$2,500\.00.*= 0\.0%.*25\.0%`, report.Body, "July: same as June, a quarter more than a year ago")
+}
+
+func TestSummarizeChecksBudgets(t *testing.T) {
+	sender := &email.CaptureSender{}
+	p := &pipeline.Pipeline{
+		Sender:           sender,
+		ProcessorOptions: []transactions.Option{transactions.WithYear(2024)},
+		Budgets: []budget.Budget{
+			{Account: "ops", Kind: budget.Spending, Amount: 300},
+			{Account: "ops", Kind: budget.Spending, Category: "transport", Amount: 50},
+			{Account: "ops", Kind: budget.MinBalance, Amount: 1000},
+		},
+	}
+	report, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "described.csv"))
+	require.NoError(t, err)
+
+	statuses := make([]budget.Status, len(report.Budgets))
+	for i, r := range report.Budgets {
+		statuses[i] = r.Status
+	}
+	// Balance first, then July's overall and transport budgets, then August's
+	assert.Equal(t, []budget.Status{budget.OK, budget.OK, budget.Warning, budget.Breached, budget.OK}, statuses)
+	assert.Contains(t, report.Body, "$15.99 over", "August's spending of $315.99")
+	assert.NotContains(t, report.Body, "below your threshold")
+
+	require.NoError(t, p.Deliver(context.Background(), report, "user@example.com"))
+	require.Len(t, sender.Sent(), 1)
+	assert.Equal(t, pipeline.AttentionSubject, sender.Sent()[0].Subject)
+
+	outputs := storage.NewMemClient()
+	dir, err := (&pipeline.Archive{Writer: outputs, Bucket: "outputs"}).Save(context.Background(), report, "user@example.com")
+	require.NoError(t, err)
+	out, err := outputs.GetObject(context.Background(), "outputs", path.Join(dir, "summary.json"))
+	require.NoError(t, err)
+	var archived pipeline.SummaryDocument
+	require.NoError(t, json.NewDecoder(out.Body).Decode(&archived))
+	assert.Equal(t, pipeline.AttentionSubject, archived.Subject, "the archive records the subject that was sent")
+
+	subject, body, err := report.Localized("es")
+	require.NoError(t, err)
+	assert.Equal(t, "Stori - Resumen de transacciones - Acción requerida", subject)
+	assert.Contains(t, body, "$15.99 por encima")
+}
+
+func TestSummarizeChecksTheBalanceAfterTheFile(t *testing.T) {
+	p := &pipeline.Pipeline{
+		ProcessorOptions: []transactions.Option{transactions.WithYear(2024)},
+		OpeningBalance:   func(context.Context, time.Time) (float64, error) { return 1000, nil },
+		Budgets:          []budget.Budget{{Account: "ops", Kind: budget.MinBalance, Amount: 500}},
+	}
+	report, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "basic.csv"))
+	require.NoError(t, err)
+	require.Len(t, report.Budgets, 1)
+	assert.InDelta(t, 1039.74, report.Budgets[0].Actual, 1e-9, "the stored balance plus the file's net flow")
+	assert.Equal(t, budget.OK, report.Budgets[0].Status)
+	assert.NotContains(t, report.Body, "below your threshold")
+}
+
+func TestSummarizeWithoutBudgets(t *testing.T) {
+	p := &pipeline.Pipeline{ProcessorOptions: []transactions.Option{transactions.WithYear(2024)}}
+	report, err := p.Summarize(context.Background(), transactions.DefaultCSVReader{}, filepath.Join("..", "..", "testdata", "fixtures", "described.csv"))
+	require.NoError(t, err)
+
+	assert.Empty(t, report.Budgets)
+	assert.NotContains(t, report.Body, "Budgets")
+	assert.Equal(t, pipeline.Subject, report.Subject())
+}
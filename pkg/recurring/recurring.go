@@ -0,0 +1,284 @@
+// Package recurring finds charges and deposits that repeat at a weekly or
+// monthly cadence, such as subscriptions and payroll, predicts when each is
+// next due and flags occurrences that were missed or changed amount.
+package recurring
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"stori-technical-challenge/pkg/transactions"
+	"time"
+)
+
+// Cadence is how often a series repeats.
+type Cadence string
+
+const (
+	Weekly  Cadence = "weekly"
+	Monthly Cadence = "monthly"
+)
+
+// Options tune detection. The zero value uses DefaultOptions.
+type Options struct {
+	// MinOccurrences is how many times a charge must appear to be
+	// considered recurring.
+	MinOccurrences int
+	// WeeklySlack and MonthlySlack are how many days an occurrence may be
+	// early or late and still be on schedule.
+	WeeklySlack  int
+	MonthlySlack int
+	// AmountTolerance is the fraction an amount may differ from the
+	// series' usual amount before it counts as changed.
+	AmountTolerance float64
+}
+
+// DefaultOptions are used for unset fields of Options.
+var DefaultOptions = Options{
+	MinOccurrences:  3,
+	WeeklySlack:     1,
+	MonthlySlack:    4,
+	AmountTolerance: 0.05,
+}
+
+func (o Options) withDefaults() Options {
+	if o.MinOccurrences == 0 {
+		o.MinOccurrences = DefaultOptions.MinOccurrences
+	}
+	if o.WeeklySlack == 0 {
+		o.WeeklySlack = DefaultOptions.WeeklySlack
+	}
+	if o.MonthlySlack == 0 {
+		o.MonthlySlack = DefaultOptions.MonthlySlack
+	}
+	if o.AmountTolerance == 0 {
+		o.AmountTolerance = DefaultOptions.AmountTolerance
+	}
+	return o
+}
+
+// Series is a detected recurring charge or deposit.
+type Series struct {
+	// Counterparty is the description of the latest occurrence, or empty
+	// for transactions without one.
+	Counterparty string  `json:"counterparty"`
+	Category     string  `json:"category,omitempty"`
+	Cadence      Cadence `json:"cadence"`
+	// Amount is the usual signed amount: the median of the occurrences.
+	Amount       float64   `json:"amount"`
+	Occurrences  int       `json:"occurrences"`
+	First        time.Time `json:"first"`
+	Last         time.Time `json:"last"`
+	NextExpected time.Time `json:"next_expected"`
+	// Active is false once the next occurrence is overdue.
+	Active bool `json:"active"`
+	// Missed are the dates an occurrence was expected but didn't come,
+	// including an overdue next one.
+	Missed []time.Time `json:"missed,omitempty"`
+	// Changed are the occurrences whose amount differs from Amount by
+	// more than the tolerance.
+	Changed []transactions.Transaction `json:"changed,omitempty"`
+}
+
+// Subscription reports whether s is a charge rather than a deposit.
+func (s Series) Subscription() bool {
+	return s.Amount < 0
+}
+
+// MonthlyCost is the magnitude of s averaged over a month.
+func (s Series) MonthlyCost() float64 {
+	if s.Cadence == Weekly {
+		return math.Abs(s.Amount) * 52 / 12
+	}
+	return math.Abs(s.Amount)
+}
+
+// Detect finds the recurring series in history as of now, subscriptions
+// first and then by monthly cost, largest first.
+func Detect(history []transactions.Transaction, now time.Time, opts Options) []Series {
+	opts = opts.withDefaults()
+
+	groups := make(map[string][]transactions.Transaction)
+	for _, t := range history {
+		if t.Amount == 0 {
+			continue
+		}
+		groups[key(t)] = append(groups[key(t)], t)
+	}
+
+	var series []Series
+	for _, group := range groups {
+		if len(group) < opts.MinOccurrences {
+			continue
+		}
+		sort.SliceStable(group, func(i, j int) bool { return group[i].Date.Before(group[j].Date) })
+		if s, ok := detect(group, now, opts); ok {
+			series = append(series, s)
+		}
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		a, b := series[i], series[j]
+		if a.Subscription() != b.Subscription() {
+			return a.Subscription()
+		}
+		if a.MonthlyCost() != b.MonthlyCost() {
+			return a.MonthlyCost() > b.MonthlyCost()
+		}
+		return a.Counterparty < b.Counterparty
+	})
+	return series
+}
+
+// key groups transactions by counterparty. Charges and deposits never share
+// a series, and transactions without a description are grouped by their
+// exact amount instead.
+func key(t transactions.Transaction) string {
+	sign := "+"
+	if t.Amount < 0 {
+		sign = "-"
+	}
+	counterparty := transactions.Counterparty(t.Description)
+	if counterparty == "" {
+		return sign + fmt.Sprintf("amount:%.2f", math.Abs(t.Amount))
+	}
+	return sign + counterparty
+}
+
+// detect checks whether the occurrences of one counterparty, oldest first,
+// follow a cadence.
+func detect(group []transactions.Transaction, now time.Time, opts Options) (Series, bool) {
+	intervals := make([]float64, 0, len(group)-1)
+	for i := 1; i < len(group); i++ {
+		intervals = append(intervals, days(group[i].Date.Sub(group[i-1].Date)))
+	}
+
+	var cadence Cadence
+	var slack int
+	switch typical := median(intervals); {
+	case math.Abs(typical-7) <= float64(opts.WeeklySlack):
+		cadence, slack = Weekly, opts.WeeklySlack
+	case typical >= 28-float64(opts.MonthlySlack) && typical <= 31+float64(opts.MonthlySlack):
+		cadence, slack = Monthly, opts.MonthlySlack
+	default:
+		return Series{}, false
+	}
+
+	first, last := group[0], group[len(group)-1]
+	s := Series{
+		Counterparty: last.Description,
+		Category:     last.Category,
+		Cadence:      cadence,
+		Occurrences:  len(group),
+		First:        first.Date,
+		Last:         last.Date,
+	}
+
+	// Every gap must be a whole number of periods, give or take the slack;
+	// gaps of several periods are missed occurrences
+	expected := first.Date
+	for _, t := range group[1:] {
+		next := advance(expected, cadence)
+		for days(t.Date.Sub(next)) > float64(slack) {
+			s.Missed = append(s.Missed, next)
+			next = advance(next, cadence)
+		}
+		if math.Abs(days(t.Date.Sub(next))) > float64(slack) {
+			return Series{}, false
+		}
+		expected = t.Date
+	}
+	if len(s.Missed) >= len(group) {
+		// More holes than occurrences is coincidence, not a schedule
+		return Series{}, false
+	}
+
+	s.NextExpected = advance(last.Date, cadence)
+	s.Active = days(now.Sub(s.NextExpected)) <= float64(slack)
+	if !s.Active {
+		s.Missed = append(s.Missed, s.NextExpected)
+	}
+
+	amounts := make([]float64, len(group))
+	for i, t := range group {
+		amounts[i] = t.Amount
+	}
+	s.Amount = median(amounts)
+	for _, t := range group {
+		if math.Abs(t.Amount-s.Amount) > math.Abs(s.Amount)*opts.AmountTolerance {
+			s.Changed = append(s.Changed, t)
+		}
+	}
+	return s, true
+}
+
+func advance(t time.Time, cadence Cadence) time.Time {
+	if cadence == Weekly {
+		return t.AddDate(0, 0, 7)
+	}
+	// Keep the day of the month where possible: Jan 31 is followed by the
+	// end of February, not March 3
+	next := t.AddDate(0, 1, 0)
+	if next.Day() != t.Day() {
+		next = next.AddDate(0, 0, -next.Day())
+	}
+	return next
+}
+
+func days(d time.Duration) float64 {
+	return d.Hours() / 24
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+// AlertKind is what went wrong with a recurring series.
+type AlertKind string
+
+const (
+	// Missed is an occurrence that was expected but didn't come.
+	Missed AlertKind = "missed"
+	// Changed is an occurrence with an unusual amount.
+	Changed AlertKind = "changed"
+)
+
+// Alert is a missed or changed occurrence worth telling the customer about.
+type Alert struct {
+	Kind         AlertKind
+	Counterparty string
+	Date         time.Time
+	// Expected is the series' usual amount; Actual is the amount charged,
+	// zero for missed occurrences.
+	Expected float64
+	Actual   float64
+}
+
+// Alerts returns the missed and changed occurrences of series dated on or
+// after since, oldest first, so old irregularities aren't reported again.
+func Alerts(series []Series, since time.Time) []Alert {
+	var alerts []Alert
+	for _, s := range series {
+		for _, date := range s.Missed {
+			if !date.Before(since) {
+				alerts = append(alerts, Alert{Kind: Missed, Counterparty: s.Counterparty, Date: date, Expected: s.Amount})
+			}
+		}
+		for _, t := range s.Changed {
+			if !t.Date.Before(since) {
+				alerts = append(alerts, Alert{Kind: Changed, Counterparty: s.Counterparty, Date: t.Date, Expected: s.Amount, Actual: t.Amount})
+			}
+		}
+	}
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].Date.Before(alerts[j].Date) })
+	return alerts
+}
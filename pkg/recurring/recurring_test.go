@@ -0,0 +1,116 @@
+package recurring_test
+
+import (
+	"stori-technical-challenge/pkg/recurring"
+	"stori-technical-challenge/pkg/transactions"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func day(month time.Month, d int) time.Time {
+	return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func tx(date time.Time, amount float64, description string) transactions.Transaction {
+	return transactions.Transaction{Date: date, Amount: amount, Description: description}
+}
+
+func TestDetectMonthlySubscription(t *testing.T) {
+	history := []transactions.Transaction{
+		tx(day(5, 3), -15.99, "NETFLIX.COM 1234"),
+		tx(day(6, 4), -15.99, "Netflix.com 5678"),
+		tx(day(7, 2), -15.99, "netflix.com"),
+		tx(day(8, 3), -15.99, "Netflix.com"),
+		tx(day(8, 10), -4.5, "Starbucks"),
+	}
+	series := recurring.Detect(history, day(8, 20), recurring.Options{})
+	require.Len(t, series, 1)
+
+	s := series[0]
+	assert.Equal(t, "Netflix.com", s.Counterparty, "named after the latest occurrence")
+	assert.Equal(t, recurring.Monthly, s.Cadence)
+	assert.Equal(t, -15.99, s.Amount)
+	assert.Equal(t, 4, s.Occurrences)
+	assert.Equal(t, day(9, 3), s.NextExpected)
+	assert.True(t, s.Active)
+	assert.True(t, s.Subscription())
+	assert.Equal(t, 15.99, s.MonthlyCost())
+	assert.Empty(t, s.Missed)
+	assert.Empty(t, s.Changed)
+}
+
+func TestDetectWeeklyDeposit(t *testing.T) {
+	var history []transactions.Transaction
+	for d := day(7, 5); d.Before(day(8, 20)); d = d.AddDate(0, 0, 7) {
+		history = append(history, tx(d, 300, "Freelance payout"))
+	}
+	series := recurring.Detect(history, day(8, 20), recurring.Options{})
+	require.Len(t, series, 1)
+	assert.Equal(t, recurring.Weekly, series[0].Cadence)
+	assert.False(t, series[0].Subscription())
+	assert.InDelta(t, 1300, series[0].MonthlyCost(), 1e-9)
+}
+
+func TestDetectFlagsMissedAndChangedOccurrences(t *testing.T) {
+	history := []transactions.Transaction{
+		tx(day(3, 15), -9.99, "Spotify"),
+		tx(day(4, 15), -9.99, "Spotify"),
+		// May is missing
+		tx(day(6, 14), -9.99, "Spotify"),
+		tx(day(7, 15), -11.99, "Spotify"),
+	}
+	// August 15 is overdue by more than the slack
+	series := recurring.Detect(history, day(8, 25), recurring.Options{})
+	require.Len(t, series, 1)
+
+	s := series[0]
+	assert.False(t, s.Active)
+	assert.Equal(t, []time.Time{day(5, 15), day(8, 15)}, s.Missed)
+	require.Len(t, s.Changed, 1)
+	assert.Equal(t, -11.99, s.Changed[0].Amount)
+
+	alerts := recurring.Alerts(series, day(7, 1))
+	assert.Equal(t, []recurring.Alert{
+		{Kind: recurring.Changed, Counterparty: "Spotify", Date: day(7, 15), Expected: -9.99, Actual: -11.99},
+		{Kind: recurring.Missed, Counterparty: "Spotify", Date: day(8, 15), Expected: -9.99},
+	}, alerts, "May is before since")
+}
+
+func TestDetectIgnoresIrregularCharges(t *testing.T) {
+	history := []transactions.Transaction{
+		tx(day(7, 1), -12, "Uber trip"),
+		tx(day(7, 3), -8, "Uber trip"),
+		tx(day(7, 19), -15, "Uber trip"),
+		tx(day(8, 2), -9, "Uber trip"),
+		// Same amount but too few
+		tx(day(7, 1), -50, ""),
+		tx(day(8, 1), -50, ""),
+	}
+	assert.Empty(t, recurring.Detect(history, day(8, 20), recurring.Options{}))
+}
+
+func TestDetectGroupsUndescribedByAmount(t *testing.T) {
+	history := []transactions.Transaction{
+		tx(day(5, 31), -500, ""),
+		tx(day(6, 30), -500, ""),
+		tx(day(7, 31), -500, ""),
+		tx(day(7, 31), -20, ""),
+	}
+	series := recurring.Detect(history, day(8, 1), recurring.Options{})
+	require.Len(t, series, 1)
+	assert.Equal(t, day(8, 31), series[0].NextExpected)
+}
+
+func TestNextExpectedAtMonthEnd(t *testing.T) {
+	history := []transactions.Transaction{
+		tx(day(11, 30).AddDate(-1, 0, 0), -10, "Gym"),
+		tx(day(12, 31).AddDate(-1, 0, 0), -10, "Gym"),
+		tx(day(1, 31), -10, "Gym"),
+	}
+	series := recurring.Detect(history, day(2, 1), recurring.Options{})
+	require.Len(t, series, 1)
+	assert.Equal(t, day(2, 29), series[0].NextExpected, "clamped to the end of February")
+}
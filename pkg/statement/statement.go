@@ -0,0 +1,313 @@
+// Package statement renders an account statement as a PDF: the document
+// attached to the summary email that customers keep for their records.
+package statement
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"stori-technical-challenge/assets"
+	"stori-technical-challenge/pkg/format"
+	"stori-technical-challenge/pkg/transactions"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Filename is the name the statement is attached under.
+const Filename = "statement.pdf"
+
+// ContentType is the MIME type of a rendered statement.
+const ContentType = "application/pdf"
+
+// Data is what goes on a statement.
+type Data struct {
+	// Account is printed in the header when set.
+	Account string
+	// Source names the file the transactions came from.
+	Source         string
+	OpeningBalance float64
+	Transactions   []transactions.Transaction
+	// GeneratedAt is printed in the footer and stored as the document's
+	// creation date. Defaults to the time of rendering.
+	GeneratedAt time.Time
+}
+
+// Month is one row of the monthly table.
+type Month struct {
+	Month           string
+	NumTransactions int
+	Credits         float64
+	Debits          float64
+}
+
+// Net is the month's credits plus its (negative) debits.
+func (m Month) Net() float64 {
+	return m.Credits + m.Debits
+}
+
+// Period returns the dates of the first and last transaction.
+func (d Data) Period() (from, to time.Time) {
+	for i, t := range d.Transactions {
+		if i == 0 || t.Date.Before(from) {
+			from = t.Date
+		}
+		if i == 0 || t.Date.After(to) {
+			to = t.Date
+		}
+	}
+	return from, to
+}
+
+// ClosingBalance is the opening balance plus every transaction.
+func (d Data) ClosingBalance() float64 {
+	balance := d.OpeningBalance
+	for _, t := range d.sorted() {
+		balance += t.Amount
+	}
+	return balance
+}
+
+// Months totals the transactions per month, oldest first.
+func (d Data) Months() []Month {
+	byMonth := make(map[string]*Month)
+	var months []*Month
+	for _, t := range d.sorted() {
+		key := transactions.MonthKey(t.Date)
+		m, ok := byMonth[key]
+		if !ok {
+			m = &Month{Month: key}
+			byMonth[key] = m
+			months = append(months, m)
+		}
+		m.NumTransactions++
+		if t.Amount > 0 {
+			m.Credits += t.Amount
+		} else {
+			m.Debits += t.Amount
+		}
+	}
+
+	result := make([]Month, len(months))
+	for i, m := range months {
+		result[i] = *m
+	}
+	return result
+}
+
+// Balance is the balance at the end of a day.
+type Balance struct {
+	Date    time.Time
+	Balance float64
+}
+
+// DailyBalances returns the balance at the end of every day with
+// transactions, oldest first.
+func (d Data) DailyBalances() []Balance {
+	var balances []Balance
+	balance := d.OpeningBalance
+	for _, t := range d.sorted() {
+		balance += t.Amount
+		if n := len(balances); n > 0 && balances[n-1].Date.Equal(t.Date) {
+			balances[n-1].Balance = balance
+			continue
+		}
+		balances = append(balances, Balance{Date: t.Date, Balance: balance})
+	}
+	return balances
+}
+
+// sorted returns the transactions by date, keeping file order within a day.
+func (d Data) sorted() []transactions.Transaction {
+	sorted := append([]transactions.Transaction(nil), d.Transactions...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Date.Before(sorted[j].Date) })
+	return sorted
+}
+
+// Render returns the statement for data as a PDF.
+func Render(data Data) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Write(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+const (
+	margin    = 15.0
+	rowHeight = 6.0
+)
+
+var (
+	brandColor = [3]int{0, 59, 70}
+	mutedColor = [3]int{110, 110, 110}
+	stripe     = [3]int{240, 244, 245}
+)
+
+// Write renders the statement for data as a PDF to w.
+func Write(w io.Writer, data Data) error {
+	generatedAt := data.GeneratedAt
+	if generatedAt.IsZero() {
+		generatedAt = time.Now()
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(true, margin+5)
+	// A fixed creation date and sorted catalog make the output reproducible
+	pdf.SetCreationDate(generatedAt)
+	pdf.SetModificationDate(generatedAt)
+	pdf.SetCatalogSort(true)
+	pdf.SetTitle("Account statement", true)
+	pdf.SetAuthor("Stori", true)
+	pdf.AliasNbPages("")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.RegisterImageOptionsReader("logo", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(assets.Logo))
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-margin)
+		pdf.SetFont("Helvetica", "", 8)
+		setTextColor(pdf, mutedColor)
+		pdf.CellFormat(0, 5, "Generated "+generatedAt.UTC().Format("2006-01-02 15:04 MST"), "", 0, "L", false, 0, "")
+		pdf.SetX(margin)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+
+	// Header
+	pdf.ImageOptions("logo", margin, margin, 40, 0, false, fpdf.ImageOptions{}, 0, "")
+	pdf.SetFont("Helvetica", "B", 18)
+	setTextColor(pdf, brandColor)
+	pdf.SetXY(margin, margin)
+	pdf.CellFormat(0, 10, "Account statement", "", 1, "R", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	setTextColor(pdf, mutedColor)
+	if data.Account != "" {
+		pdf.CellFormat(0, 5, tr("Account: "+data.Account), "", 1, "R", false, 0, "")
+	}
+	from, to := data.Period()
+	period := "No transactions"
+	if len(data.Transactions) > 0 {
+		period = format.Date(from) + " - " + format.Date(to)
+	}
+	pdf.CellFormat(0, 5, "Period: "+period, "", 1, "R", false, 0, "")
+	if data.Source != "" {
+		pdf.CellFormat(0, 5, tr("Source: "+data.Source), "", 1, "R", false, 0, "")
+	}
+	pdf.SetY(margin + 30)
+
+	// Balances
+	months := data.Months()
+	var credits, debits float64
+	for _, m := range months {
+		credits += m.Credits
+		debits += m.Debits
+	}
+	section(pdf, "Summary")
+	balances := []struct {
+		label string
+		value float64
+	}{
+		{"Opening balance", data.OpeningBalance},
+		{"Credits", credits},
+		{"Debits", debits},
+		{"Closing balance", data.ClosingBalance()},
+	}
+	width := pageWidth(pdf) / float64(len(balances))
+	pdf.SetFont("Helvetica", "", 9)
+	setTextColor(pdf, mutedColor)
+	for _, b := range balances {
+		pdf.CellFormat(width, 5, b.label, "", 0, "C", false, 0, "")
+	}
+	pdf.Ln(5)
+	pdf.SetFont("Helvetica", "B", 13)
+	setTextColor(pdf, brandColor)
+	for _, b := range balances {
+		pdf.CellFormat(width, 8, format.Currency(b.value), "", 0, "C", false, 0, "")
+	}
+	pdf.Ln(14)
+
+	// Monthly table
+	section(pdf, "Monthly summary")
+	monthColumns := []column{{"Month", 0.28, "L"}, {"Transactions", 0.18, "R"}, {"Credits", 0.18, "R"}, {"Debits", 0.18, "R"}, {"Net", 0.18, "R"}}
+	tableHeader(pdf, monthColumns)
+	for i, m := range months {
+		tableRow(pdf, monthColumns, i, []string{
+			format.Month(m.Month),
+			fmt.Sprint(m.NumTransactions),
+			format.Currency(m.Credits),
+			format.Currency(m.Debits),
+			format.Currency(m.Net()),
+		})
+	}
+	pdf.Ln(10)
+
+	// Transactions
+	section(pdf, "Transactions")
+	txnColumns := []column{{"Date", 0.25, "L"}, {"Id", 0.25, "L"}, {"Amount", 0.25, "R"}, {"Balance", 0.25, "R"}}
+	tableHeader(pdf, txnColumns)
+	balance := data.OpeningBalance
+	for i, t := range data.sorted() {
+		balance += t.Amount
+		// Repeat the header on every page the list continues on
+		if pdf.GetY()+rowHeight > pageHeight(pdf)-margin-5 {
+			pdf.AddPage()
+			tableHeader(pdf, txnColumns)
+		}
+		tableRow(pdf, txnColumns, i, []string{format.Date(t.Date), tr(t.ID), format.Currency(t.Amount), format.Currency(balance)})
+	}
+
+	if err := pdf.Output(w); err != nil {
+		return fmt.Errorf("error rendering statement: %w", err)
+	}
+	return nil
+}
+
+type column struct {
+	title string
+	// width is a fraction of the printable width.
+	width float64
+	align string
+}
+
+func section(pdf *fpdf.Fpdf, title string) {
+	pdf.SetFont("Helvetica", "B", 12)
+	setTextColor(pdf, brandColor)
+	pdf.CellFormat(0, 8, title, "B", 1, "L", false, 0, "")
+	pdf.Ln(2)
+}
+
+func tableHeader(pdf *fpdf.Fpdf, columns []column) {
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(brandColor[0], brandColor[1], brandColor[2])
+	pdf.SetTextColor(255, 255, 255)
+	for _, c := range columns {
+		pdf.CellFormat(c.width*pageWidth(pdf), rowHeight+1, c.title, "", 0, c.align, true, 0, "")
+	}
+	pdf.Ln(-1)
+}
+
+func tableRow(pdf *fpdf.Fpdf, columns []column, index int, values []string) {
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFillColor(stripe[0], stripe[1], stripe[2])
+	for i, c := range columns {
+		pdf.CellFormat(c.width*pageWidth(pdf), rowHeight, values[i], "", 0, c.align, index%2 == 1, 0, "")
+	}
+	pdf.Ln(-1)
+}
+
+func setTextColor(pdf *fpdf.Fpdf, c [3]int) {
+	pdf.SetTextColor(c[0], c[1], c[2])
+}
+
+func pageWidth(pdf *fpdf.Fpdf) float64 {
+	w, _ := pdf.GetPageSize()
+	return w - 2*margin
+}
+
+func pageHeight(pdf *fpdf.Fpdf) float64 {
+	_, h := pdf.GetPageSize()
+	return h
+}
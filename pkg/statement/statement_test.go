@@ -0,0 +1,86 @@
+package statement_test
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"stori-technical-challenge/pkg/statement"
+	"stori-technical-challenge/pkg/transactions"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func date(month time.Month, day int) time.Time {
+	return time.Date(2024, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func sampleData() statement.Data {
+	return statement.Data{
+		Account:        "acme",
+		Source:         "txns.csv",
+		OpeningBalance: 100,
+		Transactions: []transactions.Transaction{
+			{ID: "2", Date: date(8, 2), Amount: -20.46},
+			{ID: "0", Date: date(7, 15), Amount: 60.5},
+			{ID: "3", Date: date(8, 13), Amount: 10},
+			{ID: "1", Date: date(7, 28), Amount: -10.3},
+		},
+		GeneratedAt: time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestStatementTotals(t *testing.T) {
+	data := sampleData()
+
+	from, to := data.Period()
+	assert.Equal(t, date(7, 15), from)
+	assert.Equal(t, date(8, 13), to)
+	assert.InDelta(t, 139.74, data.ClosingBalance(), 1e-9)
+
+	months := data.Months()
+	require.Len(t, months, 2)
+	assert.Equal(t, statement.Month{Month: "2024-07", NumTransactions: 2, Credits: 60.5, Debits: -10.3}, months[0])
+	assert.Equal(t, "2024-08", months[1].Month)
+	assert.InDelta(t, -10.46, months[1].Net(), 1e-9)
+
+	balances := data.DailyBalances()
+	require.Len(t, balances, 4)
+	assert.Equal(t, date(7, 15), balances[0].Date)
+	assert.InDelta(t, 160.5, balances[0].Balance, 1e-9)
+	assert.InDelta(t, 139.74, balances[3].Balance, 1e-9)
+}
+
+func TestDailyBalancesMergesSameDay(t *testing.T) {
+	data := statement.Data{Transactions: []transactions.Transaction{
+		{Date: date(7, 1), Amount: 10},
+		{Date: date(7, 1), Amount: -4},
+		{Date: date(7, 2), Amount: 1},
+	}}
+	assert.Equal(t, []statement.Balance{{Date: date(7, 1), Balance: 6}, {Date: date(7, 2), Balance: 7}}, data.DailyBalances())
+}
+
+func TestRenderIsReproducible(t *testing.T) {
+	first, err := statement.Render(sampleData())
+	require.NoError(t, err)
+	second, err := statement.Render(sampleData())
+	require.NoError(t, err)
+
+	assert.True(t, bytes.HasPrefix(first, []byte("%PDF-")))
+	assert.Equal(t, first, second, "the same data must render the same bytes")
+}
+
+func TestRenderBreaksLongListsIntoPages(t *testing.T) {
+	data := sampleData()
+	data.Transactions = nil
+	for i := 0; i < 200; i++ {
+		data.Transactions = append(data.Transactions, transactions.Transaction{ID: fmt.Sprint(i), Date: date(7, 1+i%28), Amount: 1})
+	}
+
+	pdf, err := statement.Render(data)
+	require.NoError(t, err)
+	pages := regexp.MustCompile(`/Type /Page\b`).FindAll(pdf, -1)
+	assert.Greater(t, len(pages), 3)
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// FSClient is an S3Client backed by a local directory with one subdirectory
+// per bucket, for local runs and tests. Object metadata is not supported.
+type FSClient struct {
+	Root string
+	// SingleBucket makes Root itself the bucket, whatever the bucket name.
+	SingleBucket bool
+}
+
+func (c FSClient) path(bucket, key string) (string, error) {
+	// S3 bucket names never hold a separator or "..", so a bucket like ".."
+	// is rejected before it can move bucketDir out of Root
+	root := filepath.Clean(c.Root)
+	bucketDir := filepath.Join(root, bucket)
+	if c.SingleBucket {
+		bucketDir = root
+	}
+	path := filepath.Join(bucketDir, filepath.FromSlash(key))
+	if bucket == "" || key == "" || strings.ContainsAny(bucket, `/\`) || strings.Contains(bucket, "..") ||
+		!strings.HasPrefix(path, root+string(filepath.Separator)) || !strings.HasPrefix(path, bucketDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object s3://%s/%s", bucket, key)
+	}
+	return path, nil
+}
+
+func (c FSClient) GetObject(ctx context.Context, bucket, key string) (*s3.GetObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, err := c.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, notFound(bucket, key, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &s3.GetObjectOutput{
+		Body:          file,
+		ContentLength: aws.Int64(info.Size()),
+		LastModified:  aws.Time(info.ModTime()),
+	}, nil
+}
+
+func (c FSClient) HeadObject(ctx context.Context, bucket, key string) (*s3.HeadObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, err := c.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, notFound(bucket, key, err)
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(info.Size()),
+		LastModified:  aws.Time(info.ModTime()),
+	}, nil
+}
+
+func (c FSClient) PutObject(ctx context.Context, bucket, key string, body []byte, contentType string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := c.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, body, 0o644)
+}
+
+// notFound maps a missing file to the error S3 returns for a missing key.
+func notFound(bucket, key string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return awserr.New(s3.ErrCodeNoSuchKey, fmt.Sprintf("s3://%s/%s does not exist", bucket, key), err)
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// MemClient is an in-process S3Client for tests. It is safe for concurrent use.
+type MemClient struct {
+	mu      sync.RWMutex
+	objects map[string]memObject
+}
+
+type memObject struct {
+	body        []byte
+	contentType string
+	metadata    map[string]*string
+	modified    time.Time
+}
+
+func NewMemClient() *MemClient {
+	return &MemClient{objects: make(map[string]memObject)}
+}
+
+// Put stores an object with user-defined metadata, which HeadObject returns
+// the way S3 returns x-amz-meta-* headers.
+func (c *MemClient) Put(bucket, key string, body []byte, metadata map[string]string) {
+	obj := memObject{body: body, metadata: make(map[string]*string), modified: time.Now()}
+	for k, v := range metadata {
+		obj.metadata[k] = aws.String(v)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.objects[bucket+"/"+key] = obj
+}
+
+// Keys lists the keys stored in bucket, sorted.
+func (c *MemClient) Keys(bucket string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var keys []string
+	for name := range c.objects {
+		if key, ok := strings.CutPrefix(name, bucket+"/"); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *MemClient) get(ctx context.Context, bucket, key string) (memObject, error) {
+	if err := ctx.Err(); err != nil {
+		return memObject{}, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	obj, ok := c.objects[bucket+"/"+key]
+	if !ok {
+		return memObject{}, awserr.New(s3.ErrCodeNoSuchKey, fmt.Sprintf("s3://%s/%s does not exist", bucket, key), nil)
+	}
+	return obj, nil
+}
+
+func (c *MemClient) GetObject(ctx context.Context, bucket, key string) (*s3.GetObjectOutput, error) {
+	obj, err := c.get(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(obj.body)),
+		ContentLength: aws.Int64(int64(len(obj.body))),
+		ContentType:   aws.String(obj.contentType),
+		LastModified:  aws.Time(obj.modified),
+		Metadata:      obj.metadata,
+	}, nil
+}
+
+func (c *MemClient) HeadObject(ctx context.Context, bucket, key string) (*s3.HeadObjectOutput, error) {
+	obj, err := c.get(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.body))),
+		ContentType:   aws.String(obj.contentType),
+		LastModified:  aws.Time(obj.modified),
+		Metadata:      obj.metadata,
+	}, nil
+}
+
+func (c *MemClient) PutObject(ctx context.Context, bucket, key string, body []byte, contentType string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.objects[bucket+"/"+key] = memObject{
+		body:        append([]byte(nil), body...),
+		contentType: contentType,
+		metadata:    make(map[string]*string),
+		modified:    time.Now(),
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"stori-technical-challenge/pkg/tracing"
+	"stori-technical-challenge/pkg/transactions"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrObjectTooLarge is returned when an object exceeds the configured maximum size.
+var ErrObjectTooLarge = errors.New("object exceeds maximum size")
+
+// S3Client defines an interface for S3 operations.
+type S3Client interface {
+	GetObject(ctx context.Context, bucket, key string) (*s3.GetObjectOutput, error)
+	// HeadObject returns an object's metadata without its body.
+	HeadObject(ctx context.Context, bucket, key string) (*s3.HeadObjectOutput, error)
+	PutObject(ctx context.Context, bucket, key string, body []byte, contentType string) error
+}
+
+// S3Options configures a DefaultS3Client. Zero values select the defaults.
+type S3Options struct {
+	Region string
+	// Endpoint overrides the S3 endpoint, for S3-compatible stores and tests.
+	// Path-style addressing is used when it is set.
+	Endpoint string
+	// MaxRetries is the number of retries after a failed request. Defaults to
+	// 3; use a negative value to disable retries.
+	MaxRetries int
+	// MinRetryDelay and MaxRetryDelay bound the exponential backoff between
+	// retries. They default to 100ms and 5s.
+	MinRetryDelay time.Duration
+	MaxRetryDelay time.Duration
+	// Timeout bounds connecting and waiting for response headers on each
+	// attempt. Defaults to 10s. Reading the body is bounded by the context.
+	Timeout time.Duration
+	// MaxObjectSize rejects objects larger than this many bytes. Zero means
+	// no limit.
+	MaxObjectSize int64
+}
+
+// DefaultS3Client talks to S3 through a single session. Create it once (per
+// Lambda cold start) with NewDefaultS3Client and share it between calls.
+type DefaultS3Client struct {
+	svc           *s3.S3
+	maxObjectSize int64
+}
+
+func NewDefaultS3Client(opts S3Options) (*DefaultS3Client, error) {
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = client.DefaultRetryerMaxNumRetries
+	} else if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.MinRetryDelay == 0 {
+		opts.MinRetryDelay = 100 * time.Millisecond
+	}
+	if opts.MaxRetryDelay == 0 {
+		opts.MaxRetryDelay = 5 * time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: opts.Timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = opts.Timeout
+	transport.ResponseHeaderTimeout = opts.Timeout
+
+	cfg := aws.NewConfig().WithHTTPClient(&http.Client{Transport: transport})
+	if opts.Region != "" {
+		cfg = cfg.WithRegion(opts.Region)
+	}
+	if opts.Endpoint != "" {
+		cfg = cfg.WithEndpoint(opts.Endpoint).WithS3ForcePathStyle(true)
+	}
+	cfg = request.WithRetryer(cfg, client.DefaultRetryer{
+		NumMaxRetries:    opts.MaxRetries,
+		MinRetryDelay:    opts.MinRetryDelay,
+		MaxRetryDelay:    opts.MaxRetryDelay,
+		MinThrottleDelay: opts.MinRetryDelay,
+		MaxThrottleDelay: opts.MaxRetryDelay,
+	})
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %w", err)
+	}
+	return &DefaultS3Client{svc: s3.New(sess), maxObjectSize: opts.MaxObjectSize}, nil
+}
+
+func (c *DefaultS3Client) GetObject(ctx context.Context, bucket, key string) (obj *s3.GetObjectOutput, err error) {
+	ctx, span := tracing.Start(ctx, "S3.GetObject", attribute.String("s3.bucket", bucket), attribute.String("s3.key", key))
+	defer func() { tracing.End(span, err) }()
+
+	obj, err = c.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.maxObjectSize > 0 {
+		if obj.ContentLength != nil && *obj.ContentLength > c.maxObjectSize {
+			obj.Body.Close()
+			return nil, fmt.Errorf("s3://%s/%s is %d bytes: %w (%d bytes)", bucket, key, *obj.ContentLength, ErrObjectTooLarge, c.maxObjectSize)
+		}
+		// Guard against a missing or wrong Content-Length as well
+		obj.Body = &limitedBody{ReadCloser: obj.Body, remaining: c.maxObjectSize}
+	}
+	return obj, nil
+}
+
+func (c *DefaultS3Client) HeadObject(ctx context.Context, bucket, key string) (*s3.HeadObjectOutput, error) {
+	return c.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+}
+
+func (c *DefaultS3Client) PutObject(ctx context.Context, bucket, key string, body []byte, contentType string) error {
+	_, err := c.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+// limitedBody fails with ErrObjectTooLarge once more than remaining bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrObjectTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, ErrObjectTooLarge
+	}
+	return n, err
+}
+
+// Metadata returns the user-defined metadata value stored under name
+// (x-amz-meta-<name>), or "" if the object has none.
+func Metadata(head *s3.HeadObjectOutput, name string) string {
+	for k, v := range head.Metadata {
+		if strings.EqualFold(k, name) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
+
+// S3CSVReader is a transactions.CSVReader that reads objects from a bucket,
+// treating the path passed to Read as the object key.
+type S3CSVReader struct {
+	Client S3Client
+	Bucket string
+}
+
+func (r S3CSVReader) Read(ctx context.Context, key string) (records [][]string, err error) {
+	ctx, span := tracing.Start(ctx, "CSVReader.Read", attribute.String("s3.bucket", r.Bucket), attribute.String("s3.key", key))
+	defer func() { tracing.End(span, err) }()
+
+	obj, err := r.Client.GetObject(ctx, r.Bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting object from S3: %w", contextError(ctx, err))
+	}
+	defer obj.Body.Close()
+
+	records, err = transactions.ReadCSV(obj.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading s3://%s/%s: %w", r.Bucket, key, contextError(ctx, err))
+	}
+	return records, nil
+}
+
+// contextError prefers the context's error when it has expired, so a slow
+// read reports "context deadline exceeded" instead of an opaque SDK error.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %v", ctxErr, err)
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"stori-technical-challenge/pkg/storage"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3CSVReaderRead(t *testing.T) {
+	client := storage.NewMemClient()
+	client.Put("statements", "txns.csv", []byte("Id,Date,Transaction\n0,7/15,+60.5\n"), nil)
+	reader := storage.S3CSVReader{Client: client, Bucket: "statements"}
+
+	records, err := reader.Read(context.Background(), "txns.csv")
+	assert.NoError(t, err, "Error reading object")
+	assert.Equal(t, [][]string{{"Id", "Date", "Transaction"}, {"0", "7/15", "+60.5"}}, records)
+
+	_, err = reader.Read(context.Background(), "missing.csv")
+	assert.ErrorContains(t, err, "NoSuchKey")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = storage.S3CSVReader{Client: client, Bucket: "statements"}.Read(ctx, "txns.csv")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMetadata(t *testing.T) {
+	recipient := "user@example.com"
+	head := &s3.HeadObjectOutput{Metadata: map[string]*string{"Recipient": &recipient}}
+
+	assert.Equal(t, recipient, storage.Metadata(head, "recipient"))
+	assert.Equal(t, "", storage.Metadata(head, "account"))
+}
+
+func TestFSClient(t *testing.T) {
+	ctx := context.Background()
+	client := storage.FSClient{Root: t.TempDir()}
+
+	assert.NoError(t, client.PutObject(ctx, "outputs", "2024/07/summary.json", []byte(`{"ok":true}`), "application/json"))
+
+	head, err := client.HeadObject(ctx, "outputs", "2024/07/summary.json")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), *head.ContentLength)
+
+	obj, err := client.GetObject(ctx, "outputs", "2024/07/summary.json")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	assert.Equal(t, `{"ok":true}`, string(body))
+
+	_, err = client.GetObject(ctx, "outputs", "missing.json")
+	var aerr awserr.Error
+	assert.ErrorAs(t, err, &aerr)
+	assert.Equal(t, s3.ErrCodeNoSuchKey, aerr.Code())
+
+	assert.Error(t, client.PutObject(ctx, "outputs", "../escape.txt", nil, "text/plain"))
+	for _, bucket := range []string{"..", "../outputs", "outputs/..", `..\outputs`} {
+		assert.Error(t, client.PutObject(ctx, bucket, "escape.txt", nil, "text/plain"), bucket)
+	}
+	_, err = client.GetObject(ctx, "..", filepath.Base(client.Root)+"/outputs/2024/07/summary.json")
+	assert.Error(t, err, "a bucket of .. must not reach Root's siblings")
+
+	single := storage.FSClient{Root: client.Root, SingleBucket: true}
+	_, err = single.GetObject(ctx, "..", "outputs/2024/07/summary.json")
+	assert.Error(t, err)
+	obj, err = single.GetObject(ctx, "any", "outputs/2024/07/summary.json")
+	require.NoError(t, err)
+	obj.Body.Close()
+}
+
+func TestMemClient(t *testing.T) {
+	ctx := context.Background()
+	client := storage.NewMemClient()
+	client.Put("statements", "acme/txns.csv", []byte("Id,Date,Transaction\n"), map[string]string{"Recipient": "user@example.com"})
+	assert.NoError(t, client.PutObject(ctx, "outputs", "summary.json", []byte("{}"), "application/json"))
+
+	head, err := client.HeadObject(ctx, "statements", "acme/txns.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "user@example.com", storage.Metadata(head, "recipient"))
+
+	_, err = client.GetObject(ctx, "statements", "missing.csv")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"summary.json"}, client.Keys("outputs"))
+}
+
+// newTestS3Client points a DefaultS3Client at an httptest server standing in for S3.
+func newTestS3Client(t *testing.T, handler http.HandlerFunc, opts storage.S3Options) *storage.DefaultS3Client {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	opts.Region = "us-east-1"
+	opts.Endpoint = server.URL
+	opts.MinRetryDelay = time.Millisecond
+	opts.MaxRetryDelay = 5 * time.Millisecond
+	client, err := storage.NewDefaultS3Client(opts)
+	require.NoError(t, err)
+	return client
+}
+
+func TestDefaultS3ClientRetries(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestS3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "Id,Date,Transaction\n")
+	}, storage.S3Options{MaxRetries: 3})
+
+	obj, err := client.GetObject(context.Background(), "statements", "txns.csv")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(obj.Body)
+	assert.Equal(t, "Id,Date,Transaction\n", string(body))
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestDefaultS3ClientMaxObjectSize(t *testing.T) {
+	client := newTestS3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 100))
+	}, storage.S3Options{MaxObjectSize: 10})
+
+	_, err := client.GetObject(context.Background(), "statements", "big.csv")
+	assert.ErrorIs(t, err, storage.ErrObjectTooLarge)
+}
+
+func TestDefaultS3ClientHonorsContext(t *testing.T) {
+	client := newTestS3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}, storage.S3Options{MaxRetries: -1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := storage.S3CSVReader{Client: client, Bucket: "statements"}.Read(ctx, "slow.csv")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
@@ -0,0 +1,120 @@
+// Package tracing sets up OpenTelemetry and provides the helpers used to
+// create spans across the read, process, persist and send steps.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExporterEnv selects the span exporter: "otlp", "stdout" or "none" (the
+// default). The OTLP exporter reads its endpoint and headers from the
+// standard OTEL_EXPORTER_OTLP_* variables.
+const ExporterEnv = "OTEL_TRACES_EXPORTER"
+
+const instrumentationName = "stori-technical-challenge"
+
+// Options configures Setup.
+type Options struct {
+	// Exporter is "otlp", "stdout" or "none". Defaults to $OTEL_TRACES_EXPORTER.
+	Exporter string
+	// ServiceName is reported as service.name. Defaults to
+	// $OTEL_SERVICE_NAME and then to "stori".
+	ServiceName string
+	// Writer receives spans from the stdout exporter. Defaults to os.Stdout.
+	Writer io.Writer
+}
+
+// Setup installs the global tracer provider and propagator. The returned
+// function flushes pending spans and must be called before the process
+// exits. With no exporter configured tracing is a no-op and so is the
+// shutdown function.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	exporterName := opts.Exporter
+	if exporterName == "" {
+		exporterName = os.Getenv(ExporterEnv)
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(exporterName) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		w := opts.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		return nil, fmt.Errorf("unknown %s %q: use otlp, stdout or none", ExporterEnv, exporterName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s trace exporter: %w", exporterName, err)
+	}
+
+	serviceName := opts.ServiceName
+	if serviceName == "" {
+		serviceName = os.Getenv("OTEL_SERVICE_NAME")
+	}
+	if serviceName == "" {
+		serviceName = "stori"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		return errors.Join(provider.ForceFlush(ctx), provider.Shutdown(ctx))
+	}, nil
+}
+
+// Flush exports pending spans without shutting the provider down. The
+// Lambda handler calls it after every invocation because the runtime may
+// freeze the process before the batcher gets to run.
+func Flush(ctx context.Context) error {
+	if provider, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		return provider.ForceFlush(ctx)
+	}
+	return nil
+}
+
+// Start starts a span named name as a child of the span in ctx, if any.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, if any, and ends it. It is meant to be deferred
+// from functions with a named error result:
+//
+//	ctx, span := tracing.Start(ctx, "db.SaveTransactionsFromCSV")
+//	defer func() { tracing.End(span, err) }()
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,43 @@
+package transactions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MonthLayout is the layout of the month keys used in Summary maps.
+const MonthLayout = "2006-01"
+
+// dateLayouts are the accepted date formats. Dates without a year, the
+// format used by the sample files, are placed in the reference year.
+var dateLayouts = []struct {
+	layout  string
+	hasYear bool
+}{
+	{"1/2", false},
+	{"1/2/2006", true},
+	{"2006-01-02", true},
+}
+
+// ParseDate parses a transaction date. Values without a year ("7/15") are
+// assumed to belong to year.
+func ParseDate(value string, year int) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, l := range dateLayouts {
+		date, err := time.Parse(l.layout, value)
+		if err != nil {
+			continue
+		}
+		if !l.hasYear {
+			date = date.AddDate(year-date.Year(), 0, 0)
+		}
+		return date, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// MonthKey returns the Summary key for the month containing date.
+func MonthKey(date time.Time) string {
+	return date.Format(MonthLayout)
+}
@@ -1,73 +1,239 @@
 package transactions
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/metrics"
+	"stori-technical-challenge/pkg/tracing"
 	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Processor struct {
-	reader CSVReader
+	reader      CSVReader
+	year        int
+	logger      *slog.Logger
+	categorizer Categorizer
+}
+
+// Categorizer assigns a category to a transaction from its description and
+// signed amount.
+type Categorizer interface {
+	Categorize(description string, amount float64) string
+}
+
+// Option configures a Processor.
+type Option func(*Processor)
+
+// WithYear sets the year assumed for dates that don't include one. It
+// defaults to the current year.
+func WithYear(year int) Option {
+	return func(p *Processor) {
+		p.year = year
+	}
+}
+
+// WithLogger sets the logger used to report each run. It defaults to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Processor) {
+		p.logger = logging.OrDefault(logger)
+	}
+}
+
+// WithCategorizer sets the categorizer that fills Transaction.Category.
+// Without one, transactions are left uncategorized.
+func WithCategorizer(c Categorizer) Option {
+	return func(p *Processor) {
+		p.categorizer = c
+	}
+}
+
+func NewProcessor(reader CSVReader, opts ...Option) *Processor {
+	p := &Processor{reader: reader, year: time.Now().Year(), logger: slog.Default()}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Result is everything computed from one transactions file.
+type Result struct {
+	TotalBalance float64
+	Summary      map[string]Summary
+	AvgDebit     float64
+	AvgCredit    float64
+	// Header is the file's header row, kept so rejected rows can be written
+	// back out with their original columns.
+	Header   []string
+	Rejected []RejectedRow
+	// Transactions are the accepted rows, in file order.
+	Transactions []Transaction
+}
+
+// Transaction is an accepted row of a transactions file.
+type Transaction struct {
+	ID     string
+	Date   time.Time
+	Amount float64
+	// Description is the optional fourth column, usually the merchant.
+	Description string
+	Category    string
+}
+
+var counterpartyNoise = regexp.MustCompile(`[^\pL]+`)
+
+// Counterparty normalizes a description for telling whether two
+// transactions are with the same merchant. Case, digits and punctuation are
+// ignored, so "UBER *TRIP 8812" and "Uber trip 1203" match.
+func Counterparty(description string) string {
+	return strings.TrimSpace(counterpartyNoise.ReplaceAllString(strings.ToLower(description), " "))
 }
 
-func NewProcessor(reader CSVReader) *Processor {
-	return &Processor{reader: reader}
+// RejectedRow is an input row that was skipped because it couldn't be parsed.
+type RejectedRow struct {
+	Line   int
+	Record []string
+	Reason string
 }
 
-func (p *Processor) ProcessTransactions(filePath string) (float64, map[string]Summary, float64, float64, error) {
-	records, err := p.reader.Read(filePath)
+func (p *Processor) ProcessTransactions(ctx context.Context, filePath string) (float64, map[string]Summary, float64, float64, error) {
+	result, err := p.Process(ctx, filePath)
 	if err != nil {
-		return 0, nil, 0, 0, fmt.Errorf("error reading transactions: %w", err)
+		return 0, nil, 0, 0, err
 	}
+	return result.TotalBalance, result.Summary, result.AvgDebit, result.AvgCredit, nil
+}
+
+// Process reads and summarizes filePath, reporting the rows it rejected.
+func (p *Processor) Process(ctx context.Context, filePath string) (_ *Result, err error) {
+	ctx, span := tracing.Start(ctx, "Processor.ProcessTransactions", attribute.String("source", filePath))
+	defer func() { tracing.End(span, err) }()
 
-	transactions := p.parseTransactions(records)
+	start := time.Now()
+	logger := p.logger.With("source", filePath)
+
+	records, err := p.reader.Read(ctx, filePath)
+	if err != nil {
+		logger.Error("reading transactions failed", "outcome", "failure", "error", err, "duration_ms", logging.Since(start))
+		metrics.ProcessingDuration.WithLabelValues("failure").Observe(metrics.Since(start))
+		return nil, fmt.Errorf("error reading transactions: %w", err)
+	}
+
+	accepted, rejected := p.parseTransactions(records)
+	transactions := groupByMonth(accepted)
+	for _, row := range rejected {
+		logger.Warn("row rejected", "line", row.Line, "reason", row.Reason)
+	}
 
 	totalBalance, avgCredit, avgDebit, err := p.calculateTotalsAndAverages(transactions)
 	if err != nil {
-		return 0, nil, 0, 0, err
+		return nil, err
 	}
 
-	summary := p.generateSummary(transactions)
+	result := &Result{
+		TotalBalance: totalBalance,
+		Summary:      p.generateSummary(transactions),
+		AvgDebit:     avgDebit,
+		AvgCredit:    avgCredit,
+		Rejected:     rejected,
+		Transactions: accepted,
+	}
+	if len(records) > 0 {
+		result.Header = records[0]
+	}
 
-	return totalBalance, summary, avgDebit, avgCredit, nil
+	rowsRead := max(len(records)-1, 0)
+	metrics.RowsRead.Add(float64(rowsRead))
+	metrics.RowsAccepted.Add(float64(rowsRead - len(rejected)))
+	metrics.RowsRejected.Add(float64(len(rejected)))
+	metrics.ProcessingDuration.WithLabelValues("success").Observe(metrics.Since(start))
+	span.SetAttributes(
+		attribute.Int("rows_read", rowsRead),
+		attribute.Int("rows_accepted", rowsRead-len(rejected)),
+		attribute.Int("rows_rejected", len(rejected)),
+	)
+	logger.Info("transactions processed",
+		"outcome", "success",
+		"rows_read", rowsRead,
+		"rows_accepted", rowsRead-len(rejected),
+		"rows_rejected", len(rejected),
+		"months", len(result.Summary),
+		"duration_ms", logging.Since(start),
+	)
+	return result, nil
 }
 
-func (p *Processor) parseTransactions(records [][]string) map[string][]float64 {
-	transactions := make(map[string][]float64)
-	currentYear := time.Now().Year()
+func (p *Processor) parseTransactions(records [][]string) ([]Transaction, []RejectedRow) {
+	var transactions []Transaction
+	var rejected []RejectedRow
 
 	for i, record := range records {
 		if i == 0 {
 			continue // skip header
 		}
+		reject := func(reason string) {
+			rejected = append(rejected, RejectedRow{Line: i + 1, Record: record, Reason: reason})
+		}
+		if len(record) < 3 {
+			reject("missing columns")
+			continue
+		}
 
-		dateStr := strings.TrimSpace(record[1])
-		transactionStr := strings.TrimSpace(record[2])
-		date, err := time.Parse("1/2", dateStr)
+		date, err := ParseDate(record[1], p.year)
 		if err != nil {
+			reject(err.Error())
 			continue
 		}
-		date = date.AddDate(currentYear-date.Year(), 0, 0)
 
-		amount, err := strconv.ParseFloat(transactionStr, 64)
+		amount, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
 		if err != nil {
+			reject(fmt.Sprintf("invalid amount %q", record[2]))
 			continue
 		}
 
-		month := date.Format("2006-01")
-		transactions[month] = append(transactions[month], amount)
+		t := Transaction{ID: strings.TrimSpace(record[0]), Date: date, Amount: amount}
+		if len(record) > 3 {
+			t.Description = strings.TrimSpace(record[3])
+		}
+		if p.categorizer != nil {
+			t.Category = p.categorizer.Categorize(t.Description, t.Amount)
+		}
+		transactions = append(transactions, t)
 	}
 
-	return transactions
+	return transactions, rejected
+}
+
+// groupByMonth returns the amounts of transactions keyed by MonthKey.
+func groupByMonth(transactions []Transaction) map[string][]float64 {
+	byMonth := make(map[string][]float64)
+	for _, t := range transactions {
+		month := MonthKey(t.Date)
+		byMonth[month] = append(byMonth[month], t.Amount)
+	}
+	return byMonth
 }
 
 func (p *Processor) calculateTotalsAndAverages(transactions map[string][]float64) (float64, float64, float64, error) {
 	var totalBalance, totalCredit, totalDebit float64
 	var numCredits, numDebits int
 
-	for _, amounts := range transactions {
-		for _, amount := range amounts {
+	// Sum months in order so the floating point result doesn't depend on map iteration
+	months := make([]string, 0, len(transactions))
+	for month := range transactions {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+
+	for _, month := range months {
+		for _, amount := range transactions[month] {
 			totalBalance += amount
 			if amount > 0 {
 				totalCredit += amount
@@ -1,11 +1,19 @@
 package transactions_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"stori-technical-challenge/pkg/transactions"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func createTestCSV(filePath string) {
@@ -30,17 +38,19 @@ func TestProcessTransactions(t *testing.T) {
 	createTestCSV(filePath)
 	defer os.Remove(filePath)
 
-	totalBalance, summary, avgDebit, avgCredit, err := processor.ProcessTransactions(filePath)
+	totalBalance, summary, avgDebit, avgCredit, err := processor.ProcessTransactions(context.Background(), filePath)
 	assert.NoError(t, err, "Error processing transactions")
 	assert.Equal(t, 39.74, totalBalance, "Total balance does not match")
 
+	// Dates in the CSV carry no year, so the processor assumes the current one
+	year := time.Now().Year()
 	expectedSummary := map[string]transactions.Summary{
-		"2024-07": {
+		fmt.Sprintf("%d-07", year): {
 			NumTransactions: 2,
 			AvgCredit:       60.5,
 			AvgDebit:        -10.3,
 		},
-		"2024-08": {
+		fmt.Sprintf("%d-08", year): {
 			NumTransactions: 2,
 			AvgCredit:       10.0,
 			AvgDebit:        -20.46,
@@ -51,3 +61,157 @@ func TestProcessTransactions(t *testing.T) {
 	assert.Equal(t, -15.38, avgDebit, "Average debit amount does not match")
 	assert.Equal(t, 35.25, avgCredit, "Average credit amount does not match")
 }
+
+func TestProcessTransactionsFixtures(t *testing.T) {
+	tests := []struct {
+		fixture      string
+		totalBalance float64
+		summary      map[string]transactions.Summary
+	}{
+		{
+			fixture:      "basic.csv",
+			totalBalance: 39.74,
+			summary: map[string]transactions.Summary{
+				"2024-07": {NumTransactions: 2, AvgCredit: 60.5, AvgDebit: -10.3},
+				"2024-08": {NumTransactions: 2, AvgCredit: 10, AvgDebit: -20.46},
+			},
+		},
+		{
+			// Every row falls on a different day but there are only two months
+			fixture:      "many_days.csv",
+			totalBalance: 220,
+			summary: map[string]transactions.Summary{
+				"2024-07": {NumTransactions: 6, AvgCredit: 70, AvgDebit: -12.5},
+				"2024-08": {NumTransactions: 4, AvgCredit: 102.5, AvgDebit: -37.5},
+			},
+		},
+		{
+			fixture:      "full_dates.csv",
+			totalBalance: 57.5,
+			summary: map[string]transactions.Summary{
+				"2023-12": {NumTransactions: 2, AvgCredit: 80, AvgDebit: -30},
+				"2024-01": {NumTransactions: 2, AvgCredit: 20, AvgDebit: -12.5},
+			},
+		},
+		{
+			fixture:      "described.csv",
+			totalBalance: 4497.07,
+			summary: map[string]transactions.Summary{
+				"2024-07": {NumTransactions: 5, AvgCredit: 2500, AvgDebit: -46.735},
+				"2024-08": {NumTransactions: 3, AvgCredit: 2500, AvgDebit: -157.995},
+			},
+		},
+		{
+			fixture:      "invalid_rows.csv",
+			totalBalance: 40.5,
+			summary: map[string]transactions.Summary{
+				"2024-07": {NumTransactions: 1, AvgCredit: 60.5},
+				"2024-08": {NumTransactions: 1, AvgDebit: -20},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			processor := transactions.NewProcessor(transactions.DefaultCSVReader{}, transactions.WithYear(2024))
+
+			totalBalance, summary, _, _, err := processor.ProcessTransactions(context.Background(), filepath.Join("..", "..", "testdata", "fixtures", tt.fixture))
+			require.NoError(t, err)
+			assert.InDelta(t, tt.totalBalance, totalBalance, 1e-9)
+			assert.Equal(t, tt.summary, summary)
+		})
+	}
+}
+
+type categorizerFunc func(string, float64) string
+
+func (f categorizerFunc) Categorize(description string, amount float64) string {
+	return f(description, amount)
+}
+
+func TestProcessCategorizesDescriptions(t *testing.T) {
+	processor := transactions.NewProcessor(transactions.DefaultCSVReader{}, transactions.WithYear(2024),
+		transactions.WithCategorizer(categorizerFunc(func(description string, amount float64) string {
+			return fmt.Sprintf("%s/%g", description, amount)
+		})))
+
+	result, err := processor.Process(context.Background(), filepath.Join("..", "..", "testdata", "fixtures", "described.csv"))
+	require.NoError(t, err)
+	require.Len(t, result.Transactions, 8)
+	assert.Equal(t, "UBER *TRIP", result.Transactions[2].Description)
+	assert.Equal(t, "UBER *TRIP/-42.1", result.Transactions[2].Category)
+
+	// Files without the column still parse
+	result, err = processor.Process(context.Background(), filepath.Join("..", "..", "testdata", "fixtures", "basic.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "", result.Transactions[0].Description)
+	assert.Equal(t, "/60.5", result.Transactions[0].Category)
+}
+
+func TestParseDate(t *testing.T) {
+	for value, want := range map[string]string{
+		"7/15":       "2024-07-15",
+		" 12/1 ":     "2024-12-01",
+		"1/2/2023":   "2023-01-02",
+		"2023-11-30": "2023-11-30",
+	} {
+		date, err := transactions.ParseDate(value, 2024)
+		assert.NoError(t, err, value)
+		assert.Equal(t, want, date.Format("2006-01-02"), value)
+	}
+
+	_, err := transactions.ParseDate("7-15", 2024)
+	assert.Error(t, err)
+}
+
+func TestProcessReportsRejectedRows(t *testing.T) {
+	processor := transactions.NewProcessor(transactions.DefaultCSVReader{}, transactions.WithYear(2024))
+
+	result, err := processor.Process(context.Background(), filepath.Join("..", "..", "testdata", "fixtures", "invalid_rows.csv"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Id", "Date", "Transaction"}, result.Header)
+	assert.Equal(t, []transactions.RejectedRow{
+		{Line: 3, Record: []string{"1", "13/45", "-10"}, Reason: `invalid date "13/45"`},
+		{Line: 4, Record: []string{"2", "7/20", "abc"}, Reason: `invalid amount "abc"`},
+		{Line: 5, Record: []string{"3", "7/21", ""}, Reason: `invalid amount ""`},
+		{Line: 7, Record: []string{"5", "8/2"}, Reason: "missing columns"},
+	}, result.Rejected)
+	assert.Equal(t, []transactions.Transaction{
+		{ID: "0", Date: time.Date(2024, 7, 15, 0, 0, 0, 0, time.UTC), Amount: 60.5},
+		{ID: "4", Date: time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC), Amount: -20},
+	}, result.Transactions)
+}
+
+func TestProcessLogsRowCounts(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil)).With("correlation_id", "abc123")
+	processor := transactions.NewProcessor(transactions.DefaultCSVReader{}, transactions.WithYear(2024), transactions.WithLogger(logger))
+
+	_, err := processor.Process(context.Background(), filepath.Join("..", "..", "testdata", "fixtures", "invalid_rows.csv"))
+	require.NoError(t, err)
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.NotEmpty(t, lines)
+	var summary map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[len(lines)-1], &summary))
+
+	assert.Equal(t, "transactions processed", summary["msg"])
+	assert.Equal(t, "abc123", summary["correlation_id"])
+	assert.Equal(t, "success", summary["outcome"])
+	assert.EqualValues(t, 4, summary["rows_rejected"])
+	assert.EqualValues(t, summary["rows_read"], summary["rows_accepted"].(float64)+summary["rows_rejected"].(float64))
+	assert.Contains(t, summary, "duration_ms")
+}
+
+func TestProcessWithNilLogger(t *testing.T) {
+	processor := transactions.NewProcessor(transactions.DefaultCSVReader{}, transactions.WithYear(2024), transactions.WithLogger(nil))
+	_, err := processor.Process(context.Background(), filepath.Join("..", "..", "testdata", "fixtures", "basic.csv"))
+	assert.NoError(t, err)
+}
+
+func TestCounterparty(t *testing.T) {
+	assert.Equal(t, transactions.Counterparty("UBER *TRIP 8812"), transactions.Counterparty("Uber trip 1203"))
+	assert.Equal(t, "café de la plaza", transactions.Counterparty("  CAFÉ de la Plaza #12 "))
+	assert.Empty(t, transactions.Counterparty("1234"))
+}
@@ -1,23 +1,43 @@
 package transactions
 
 import (
+	"context"
 	"encoding/csv"
+	"fmt"
+	"io"
 	"os"
+	"stori-technical-challenge/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type CSVReader interface {
-	Read(filePath string) ([][]string, error)
+	Read(ctx context.Context, filePath string) ([][]string, error)
 }
 
 type DefaultCSVReader struct{}
 
-func (r DefaultCSVReader) Read(filePath string) ([][]string, error) {
+func (r DefaultCSVReader) Read(ctx context.Context, filePath string) (records [][]string, err error) {
+	_, span := tracing.Start(ctx, "CSVReader.Read", attribute.String("file.path", filePath))
+	defer func() { tracing.End(span, err) }()
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	return reader.ReadAll()
+	return ReadCSV(file)
+}
+
+// ReadCSV reads every record from a CSV stream.
+func ReadCSV(r io.Reader) ([][]string, error) {
+	reader := csv.NewReader(r)
+	// Let rows with missing columns through so they're rejected individually
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV: %w", err)
+	}
+	return records, nil
 }
@@ -1,7 +1,7 @@
 package transactions
 
 type Summary struct {
-	NumTransactions int
-	AvgCredit       float64
-	AvgDebit        float64
+	NumTransactions int     `json:"num_transactions"`
+	AvgCredit       float64 `json:"avg_credit"`
+	AvgDebit        float64 `json:"avg_debit"`
 }
@@ -0,0 +1,182 @@
+// Package trend compares each month of a statement with the month before
+// it and the same month a year earlier, so the summary shows whether
+// things are going up or down.
+package trend
+
+import (
+	"math"
+	"sort"
+	"stori-technical-challenge/pkg/transactions"
+	"time"
+)
+
+// Stats are the figures of one month. Debits are negative, as in the
+// statement.
+type Stats struct {
+	Month       string  `json:"month"`
+	Count       int     `json:"count"`
+	TotalCredit float64 `json:"total_credit"`
+	TotalDebit  float64 `json:"total_debit"`
+	AvgCredit   float64 `json:"avg_credit"`
+	AvgDebit    float64 `json:"avg_debit"`
+	Net         float64 `json:"net"`
+}
+
+// Monthly returns the stats of every month in txs, keyed by
+// transactions.MonthLayout.
+func Monthly(txs []transactions.Transaction) map[string]Stats {
+	months := make(map[string]Stats)
+	credits := make(map[string]int)
+	for _, t := range txs {
+		month := t.Date.Format(transactions.MonthLayout)
+		s := months[month]
+		s.Month = month
+		s.Count++
+		s.Net += t.Amount
+		if t.Amount > 0 {
+			s.TotalCredit += t.Amount
+			credits[month]++
+		} else {
+			s.TotalDebit += t.Amount
+		}
+		months[month] = s
+	}
+	for month, s := range months {
+		if n := credits[month]; n > 0 {
+			s.AvgCredit = s.TotalCredit / float64(n)
+		}
+		if n := s.Count - credits[month]; n > 0 {
+			s.AvgDebit = s.TotalDebit / float64(n)
+		}
+		months[month] = s
+	}
+	return months
+}
+
+// Direction is which way a figure moved.
+type Direction string
+
+const (
+	Up   Direction = "up"
+	Down Direction = "down"
+	Flat Direction = "flat"
+)
+
+// Change is how a figure moved from an earlier month.
+type Change struct {
+	Previous float64 `json:"previous"`
+	Diff     float64 `json:"diff"`
+	// Ratio is Diff relative to the size of Previous. It is only set when
+	// Previous isn't zero; see HasRatio.
+	Ratio float64 `json:"ratio"`
+}
+
+func newChange(current, previous float64) *Change {
+	c := &Change{Previous: previous, Diff: current - previous}
+	if previous != 0 {
+		c.Ratio = c.Diff / math.Abs(previous)
+	}
+	return c
+}
+
+// HasRatio reports whether the change can be told as a percentage.
+func (c Change) HasRatio() bool {
+	return c.Previous != 0
+}
+
+// Direction is Flat for differences under a cent.
+func (c Change) Direction() Direction {
+	switch {
+	case c.Diff >= 0.005:
+		return Up
+	case c.Diff <= -0.005:
+		return Down
+	}
+	return Flat
+}
+
+// Metric is one figure of a month with its changes. A nil change means
+// there is no data for the earlier month.
+type Metric struct {
+	Name string `json:"name"`
+	// Money is false for counts.
+	Money      bool    `json:"money"`
+	Value      float64 `json:"value"`
+	VsPrevious *Change `json:"vs_previous,omitempty"`
+	VsLastYear *Change `json:"vs_last_year,omitempty"`
+}
+
+// Comparison is a month set against the month before and the same month
+// of the previous year.
+type Comparison struct {
+	Month    string `json:"month"`
+	Current  Stats  `json:"current"`
+	Previous *Stats `json:"previous,omitempty"`
+	LastYear *Stats `json:"last_year,omitempty"`
+}
+
+// Compare returns a comparison for every month in current, oldest first.
+// The earlier months come from current when it covers them and from
+// history otherwise.
+func Compare(history, current []transactions.Transaction) []Comparison {
+	months := Monthly(current)
+	past := Monthly(history)
+	lookup := func(month string) *Stats {
+		if s, ok := months[month]; ok {
+			return &s
+		}
+		if s, ok := past[month]; ok {
+			return &s
+		}
+		return nil
+	}
+
+	comparisons := make([]Comparison, 0, len(months))
+	for month, s := range months {
+		start, err := time.Parse(transactions.MonthLayout, month)
+		if err != nil {
+			continue
+		}
+		comparisons = append(comparisons, Comparison{
+			Month:    month,
+			Current:  s,
+			Previous: lookup(start.AddDate(0, -1, 0).Format(transactions.MonthLayout)),
+			LastYear: lookup(start.AddDate(-1, 0, 0).Format(transactions.MonthLayout)),
+		})
+	}
+	sort.Slice(comparisons, func(i, j int) bool { return comparisons[i].Month < comparisons[j].Month })
+	return comparisons
+}
+
+// HasHistory reports whether there is an earlier month to compare with.
+func (c Comparison) HasHistory() bool {
+	return c.Previous != nil || c.LastYear != nil
+}
+
+// Metrics returns the month's figures in display order.
+func (c Comparison) Metrics() []Metric {
+	figures := []struct {
+		name  string
+		money bool
+		value func(Stats) float64
+	}{
+		{"Transactions", false, func(s Stats) float64 { return float64(s.Count) }},
+		{"Total credits", true, func(s Stats) float64 { return s.TotalCredit }},
+		{"Total debits", true, func(s Stats) float64 { return s.TotalDebit }},
+		{"Average credit", true, func(s Stats) float64 { return s.AvgCredit }},
+		{"Average debit", true, func(s Stats) float64 { return s.AvgDebit }},
+		{"Net", true, func(s Stats) float64 { return s.Net }},
+	}
+	metrics := make([]Metric, len(figures))
+	for i, f := range figures {
+		value := f.value(c.Current)
+		metrics[i] = Metric{Name: f.name, Money: f.money, Value: value}
+		if c.Previous != nil {
+			metrics[i].VsPrevious = newChange(value, f.value(*c.Previous))
+		}
+		if c.LastYear != nil {
+			metrics[i].VsLastYear = newChange(value, f.value(*c.LastYear))
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,87 @@
+package trend_test
+
+import (
+	"stori-technical-challenge/pkg/transactions"
+	"stori-technical-challenge/pkg/trend"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tx(year int, month time.Month, day int, amount float64) transactions.Transaction {
+	return transactions.Transaction{Date: time.Date(year, month, day, 0, 0, 0, 0, time.UTC), Amount: amount}
+}
+
+func TestMonthly(t *testing.T) {
+	months := trend.Monthly([]transactions.Transaction{
+		tx(2024, 7, 15, 60.5),
+		tx(2024, 7, 28, -10.3),
+		tx(2024, 8, 2, -20.46),
+		tx(2024, 8, 13, 10),
+		tx(2024, 8, 20, -5),
+	})
+	assert.Equal(t, map[string]trend.Stats{
+		"2024-07": {Month: "2024-07", Count: 2, TotalCredit: 60.5, TotalDebit: -10.3, AvgCredit: 60.5, AvgDebit: -10.3, Net: 50.2},
+		"2024-08": {Month: "2024-08", Count: 3, TotalCredit: 10, TotalDebit: -25.46, AvgCredit: 10, AvgDebit: -12.73, Net: -15.46},
+	}, months)
+}
+
+func TestCompareUsesHistoryForEarlierMonths(t *testing.T) {
+	history := []transactions.Transaction{
+		tx(2023, 8, 10, -50),
+		tx(2024, 6, 30, -100),
+		// Current covers July, so this stored row is ignored
+		tx(2024, 7, 1, -999),
+	}
+	current := []transactions.Transaction{
+		tx(2024, 7, 15, -80),
+		tx(2024, 8, 2, -120),
+	}
+	comparisons := trend.Compare(history, current)
+	require.Len(t, comparisons, 2)
+
+	july, august := comparisons[0], comparisons[1]
+	assert.Equal(t, "2024-07", july.Month)
+	require.NotNil(t, july.Previous)
+	assert.Equal(t, "2024-06", july.Previous.Month)
+	assert.Nil(t, july.LastYear)
+
+	assert.Equal(t, "2024-08", august.Month)
+	require.NotNil(t, august.Previous)
+	assert.Equal(t, -80.0, august.Previous.TotalDebit, "from the file, not the stored row")
+	require.NotNil(t, august.LastYear)
+	assert.Equal(t, -50.0, august.LastYear.TotalDebit)
+	assert.True(t, august.HasHistory())
+}
+
+func TestMetrics(t *testing.T) {
+	comparisons := trend.Compare([]transactions.Transaction{tx(2024, 7, 1, -100), tx(2024, 7, 2, -100)}, []transactions.Transaction{tx(2024, 8, 1, 500), tx(2024, 8, 2, -150)})
+	require.Len(t, comparisons, 1)
+	metrics := comparisons[0].Metrics()
+	require.Len(t, metrics, 6)
+
+	byName := make(map[string]trend.Metric)
+	for _, m := range metrics {
+		byName[m.Name] = m
+		assert.Nil(t, m.VsLastYear, "no data a year ago")
+	}
+
+	count := byName["Transactions"]
+	assert.False(t, count.Money)
+	assert.Equal(t, trend.Flat, count.VsPrevious.Direction())
+
+	debits := byName["Total debits"]
+	assert.Equal(t, -150.0, debits.Value)
+	assert.Equal(t, 50.0, debits.VsPrevious.Diff)
+	assert.Equal(t, 0.25, debits.VsPrevious.Ratio, "relative to the size of the previous total")
+	assert.Equal(t, trend.Up, debits.VsPrevious.Direction(), "less spent")
+
+	credits := byName["Total credits"]
+	assert.False(t, credits.VsPrevious.HasRatio(), "nothing credited the month before")
+	assert.Equal(t, trend.Up, credits.VsPrevious.Direction())
+
+	assert.Equal(t, trend.Down, trend.Change{Diff: -0.01}.Direction())
+	assert.Equal(t, trend.Flat, trend.Change{Diff: 0.004}.Direction())
+}
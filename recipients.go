@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/db"
+	"stori-technical-challenge/pkg/outbox"
+	"stori-technical-challenge/pkg/pipeline"
+	"time"
+)
+
+const recipientsUsage = "usage: %s recipients add [-account a] [-role to|cc|bcc] [-locale l] [-frequency daily|weekly|monthly] EMAIL" +
+	" | list [-account a] | remove|opt-out|opt-in [-account a] EMAIL"
+
+// runRecipientsCommand implements "recipients", which manages who receives
+// each account's summaries. "list" prints recipients as JSON, one per line.
+func runRecipientsCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf(recipientsUsage, os.Args[0])
+	}
+	command := args[0]
+	switch command {
+	case "add", "list", "remove", "opt-out", "opt-in":
+	default:
+		return fmt.Errorf(recipientsUsage, os.Args[0])
+	}
+
+	account := os.Getenv("ACCOUNT")
+	if account == "" {
+		account = config.DefaultAccount
+	}
+	fs := flag.NewFlagSet("recipients "+command, flag.ExitOnError)
+	fs.StringVar(&account, "account", account, "account the recipient belongs to (overrides $ACCOUNT)")
+	role := fs.String("role", string(db.RoleTo), "with add: to, cc or bcc")
+	locale := fs.String("locale", db.DefaultLocale, "with add: language of the summary")
+	frequency := fs.String("frequency", string(db.Daily), "with add: daily, weekly or monthly")
+	fs.Parse(args[1:])
+
+	if err := db.InitDB(); err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	ctx := context.Background()
+
+	if command == "list" {
+		// An explicit -account lists that account; otherwise list them all
+		listed := ""
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "account" {
+				listed = account
+			}
+		})
+		recipients, err := db.GetRecipients(ctx, listed)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(out)
+		for _, r := range recipients {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf(recipientsUsage, os.Args[0])
+	}
+	address := fs.Arg(0)
+
+	var err error
+	switch command {
+	case "add":
+		err = db.SaveRecipient(ctx, db.Recipient{
+			Account:   account,
+			Email:     address,
+			Role:      db.RecipientRole(*role),
+			Locale:    *locale,
+			Frequency: db.Frequency(*frequency),
+		})
+	case "remove":
+		err = db.DeleteRecipient(ctx, account, address)
+	default:
+		err = db.SetOptOut(ctx, account, address, command == "opt-out")
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("account %q has no recipient %s", account, address)
+	}
+	return err
+}
+
+// summaryMessages addresses the summary of statement to the recipients of
+// the configured account and returns the IDs of those notified right away.
+// Recipients that are due at now get the summary in their locale, one
+// message per locale; the first message is always there, so it identifies
+// the statement even when nobody is due and it is stored as skipped.
+// Recipients that aren't due, because their frequency hasn't let them hear
+// from the account again yet, get a digest part each instead, held until
+// they are due and then sent along with the rest of their digest. An
+// account without recipients falls back to to_email, in English.
+func summaryMessages(ctx context.Context, statement []byte, report *pipeline.Report, now time.Time) ([]db.OutboxMessage, []int64, error) {
+	account := config.AppConfig.Account
+	recipients, err := db.GetRecipients(ctx, account)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var attachments []db.OutboxAttachment
+	for _, a := range report.Attachments() {
+		attachments = append(attachments, db.OutboxAttachment{Filename: a.Filename, ContentType: a.ContentType, ContentID: a.ContentID, Data: a.Data})
+	}
+	if len(recipients) == 0 {
+		if config.AppConfig.ToEmail == "" {
+			return nil, nil, fmt.Errorf("account %q has no recipients and to_email is not set", account)
+		}
+		return []db.OutboxMessage{{
+			DedupKey:    fallbackKey(statement, account, config.AppConfig.ToEmail),
+			To:          []string{config.AppConfig.ToEmail},
+			Subject:     report.Subject(),
+			Body:        report.Body,
+			Attachments: attachments,
+		}}, nil, nil
+	}
+
+	// localized renders the summary once per locale
+	rendered := make(map[string]db.OutboxMessage)
+	localized := func(locale string) (db.OutboxMessage, error) {
+		if msg, ok := rendered[locale]; ok {
+			return msg, nil
+		}
+		subject, body, err := report.Localized(locale)
+		if err != nil {
+			return db.OutboxMessage{}, err
+		}
+		rendered[locale] = db.OutboxMessage{Subject: subject, Body: body, Attachments: attachments}
+		return rendered[locale], nil
+	}
+
+	digests, err := db.DigestRecipients(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var summaries, parts []db.OutboxMessage
+	byLocale := make(map[string]int)
+	var notified []int64
+	for _, r := range recipients {
+		if r.OptedOut {
+			continue
+		}
+		msg, err := localized(r.Locale)
+		if err != nil {
+			return nil, nil, err
+		}
+		// A recipient whose digest is waiting gets this summary in it, so
+		// they don't hear about the account twice
+		if !r.DueAt(now) || digests[r.ID] {
+			msg.DedupKey = outbox.StatementKey(statement, "digest:"+account+":"+r.Email)
+			msg.RecipientID = r.ID
+			msg.NextAttemptAt = r.NextDue()
+			if r.Role == db.RoleBcc {
+				msg.Bcc = []string{r.Email}
+			} else {
+				msg.To = []string{r.Email}
+			}
+			parts = append(parts, msg)
+			continue
+		}
+
+		i, ok := byLocale[r.Locale]
+		if !ok {
+			msg.DedupKey = outbox.StatementKey(statement, "account:"+account+":"+r.Locale)
+			i, byLocale[r.Locale] = len(summaries), len(summaries)
+			summaries = append(summaries, msg)
+		}
+		switch r.Role {
+		case db.RoleCc:
+			summaries[i].Cc = append(summaries[i].Cc, r.Email)
+		case db.RoleBcc:
+			summaries[i].Bcc = append(summaries[i].Bcc, r.Email)
+		default:
+			summaries[i].To = append(summaries[i].To, r.Email)
+		}
+		notified = append(notified, r.ID)
+	}
+
+	if len(summaries) == 0 {
+		summaries = append(summaries, db.OutboxMessage{Subject: report.Subject(), Body: report.Body, Attachments: attachments})
+	}
+	// The first message is keyed by account alone, as before summaries
+	// were localized
+	summaries[0].DedupKey = outbox.StatementKey(statement, "account:"+account)
+	for i := range summaries {
+		// Cc recipients alone are addressed directly; Bcc recipients
+		// alone get a message without a To header
+		if len(summaries[i].To) == 0 {
+			summaries[i].To, summaries[i].Cc = summaries[i].Cc, nil
+		}
+	}
+	return append(summaries, parts...), notified, nil
+}
+
+// recipientsHandler serves the recipients API:
+//
+//	GET    /recipients?account=a            list recipients (all without account)
+//	POST   /recipients                      add or update the recipient in the JSON body
+//	PATCH  /recipients                      set opted_out for {"account", "email", "opted_out"}
+//	DELETE /recipients?account=a&email=e    remove a recipient
+func recipientsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		switch r.Method {
+		case http.MethodGet:
+			recipients, err := db.GetRecipients(ctx, r.URL.Query().Get("account"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if recipients == nil {
+				recipients = []db.Recipient{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(recipients)
+
+		case http.MethodPost, http.MethodPatch:
+			var recipient db.Recipient
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&recipient); err != nil {
+				http.Error(w, fmt.Sprintf("invalid recipient: %v", err), http.StatusBadRequest)
+				return
+			}
+			var err error
+			if r.Method == http.MethodPost {
+				if err = recipient.Validate(); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				err = db.SaveRecipient(ctx, recipient)
+			} else {
+				err = db.SetOptOut(ctx, recipient.Account, recipient.Email, recipient.OptedOut)
+			}
+			writeRecipientResult(w, err)
+
+		case http.MethodDelete:
+			query := r.URL.Query()
+			writeRecipientResult(w, db.DeleteRecipient(ctx, query.Get("account"), query.Get("email")))
+
+		default:
+			w.Header().Set("Allow", "GET, POST, PATCH, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func writeRecipientResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "recipient not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// fallbackKey is the dedup key of a statement of account sent to the
+// to_email address. Each account has its own, so accounts sharing the
+// fallback are each notified. The default account keeps the key used
+// before accounts existed, so statements imported back then aren't
+// imported and notified again.
+func fallbackKey(statement []byte, account, address string) string {
+	if account == config.DefaultAccount {
+		return outbox.StatementKey(statement, address)
+	}
+	return outbox.StatementKey(statement, "to_email:"+account+":"+address)
+}
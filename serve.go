@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/logging"
+	"stori-technical-challenge/pkg/metrics"
+	"stori-technical-challenge/pkg/tracing"
+	"syscall"
+	"time"
+)
+
+// runServeCommand implements "serve": it runs the import on a fixed
+// interval, starting immediately, and exposes Prometheus metrics on
+// /metrics and exports on /export/, and the recipients API on a separate
+// admin listener, until it receives SIGINT or SIGTERM. A failed run is
+// logged and counted but doesn't stop the server.
+func runServeCommand(args []string) error {
+	addr := os.Getenv("METRICS_ADDR")
+	if addr == "" {
+		addr = ":9090"
+	}
+	adminAddr, ok := os.LookupEnv("ADMIN_ADDR")
+	if !ok {
+		adminAddr = defaultAdminAddr
+	}
+
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFlags := config.BindFlags(fs)
+	fs.StringVar(&addr, "metrics-addr", addr, "address to serve /metrics on (overrides $METRICS_ADDR)")
+	fs.StringVar(&adminAddr, "admin-addr", adminAddr, "address to serve the /recipients and /export/ APIs on, empty to disable (overrides $ADMIN_ADDR)")
+	adminTokenFile := fs.String("admin-token-file", os.Getenv("ADMIN_TOKEN_FILE"), "file holding the bearer token the admin API requires (overrides $ADMIN_TOKEN_FILE)")
+	interval := fs.Duration("interval", 24*time.Hour, "time between runs")
+	retryInterval := fs.Duration("retry-interval", time.Minute, "time between attempts to send queued emails")
+	applyTemplateFlag := bindTemplateFlag(fs)
+	fs.Parse(args)
+	applyTemplateFlag()
+
+	if *interval <= 0 || *retryInterval <= 0 {
+		return fmt.Errorf("invalid interval %s", min(*interval, *retryInterval))
+	}
+	adminToken, err := readAdminToken(*adminTokenFile)
+	if err != nil {
+		return err
+	}
+	if adminAddr != "" {
+		if err := checkAdminAddr(adminAddr, adminToken); err != nil {
+			return err
+		}
+	}
+
+	base := logging.FromEnv()
+	slog.SetDefault(base)
+
+	if err := initializeApp(configFlags); err != nil {
+		return fmt.Errorf("failed to initialize application: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Options{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			base.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	servers := []*http.Server{{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}}
+	if adminAddr != "" {
+		servers = append(servers, &http.Server{Addr: adminAddr, Handler: adminHandler(adminToken), ReadHeaderTimeout: 10 * time.Second})
+	}
+
+	serveErr := make(chan error, len(servers))
+	base.Info("serving metrics", "addr", addr, "admin_addr", adminAddr, "admin_auth", adminToken != "", "interval", interval.String())
+	for _, server := range servers {
+		go func() { serveErr <- server.ListenAndServe() }()
+	}
+
+	// Retry queued emails between runs
+	sender := newSender(base)
+	defer sender.Close()
+	go newDispatcher(sender, base).Run(ctx, *retryInterval)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		runOnce(ctx, base)
+
+		select {
+		case <-ticker.C:
+		case err := <-serveErr:
+			return err
+		case <-ctx.Done():
+			base.Info("shutting down")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for _, server := range servers {
+				if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// runOnce runs the import with its own correlation ID.
+func runOnce(ctx context.Context, base *slog.Logger) {
+	logger := logging.WithCorrelationID(base, logging.NewCorrelationID())
+	start := time.Now()
+	if err := processAndSendTransactions(ctx, logger); err != nil {
+		logger.Error("failed to process transactions", "outcome", "failure", "error", err, "duration_ms", logging.Since(start))
+		return
+	}
+	logger.Info("run finished", "outcome", "success", "duration_ms", logging.Since(start))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"stori-technical-challenge/config"
+	"stori-technical-challenge/pkg/statement"
+	"stori-technical-challenge/pkg/transactions"
+)
+
+// runStatementCommand implements "statement", which writes the PDF
+// statement for a transactions file without importing it or sending email.
+func runStatementCommand(args []string) error {
+	account := os.Getenv("ACCOUNT")
+	fs := flag.NewFlagSet("statement", flag.ExitOnError)
+	fs.StringVar(&account, "account", account, "account printed on the statement (overrides $ACCOUNT)")
+	output := fs.String("o", statement.Filename, "file to write the PDF to")
+	openingBalance := fs.Float64("opening-balance", 0, "balance before the first transaction")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s statement [flags] [file]\n\nfile defaults to %s.\n\n", os.Args[0], FilePath)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("expected at most one file, got %d", fs.NArg())
+	}
+	source := FilePath
+	if fs.NArg() == 1 {
+		source = fs.Arg(0)
+	}
+
+	result, err := transactions.NewProcessor(transactions.DefaultCSVReader{}).Process(context.Background(), source)
+	if err != nil {
+		return err
+	}
+	pdf, err := statement.Render(statement.Data{
+		Account:        statementAccount(account),
+		Source:         filepath.Base(source),
+		OpeningBalance: *openingBalance,
+		Transactions:   result.Transactions,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*output, pdf, 0o644); err != nil {
+		return fmt.Errorf("writing statement: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "statement for %d transactions written to %s\n", len(result.Transactions), *output)
+	return nil
+}
+
+// statementAccount is the account name printed on statements. The default
+// account is left off: it only means no account was configured.
+func statementAccount(account string) string {
+	if account == config.DefaultAccount {
+		return ""
+	}
+	return account
+}
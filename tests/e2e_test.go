@@ -1,12 +1,15 @@
 package tests
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"stori-technical-challenge/config"
 	"stori-technical-challenge/pkg/db"
 	"stori-technical-challenge/pkg/email"
 	"stori-technical-challenge/pkg/transactions"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -38,7 +41,7 @@ func TestE2E(t *testing.T) {
 		SMTPHost:     os.Getenv("SMTP_HOST"),
 		SMTPPort:     1025, // Convertir el puerto de cadena a entero
 		SMTPUser:     os.Getenv("SMTP_USER"),
-		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		SMTPPassword: config.Secret(os.Getenv("SMTP_PASSWORD")),
 		FromEmail:    os.Getenv("FROM_EMAIL"),
 	}
 
@@ -52,11 +55,11 @@ func TestE2E(t *testing.T) {
 	reader := transactions.DefaultCSVReader{}
 	processor := transactions.NewProcessor(reader)
 
-	totalBalance, summary, avgDebit, avgCredit, err := processor.ProcessTransactions(filePath)
+	totalBalance, summary, avgDebit, avgCredit, err := processor.ProcessTransactions(context.Background(), filePath)
 	assert.NoError(t, err, "Error processing transactions")
 
 	// Guardar todas las transacciones individuales en la base de datos
-	err = db.SaveTransactionsFromCSV(filePath)
+	err = db.SaveTransactionsFromCSV(context.Background(), filePath)
 	assert.NoError(t, err, "Error saving transactions from CSV")
 
 	emailData := email.EmailData{
@@ -70,12 +73,13 @@ func TestE2E(t *testing.T) {
 		emailData.NumTransactions[month] = data.NumTransactions
 	}
 
-	body, err := email.LoadTemplate("email_template_test.html", emailData)
-	assert.NoError(t, err, "Error loading email template")
+	// The layout itself is covered by the golden files in pkg/email
+	body, err := email.DefaultTemplates.Render(email.SummaryTemplate, emailData)
+	assert.NoError(t, err, "Error rendering email template")
 
 	// Omitir el envío de correo en la prueba
 	// emailSender := email.SMTPSender{}
-	// err = emailSender.SendEmail(subject, body, "recipient@example.com")
+	// err = emailSender.SendEmail(context.Background(), email.NewMessage("recipient@example.com", subject, body))
 	// assert.NoError(t, err, "Error sending email")
 
 	// Verificar que las transacciones se guardaron en la base de datos
@@ -83,14 +87,17 @@ func TestE2E(t *testing.T) {
 	assert.NoError(t, err, "Error retrieving transactions")
 	assert.NotEmpty(t, transactions, "No transactions found")
 
+	// Las fechas del CSV no tienen año, se asume el año actual
+	year := time.Now().Year()
+
 	lastTransaction := transactions[len(transactions)-1]
-	assert.Equal(t, "2024-08-13", lastTransaction.Date, "Last transaction date does not match")
+	assert.Equal(t, fmt.Sprintf("%d-08-13", year), lastTransaction.Date, "Last transaction date does not match")
 	assert.Equal(t, 10.0, lastTransaction.Amount, "Last transaction amount does not match")
 
 	// Verificar detalles del email
-	assert.Contains(t, body, "Total balance is 39.74", "Email body does not contain correct total balance")
-	assert.Contains(t, body, "Number of transactions in 2024-07: 2", "Email body does not contain correct transaction count for July")
-	assert.Contains(t, body, "Number of transactions in 2024-08: 2", "Email body does not contain correct transaction count for August")
-	assert.Contains(t, body, "Average debit amount: -15.38", "Email body does not contain correct average debit amount")
-	assert.Contains(t, body, "Average credit amount: 35.25", "Email body does not contain correct average credit amount")
+	assert.Contains(t, body, "Total balance is $39.74", "Email body does not contain correct total balance")
+	assert.Contains(t, body, fmt.Sprintf("Number of transactions in %d-07: 2", year), "Email body does not contain correct transaction count for July")
+	assert.Contains(t, body, fmt.Sprintf("Number of transactions in %d-08: 2", year), "Email body does not contain correct transaction count for August")
+	assert.Contains(t, body, "Average debit amount: -$15.38", "Email body does not contain correct average debit amount")
+	assert.Contains(t, body, "Average credit amount: $35.25", "Email body does not contain correct average credit amount")
 }